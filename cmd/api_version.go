@@ -0,0 +1,94 @@
+package cmd
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/spf13/viper"
+
+	"github.com/jake-scott/apim-tools/internal/pkg/logging"
+)
+
+// Management API versions look like 2021-08-01 or 2021-01-01-preview
+var apiVersionRegexp = regexp.MustCompile(`^\d{4}-\d{2}-\d{2}(-preview)?$`)
+
+// A management API operation and the oldest API version that supports it
+type apiOperation struct {
+	name       string
+	minVersion string
+}
+
+var (
+	apiOpInstance = apiOperation{"read the API Manager instance", "2019-01-01"}
+	apiOpSasToken = apiOperation{"vend SAS tokens", "2019-01-01"}
+	apiOpContent  = apiOperation{"manage developer portal content", "2019-12-01"}
+	apiOpMedia    = apiOperation{"access developer portal media storage", "2019-12-01"}
+)
+
+// Select the management API version for a command: --api-version if given,
+// else the default version, raised to the newest minimum version of the
+// operations the command performs.  The version must support every
+// operation the command performs.
+func selectAPIVersion(ops ...apiOperation) (string, error) {
+	version := viper.GetString("api-version")
+	if version == "" {
+		version = azureAPIVersion
+		for _, op := range ops {
+			c, err := compareAPIVersions(version, op.minVersion)
+			if err != nil {
+				return "", err
+			}
+
+			if c < 0 {
+				version = op.minVersion
+			}
+		}
+	}
+
+	for _, op := range ops {
+		c, err := compareAPIVersions(version, op.minVersion)
+		if err != nil {
+			return "", err
+		}
+
+		if c < 0 {
+			return "", fmt.Errorf("API version %s cannot %s, version %s or later is required", version, op.name, op.minVersion)
+		}
+	}
+
+	logging.Logger().Debugf("Using management API version %s", version)
+
+	return version, nil
+}
+
+// Compare two management API versions, returning -1, 0 or 1 if a is older,
+// the same or newer than b.  A preview is older than the release of the
+// same date.
+func compareAPIVersions(a, b string) (int, error) {
+	for _, v := range []string{a, b} {
+		if !apiVersionRegexp.MatchString(v) {
+			return 0, fmt.Errorf("bad API version %q, expected YYYY-MM-DD or YYYY-MM-DD-preview", v)
+		}
+	}
+
+	// The dates compare correctly as strings
+	switch {
+	case a[:10] < b[:10]:
+		return -1, nil
+	case a[:10] > b[:10]:
+		return 1, nil
+	}
+
+	aPreview := strings.HasSuffix(a, "-preview")
+	bPreview := strings.HasSuffix(b, "-preview")
+
+	switch {
+	case aPreview && !bPreview:
+		return -1, nil
+	case !aPreview && bPreview:
+		return 1, nil
+	}
+
+	return 0, nil
+}
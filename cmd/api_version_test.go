@@ -0,0 +1,69 @@
+package cmd
+
+import (
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+func TestCompareAPIVersions(t *testing.T) {
+	tests := []struct {
+		a, b      string
+		want      int
+		expectErr bool
+	}{
+		{"2019-12-01", "2019-12-01", 0, false},
+		{"2019-12-01", "2021-08-01", -1, false},
+		{"2021-08-01", "2019-12-01", 1, false},
+		{"2021-01-01-preview", "2021-01-01", -1, false},
+		{"2021-01-01", "2021-01-01-preview", 1, false},
+		{"2021-01-01-preview", "2020-12-01", 1, false},
+		{"2021-01-01-preview", "2021-01-01-preview", 0, false},
+		{"2021-08", "2019-12-01", 0, true},
+		{"2019-12-01", "latest", 0, true},
+	}
+
+	for _, tt := range tests {
+		got, err := compareAPIVersions(tt.a, tt.b)
+		if tt.expectErr {
+			if err == nil {
+				t.Errorf("Expected error comparing %q and %q", tt.a, tt.b)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("Comparing %q and %q: %s", tt.a, tt.b, err)
+		} else if got != tt.want {
+			t.Errorf("Comparing %q and %q: got %d, wanted %d", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func TestSelectAPIVersion(t *testing.T) {
+	defer viper.Set("api-version", nil)
+
+	viper.Set("api-version", "")
+	if v, err := selectAPIVersion(apiOpContent); err != nil || v != azureAPIVersion {
+		t.Errorf("Default version: got %q, %v", v, err)
+	}
+
+	// The default is raised to what the operations need
+	newer := apiOperation{"do something new", "2021-08-01"}
+	if v, err := selectAPIVersion(apiOpContent, newer); err != nil || v != "2021-08-01" {
+		t.Errorf("Raised default version: got %q, %v", v, err)
+	}
+
+	viper.Set("api-version", "2019-12-01")
+	if _, err := selectAPIVersion(apiOpContent, newer); err == nil {
+		t.Errorf("Expected error forcing a version older than an operation needs")
+	}
+	if v, err := selectAPIVersion(apiOpInstance, apiOpContent); err != nil || v != "2019-12-01" {
+		t.Errorf("Forced version: got %q, %v", v, err)
+	}
+
+	viper.Set("api-version", "2019-01-01")
+	if _, err := selectAPIVersion(apiOpInstance, apiOpContent); err == nil {
+		t.Errorf("Expected error forcing a version older than the content API")
+	}
+}
@@ -0,0 +1,14 @@
+package cmd
+
+import (
+	"github.com/spf13/cobra"
+)
+
+var apimCmd = &cobra.Command{
+	Use:   "apim",
+	Short: "API Manager instance operations",
+}
+
+func init() {
+	rootCmd.AddCommand(apimCmd)
+}
@@ -0,0 +1,194 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+
+	"github.com/jake-scott/apim-tools/internal/pkg/logging"
+	"github.com/jake-scott/apim-tools/pkg/apim"
+)
+
+var apimListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List the API Manager instances in the subscription",
+	Long: `List the API Manager instances in the subscription, or in every
+subscription the credentials can access with --all-subscriptions.`,
+
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if err := doApimList(cmd.Context()); err != nil {
+			return err
+		}
+
+		return nil
+	},
+}
+
+func init() {
+	apimListCmd.Flags().BoolVar(&portalCmdOpts.allSubscriptions, "all-subscriptions", false, "List instances in all accessible subscriptions")
+
+	bindFlag(apimListCmd, "all-subscriptions", "all-subscriptions")
+
+	apimCmd.AddCommand(apimListCmd)
+}
+
+// Summary of an API Manager instance
+type apimInstance struct {
+	Name                string            `json:"name"`
+	Subscription        string            `json:"subscription"`
+	ResourceGroup       string            `json:"resource_group"`
+	Location            string            `json:"location"`
+	Sku                 string            `json:"sku"`
+	Capacity            int               `json:"capacity"`
+	ProvisioningState   string            `json:"provisioning_state"`
+	GatewayHostnames    []string          `json:"gateway_hostnames"`
+	PortalHostnames     []string          `json:"portal_hostnames"`
+	ManagementHostnames []string          `json:"management_hostnames"`
+	Tags                map[string]string `json:"tags"`
+}
+
+func doApimList(ctx context.Context) error {
+	apiVersion, err := selectAPIVersion(apiOpInstance)
+	if err != nil {
+		return err
+	}
+
+	cli, err := newAzureClient(apiVersion)
+	if err != nil {
+		return err
+	}
+
+	subs := []string{currentSubscription()}
+	if viper.GetBool("all-subscriptions") {
+		subs, err = listSubscriptions(ctx, cli, azureManagementEndpoint())
+		if err != nil {
+			return err
+		}
+	} else if subs[0] == "" {
+		return errNoSubscription
+	}
+
+	instances, err := listApimInstances(ctx, cli, azureManagementEndpoint(), subs)
+	if err != nil {
+		return err
+	}
+
+	return writeResult(instances, func(w io.Writer) error {
+		tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "NAME\tRESOURCE GROUP\tLOCATION\tSKU\tSTATE\tGATEWAY")
+		for _, i := range instances {
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s/%d\t%s\t%s\n", i.Name, i.ResourceGroup, i.Location,
+				i.Sku, i.Capacity, i.ProvisioningState, strings.Join(i.GatewayHostnames, ","))
+		}
+		return tw.Flush()
+	})
+}
+
+// List the instances in the subscriptions, sorted by subscription, resource
+// group and name.  Subscriptions that can't be listed are skipped when there
+// is more than one.
+func listApimInstances(ctx context.Context, cli *apim.ResourceManagerClient, armURL string, subs []string) ([]apimInstance, error) {
+	instances := []apimInstance{}
+
+	for _, sub := range subs {
+		listURL := fmt.Sprintf("%s/subscriptions/%s/providers/Microsoft.ApiManagement/service", armURL, sub)
+
+		err := armList(ctx, cli, listURL, func(item json.RawMessage) error {
+			var d apim.InstanceDetails
+			if err := json.Unmarshal(item, &d); err != nil {
+				return err
+			}
+			instances = append(instances, apimInstanceFromDetails(&d))
+			return nil
+		})
+		if err != nil {
+			if len(subs) > 1 && ctx.Err() == nil {
+				logging.Logger().WithError(err).Warnf("Skipping subscription %s", sub)
+				continue
+			}
+			return nil, fmt.Errorf("listing API Manager instances in subscription %s: %s", sub, err)
+		}
+	}
+
+	sort.Slice(instances, func(i, j int) bool {
+		a, b := instances[i], instances[j]
+		if a.Subscription != b.Subscription {
+			return a.Subscription < b.Subscription
+		}
+		if !strings.EqualFold(a.ResourceGroup, b.ResourceGroup) {
+			return strings.ToLower(a.ResourceGroup) < strings.ToLower(b.ResourceGroup)
+		}
+		return a.Name < b.Name
+	})
+
+	return instances, nil
+}
+
+// Summarise the instance details.  The hostnames are the default ones
+// followed by any custom hostnames.
+func apimInstanceFromDetails(d *apim.InstanceDetails) apimInstance {
+	i := apimInstance{
+		Name:              d.Name,
+		Location:          d.Location,
+		Sku:               d.Sku.Name,
+		Capacity:          d.Sku.Capacity,
+		ProvisioningState: d.Properties.ProvisioningState,
+		Tags:              d.Tags,
+	}
+
+	// /subscriptions/<sub>/resourceGroups/<rg>/providers/...
+	parts := strings.Split(d.ID, "/")
+	if len(parts) > 4 {
+		i.Subscription = parts[2]
+		i.ResourceGroup = parts[4]
+	}
+
+	i.GatewayHostnames = appendHostname(i.GatewayHostnames, urlHostname(d.Properties.GatewayURL))
+	i.PortalHostnames = appendHostname(i.PortalHostnames, urlHostname(d.Properties.PortalURL))
+	i.ManagementHostnames = appendHostname(i.ManagementHostnames, urlHostname(d.Properties.MgmtURL))
+
+	for _, entry := range d.Properties.HostnameConfigurations {
+		switch entry.Type {
+		case "Proxy":
+			i.GatewayHostnames = appendHostname(i.GatewayHostnames, entry.Hostname)
+		case "DeveloperPortal":
+			i.PortalHostnames = appendHostname(i.PortalHostnames, entry.Hostname)
+		case "Management":
+			i.ManagementHostnames = appendHostname(i.ManagementHostnames, entry.Hostname)
+		}
+	}
+
+	return i
+}
+
+func urlHostname(s string) string {
+	u, err := url.Parse(s)
+	if err != nil {
+		return ""
+	}
+
+	return u.Hostname()
+}
+
+// Append a hostname to the list unless it is empty or already there
+func appendHostname(list []string, host string) []string {
+	if host == "" {
+		return list
+	}
+
+	for _, h := range list {
+		if strings.EqualFold(h, host) {
+			return list
+		}
+	}
+
+	return append(list, host)
+}
@@ -0,0 +1,91 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/Azure/go-autorest/autorest"
+
+	"github.com/jake-scott/apim-tools/pkg/apim"
+)
+
+const apimListTestResponse = `{"value": [
+	{
+		"id": "/subscriptions/sub-a/resourceGroups/apis-prod/providers/Microsoft.ApiManagement/service/apis-prod",
+		"name": "apis-prod",
+		"location": "UK South",
+		"tags": {"env": "prod"},
+		"sku": {"name": "Premium", "capacity": 2},
+		"properties": {
+			"provisioningState": "Succeeded",
+			"gatewayUrl": "https://apis-prod.azure-api.net",
+			"developerPortalUrl": "https://apis-prod.developer.azure-api.net",
+			"managementApiUrl": "https://apis-prod.management.azure-api.net",
+			"hostnameConfigurations": [
+				{"type": "Proxy", "hostName": "apis-prod.azure-api.net"},
+				{"type": "Proxy", "hostName": "api.example.com"},
+				{"type": "DeveloperPortal", "hostName": "developer.example.com"}
+			]
+		}
+	},
+	{
+		"id": "/subscriptions/sub-a/resourceGroups/Apis-Dev/providers/Microsoft.ApiManagement/service/apis-dev",
+		"name": "apis-dev",
+		"location": "UK South",
+		"sku": {"name": "Developer", "capacity": 1},
+		"properties": {"provisioningState": "Activating"}
+	}
+]}`
+
+func TestListApimInstances(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/subscriptions/sub-a/providers/Microsoft.ApiManagement/service" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		fmt.Fprint(w, apimListTestResponse)
+	}))
+	defer srv.Close()
+
+	cli := apim.NewResourceManagerClient(autorest.NullAuthorizer{}, azureAPIVersion, nil)
+
+	instances, err := listApimInstances(context.Background(), cli, srv.URL, []string{"sub-a"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(instances) != 2 {
+		t.Fatalf("Got %d instances, wanted 2", len(instances))
+	}
+
+	// Sorted by resource group
+	dev, prod := instances[0], instances[1]
+	if dev.Name != "apis-dev" || dev.ResourceGroup != "Apis-Dev" || dev.ProvisioningState != "Activating" {
+		t.Errorf("Got first instance %+v", dev)
+	}
+
+	if prod.Subscription != "sub-a" || prod.Sku != "Premium" || prod.Capacity != 2 || prod.Tags["env"] != "prod" {
+		t.Errorf("Got second instance %+v", prod)
+	}
+
+	checks := []struct {
+		name      string
+		got, want []string
+	}{
+		{"gateway", prod.GatewayHostnames, []string{"apis-prod.azure-api.net", "api.example.com"}},
+		{"portal", prod.PortalHostnames, []string{"apis-prod.developer.azure-api.net", "developer.example.com"}},
+		{"management", prod.ManagementHostnames, []string{"apis-prod.management.azure-api.net"}},
+	}
+	for _, c := range checks {
+		if fmt.Sprint(c.got) != fmt.Sprint(c.want) {
+			t.Errorf("Got %s hostnames %v, wanted %v", c.name, c.got, c.want)
+		}
+	}
+
+	if _, err := listApimInstances(context.Background(), cli, srv.URL, []string{"sub-b"}); err == nil {
+		t.Errorf("Expected error listing an unreadable subscription")
+	}
+}
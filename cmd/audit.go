@@ -0,0 +1,53 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/jake-scott/apim-tools/internal/pkg/auth"
+	"github.com/jake-scott/apim-tools/internal/pkg/logging"
+)
+
+// Record an audit event for an operation that changes an instance.  counts
+// is the operation's result summary.
+func auditOperation(op, target string, counts interface{}, err error) {
+	e := logging.AuditEvent{
+		Operation: op,
+		Target:    target,
+		Principal: auditPrincipal(),
+		Counts:    counts,
+	}
+
+	switch {
+	case err == nil:
+		e.Result = logging.AuditSucceeded
+	case errors.Is(err, errInterrupted) || errors.Is(err, context.Canceled):
+		e.Result = logging.AuditInterrupted
+	default:
+		e.Result = logging.AuditFailed
+		e.Error = err.Error()
+	}
+
+	logging.Audit(e)
+}
+
+// The Azure identity running the command, from its access token if possible
+func auditPrincipal() string {
+	p, err := auth.WhoAmI()
+	if err != nil {
+		logging.Logger().WithError(err).Debugf("Cannot identify principal for audit event")
+
+		if cfg := auth.Get(); cfg != nil && cfg.ClientID != "" {
+			return fmt.Sprintf("client %s (%s)", cfg.ClientID, auth.Method())
+		}
+		return auth.Method()
+	}
+
+	name := p.Name
+	if name == "" {
+		name = "client " + p.ClientID
+	}
+
+	return fmt.Sprintf("%s (object %s, tenant %s)", name, p.ObjectID, p.TenantID)
+}
@@ -0,0 +1,18 @@
+package cmd
+
+import (
+	"github.com/spf13/cobra"
+)
+
+var authCmd = &cobra.Command{
+	Use:   "auth",
+	Short: "Azure authentication diagnostics",
+	Long: `Azure authentication diagnostics.
+
+Shows which credentials apim-tools is using and checks that they grant the
+access needed to work with an API Manager instance.`,
+}
+
+func init() {
+	rootCmd.AddCommand(authCmd)
+}
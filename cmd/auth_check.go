@@ -0,0 +1,171 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/jake-scott/apim-tools/internal/pkg/auth"
+	"github.com/jake-scott/apim-tools/pkg/apim"
+)
+
+// Validity of the SAS token vended to check the permission, which is not used
+const probeTokenValidity = time.Minute
+
+var authTestCmd = &cobra.Command{
+	Use:   "test",
+	Short: "Check the Azure identity can manage an API Manager instance",
+	Long: `Check the Azure identity can manage an API Manager instance.
+
+Displays the identity in use, then checks that it can read the instance and
+vend the SAS tokens used for developer portal operations.  Missing
+permissions are reported along with the role that grants them.`,
+
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if err := doAuthTest(cmd.Context()); err != nil {
+			return err
+		}
+
+		return nil
+	},
+}
+
+func init() {
+	authTestCmd.Flags().StringVar(&portalCmdOpts.apimName, "apim", "", "API Manager instance")
+	authTestCmd.Flags().StringVar(&portalCmdOpts.resourceGroup, "rg", "", "Resource group containing the APIM instance")
+	authTestCmd.Flags().StringVar(&portalCmdOpts.instanceID, "id", "", "Resource ID of the APIM instance, instead of --apim and --rg")
+	authTestCmd.Flags().BoolVar(&portalCmdOpts.allSubscriptions, "all-subscriptions", false, "Search all accessible subscriptions for --apim")
+
+	bindFlag(authTestCmd, "apim", "apim")
+	bindFlag(authTestCmd, "rg", "rg")
+	bindFlag(authTestCmd, "id", "id")
+	bindFlag(authTestCmd, "all-subscriptions", "all-subscriptions")
+
+	authCmd.AddCommand(authTestCmd)
+}
+
+// The outcome of one access check
+type authCheck struct {
+	Check   string `json:"check"`
+	OK      bool   `json:"ok"`
+	Message string `json:"message,omitempty"`
+}
+
+type authTestResult struct {
+	Principal *auth.Principal `json:"principal"`
+	Checks    []authCheck     `json:"checks"`
+}
+
+func doAuthTest(ctx context.Context) error {
+	if err := checkInstanceConfig(); err != nil {
+		return err
+	}
+
+	p, err := auth.WhoAmI()
+	if err != nil {
+		return err
+	}
+
+	apiVersion, err := selectAPIVersion(apiOpInstance, apiOpSasToken)
+	if err != nil {
+		return err
+	}
+
+	cli, err := newAzureClient(apiVersion)
+	if err != nil {
+		return err
+	}
+
+	id, err := resolveInstanceID(ctx, cli)
+	if err != nil {
+		return err
+	}
+	instanceURL := azureManagementEndpoint() + id
+
+	result := authTestResult{Principal: p}
+
+	// Read the instance
+	check := authCheck{Check: "read instance"}
+	resp, err := cli.Get(ctx, instanceURL)
+	if err == nil {
+		resp.Body.Close()
+		check.OK, check.Message = explainAccess(resp.StatusCode, p, id,
+			"read the API Manager instance", "API Management Service Reader Role")
+	} else {
+		check.Message = err.Error()
+	}
+	result.Checks = append(result.Checks, check)
+
+	// Vend a short lived Administrator SAS token, as the portal commands do
+	check = authCheck{Check: "vend SAS token"}
+	opts := apim.DefaultTokenOptions()
+	opts.Expiry = time.Now().Add(probeTokenValidity)
+	_, err = apim.SasToken(ctx, cli, instanceURL, opts)
+	var serr *apim.StatusError
+	switch {
+	case err == nil:
+		check.OK = true
+	case errors.As(err, &serr):
+		check.OK, check.Message = explainAccess(serr.StatusCode, p, id,
+			"vend SAS tokens for the instance", "API Management Service Contributor")
+	default:
+		check.Message = err.Error()
+	}
+	result.Checks = append(result.Checks, check)
+
+	err = writeResult(result, func(w io.Writer) error {
+		writePrincipal(w, p)
+		fmt.Fprintln(w)
+		for _, c := range result.Checks {
+			status := "OK"
+			if !c.OK {
+				status = "FAILED"
+			}
+			fmt.Fprintf(w, "%s: %s\n", c.Check, status)
+			if c.Message != "" {
+				fmt.Fprintf(w, "  %s\n", c.Message)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, c := range result.Checks {
+		if !c.OK {
+			return fmt.Errorf("access checks failed")
+		}
+	}
+
+	return nil
+}
+
+// Explain a management API response status in terms of the permissions the
+// principal needs
+func explainAccess(status int, p *auth.Principal, id, action, role string) (bool, string) {
+	who := p.ObjectID
+	if p.Name != "" {
+		who = fmt.Sprintf("%s (%s)", p.Name, p.ObjectID)
+	}
+
+	switch {
+	case status < 300:
+		return true, ""
+	case status == http.StatusUnauthorized:
+		return false, fmt.Sprintf("The access token was rejected.  Check that tenant %s owns subscription %s.",
+			p.TenantID, p.SubscriptionID)
+	case status == http.StatusForbidden:
+		return false, fmt.Sprintf("%s is not allowed to %s.  Grant it the \"%s\" role, or an equivalent, on the instance or its resource group.",
+			who, action, role)
+	case status == http.StatusNotFound:
+		return false, fmt.Sprintf("Instance %s was not found.  Check the subscription, resource group and instance name.", id)
+	}
+
+	return false, fmt.Sprintf("Unable to %s: status %d (%s) received", action, status, http.StatusText(status))
+}
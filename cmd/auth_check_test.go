@@ -0,0 +1,34 @@
+package cmd
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/jake-scott/apim-tools/internal/pkg/auth"
+)
+
+func TestExplainAccess(t *testing.T) {
+	p := &auth.Principal{ObjectID: "0b5f2b3c", TenantID: "72f988bf", Name: "jo@example.com"}
+
+	tests := []struct {
+		status int
+		ok     bool
+		want   string
+	}{
+		{200, true, ""},
+		{401, false, "tenant 72f988bf"},
+		{403, false, `jo@example.com (0b5f2b3c) is not allowed to read the instance.  Grant it the "Reader" role`},
+		{404, false, "was not found"},
+		{500, false, "status 500 (Internal Server Error)"},
+	}
+
+	for _, tt := range tests {
+		ok, msg := explainAccess(tt.status, p, "/subscriptions/s/resourceGroups/rg/providers/Microsoft.ApiManagement/service/apim", "read the instance", "Reader")
+		if ok != tt.ok {
+			t.Errorf("Status %d: got ok %t, wanted %t", tt.status, ok, tt.ok)
+		}
+		if !strings.Contains(msg, tt.want) {
+			t.Errorf("Status %d: got message %q, wanted it to contain %q", tt.status, msg, tt.want)
+		}
+	}
+}
@@ -0,0 +1,56 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/jake-scott/apim-tools/internal/pkg/auth"
+)
+
+var authWhoamiCmd = &cobra.Command{
+	Use:   "whoami",
+	Short: "Display the Azure identity in use",
+
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if err := doAuthWhoami(); err != nil {
+			return err
+		}
+
+		return nil
+	},
+}
+
+func init() {
+	authCmd.AddCommand(authWhoamiCmd)
+}
+
+func doAuthWhoami() error {
+	p, err := auth.WhoAmI()
+	if err != nil {
+		return err
+	}
+
+	return writeResult(p, func(w io.Writer) error {
+		writePrincipal(w, p)
+		return nil
+	})
+}
+
+// Write the principal details in the text format
+func writePrincipal(w io.Writer, p *auth.Principal) {
+	fmt.Fprintf(w, "          Method: %s\n", p.Method)
+	fmt.Fprintf(w, "     Environment: %s\n", p.Environment)
+	fmt.Fprintf(w, "          Tenant: %s\n", p.TenantID)
+	fmt.Fprintf(w, "    Subscription: %s\n", p.SubscriptionID)
+	if p.ClientID != "" {
+		fmt.Fprintf(w, "       Client ID: %s\n", p.ClientID)
+	}
+	if p.Name != "" {
+		fmt.Fprintf(w, "            Name: %s\n", p.Name)
+	}
+	fmt.Fprintf(w, "       Object ID: %s\n", p.ObjectID)
+	fmt.Fprintf(w, "Token expires at: %s\n", p.TokenExpiry.Local().Format(time.RFC822))
+}
@@ -0,0 +1,14 @@
+package cmd
+
+import (
+	"github.com/spf13/cobra"
+)
+
+var configCmd = &cobra.Command{
+	Use:   "config",
+	Short: "Configuration operations",
+}
+
+func init() {
+	rootCmd.AddCommand(configCmd)
+}
@@ -0,0 +1,128 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/spf13/cast"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var configShowCmd = &cobra.Command{
+	Use:   "show",
+	Short: "Display the effective configuration",
+	Long: `Display the effective configuration, after merging the config file, the
+selected profile, environment variables and command line options.
+
+Each setting is shown with where its value came from.  Secrets are redacted.`,
+	Annotations: map[string]string{skipAuthAnnotation: "true"},
+
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if err := doConfigShow(cmd); err != nil {
+			return err
+		}
+
+		return nil
+	},
+}
+
+func init() {
+	configCmd.AddCommand(configShowCmd)
+}
+
+const redacted = "[redacted]"
+
+// A config setting and where its value came from
+type configSetting struct {
+	Key    string `json:"key"`
+	Value  string `json:"value"`
+	Source string `json:"source"`
+}
+
+func doConfigShow(cmd *cobra.Command) error {
+	// The config file on its own, to tell file settings from defaults
+	file := viper.New()
+	if f := viper.ConfigFileUsed(); f != "" {
+		file.SetConfigFile(f)
+		if err := file.ReadInConfig(); err != nil {
+			return err
+		}
+	}
+
+	secret := make(map[string]bool)
+	for _, key := range secretConfigKeys {
+		secret[key] = true
+	}
+
+	settings := []configSetting{}
+	for _, key := range viper.AllKeys() {
+		if strings.HasPrefix(key, "profiles.") {
+			continue
+		}
+
+		s := configSetting{
+			Key:    key,
+			Value:  configValueString(viper.Get(key)),
+			Source: configSource(cmd, file, key),
+		}
+		if secret[key] && s.Value != "" {
+			s.Value = redacted
+		}
+
+		settings = append(settings, s)
+	}
+
+	sort.Slice(settings, func(i, j int) bool { return settings[i].Key < settings[j].Key })
+
+	return writeResult(settings, func(w io.Writer) error {
+		tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "KEY\tVALUE\tSOURCE")
+		for _, s := range settings {
+			fmt.Fprintf(tw, "%s\t%s\t%s\n", s.Key, s.Value, s.Source)
+		}
+		return tw.Flush()
+	})
+}
+
+// Work out where a setting came from, in viper's order of precedence
+func configSource(cmd *cobra.Command, file *viper.Viper, key string) string {
+	for _, b := range globalFlagKeys {
+		if b.key == key && cmd.Flags().Changed(b.flag) {
+			return "flag --" + b.flag
+		}
+	}
+
+	env := configEnvName(key)
+	if _, ok := os.LookupEnv(env); ok {
+		return "env " + env
+	}
+
+	if p := viper.GetString("profile"); p != "" && file.IsSet("profiles."+p+"."+key) {
+		return "profile " + p
+	}
+
+	if file.IsSet(key) {
+		return "config " + file.ConfigFileUsed()
+	}
+
+	return "default"
+}
+
+// The environment variable that sets key
+func configEnvName(key string) string {
+	return envPrefix + "_" + strings.ToUpper(envKeyReplacer.Replace(key))
+}
+
+func configValueString(v interface{}) string {
+	switch v.(type) {
+	case []string, []interface{}:
+		return strings.Join(cast.ToStringSlice(v), ",")
+	}
+
+	return cast.ToString(v)
+}
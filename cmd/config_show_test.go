@@ -0,0 +1,27 @@
+package cmd
+
+import (
+	"testing"
+)
+
+func TestConfigEnvName(t *testing.T) {
+	tests := map[string]string{
+		"auth.client-id":                        "APIM_TOOLS_AUTH_CLIENT_ID",
+		"profile":                               "APIM_TOOLS_PROFILE",
+		"self-hosted.storage-connection-string": "APIM_TOOLS_SELF_HOSTED_STORAGE_CONNECTION_STRING",
+	}
+
+	for key, want := range tests {
+		if got := configEnvName(key); got != want {
+			t.Errorf("Key %s: got %s, wanted %s", key, got, want)
+		}
+	}
+}
+
+func TestGlobalFlagKeys(t *testing.T) {
+	for _, b := range globalFlagKeys {
+		if rootCmd.PersistentFlags().Lookup(b.flag) == nil {
+			t.Errorf("Key %s is bound to missing flag --%s", b.key, b.flag)
+		}
+	}
+}
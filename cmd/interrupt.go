@@ -0,0 +1,50 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/jake-scott/apim-tools/internal/pkg/logging"
+)
+
+// Returned by commands that stopped early because they were interrupted
+var errInterrupted = errors.New("interrupted")
+
+// Return a context that is cancelled when the user presses Ctrl-C or the
+// process is asked to terminate, so that commands can stop cleanly.  A
+// second signal exits immediately.  The returned function stops listening
+// for signals.
+func signalContext() (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	sigs := make(chan os.Signal, 2)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+
+	go func() {
+		select {
+		case sig := <-sigs:
+			logging.Logger().Warnf("Received %s, stopping (repeat to exit immediately)", sig)
+			cancel()
+		case <-ctx.Done():
+			return
+		}
+
+		<-sigs
+		fmt.Fprintln(os.Stderr, "Exiting immediately")
+		os.Exit(130)
+	}()
+
+	return ctx, func() {
+		signal.Stop(sigs)
+		cancel()
+	}
+}
+
+// Report whether the command was interrupted
+func interrupted(ctx context.Context) bool {
+	return errors.Is(ctx.Err(), context.Canceled)
+}
@@ -0,0 +1,24 @@
+package cmd
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestInterrupted(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if !interrupted(ctx) {
+		t.Errorf("Cancelled context not reported as interrupted")
+	}
+
+	ctx, cancel = context.WithTimeout(context.Background(), time.Nanosecond)
+	defer cancel()
+	<-ctx.Done()
+
+	if interrupted(ctx) {
+		t.Errorf("Timed out context reported as interrupted")
+	}
+}
@@ -0,0 +1,35 @@
+package cmd
+
+import (
+	"io"
+	"os"
+
+	"github.com/spf13/viper"
+
+	"github.com/jake-scott/apim-tools/internal/pkg/output"
+)
+
+// The format chosen with --output, set up by doConfigure
+var outputFormat output.Format
+
+// Parse the --output option.  The older --json option is still honoured.
+func configureOutput() (err error) {
+	spec := viper.GetString("output")
+	if viper.GetBool("json") {
+		spec = output.JSON
+	}
+
+	outputFormat, err = output.Parse(spec)
+	return err
+}
+
+// Write a command's result to stdout in the chosen format, using text to
+// render the human readable version
+func writeResult(v interface{}, text output.TextFunc) error {
+	return writeResultTo(os.Stdout, v, text)
+}
+
+// Write a command's result to w, eg. stderr when stdout carries an archive
+func writeResultTo(w io.Writer, v interface{}, text output.TextFunc) error {
+	return outputFormat.Write(w, v, text)
+}
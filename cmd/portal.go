@@ -2,13 +2,49 @@ package cmd
 
 import (
 	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
 )
 
 var portalCmd = &cobra.Command{
 	Use:   "devportal",
 	Short: "API Manager Developer Portal operations",
+	Long: `API Manager Developer Portal operations.
+
+By default the operations act on the managed developer portal hosted by the
+API Manager instance.  Use --self-hosted to act on a self-hosted developer
+portal, whose media and static website live in a storage account of its own.`,
+}
+
+// Self-hosted portal options
+var selfHostedOpts struct {
+	enabled                 bool
+	storageConnectionString string
+	mediaSasURL             string
+	mediaContainer          string
+	websiteSasURL           string
+	websiteContainer        string
+	portalURL               string
 }
 
 func init() {
+	flags := portalCmd.PersistentFlags()
+	flags.BoolVar(&selfHostedOpts.enabled, "self-hosted", false, "Operate on a self-hosted developer portal")
+	flags.StringVar(&selfHostedOpts.storageConnectionString, "storage-connection-string", "", "Self-hosted portal storage account connection string")
+	flags.StringVar(&selfHostedOpts.mediaSasURL, "media-sas-url", "", "Self-hosted portal media container SAS URL")
+	flags.StringVar(&selfHostedOpts.mediaContainer, "media-container", "content", "Self-hosted portal media container name")
+	flags.StringVar(&selfHostedOpts.websiteSasURL, "website-sas-url", "", "Self-hosted portal static website container SAS URL")
+	flags.StringVar(&selfHostedOpts.websiteContainer, "website-container", "$web", "Self-hosted portal static website container name")
+	flags.StringVar(&selfHostedOpts.portalURL, "portal-url", "", "Self-hosted portal URL")
+	flags.StringVar(&portalCmdOpts.apiVersion, "api-version", "", "Management API version (default "+azureAPIVersion+", or newer if a command requires it)")
+
+	errPanic(viper.BindPFlag("self-hosted.enabled", flags.Lookup("self-hosted")))
+	errPanic(viper.BindPFlag("self-hosted.storage-connection-string", flags.Lookup("storage-connection-string")))
+	errPanic(viper.BindPFlag("self-hosted.media-sas-url", flags.Lookup("media-sas-url")))
+	errPanic(viper.BindPFlag("self-hosted.media-container", flags.Lookup("media-container")))
+	errPanic(viper.BindPFlag("self-hosted.website-sas-url", flags.Lookup("website-sas-url")))
+	errPanic(viper.BindPFlag("self-hosted.website-container", flags.Lookup("website-container")))
+	errPanic(viper.BindPFlag("self-hosted.portal-url", flags.Lookup("portal-url")))
+	errPanic(viper.BindPFlag("api-version", flags.Lookup("api-version")))
+
 	rootCmd.AddCommand(portalCmd)
 }
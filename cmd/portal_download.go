@@ -2,18 +2,16 @@ package cmd
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
-	"io/ioutil"
-	"net/url"
-	"strings"
+	"io"
+	"os"
 
-	"github.com/Azure/azure-storage-blob-go/azblob"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 
-	"github.com/jake-scott/apim-tools/internal/pkg/devportal"
 	"github.com/jake-scott/apim-tools/internal/pkg/logging"
+	"github.com/jake-scott/apim-tools/pkg/apim"
+	"github.com/jake-scott/apim-tools/pkg/devportal"
 )
 
 var portalDownloadCmd = &cobra.Command{
@@ -21,7 +19,7 @@ var portalDownloadCmd = &cobra.Command{
 	Short: "Download the APIM developer portal content to a ZIP archive",
 
 	RunE: func(cmd *cobra.Command, args []string) error {
-		if err := doPortalDownload(); err != nil {
+		if err := doPortalDownload(cmd.Context()); err != nil {
 			return err
 		}
 
@@ -31,175 +29,146 @@ var portalDownloadCmd = &cobra.Command{
 
 func init() {
 	portalDownloadCmd.Flags().StringVar(&portalCmdOpts.apimName, "apim", "", "API Manager instance")
-	portalDownloadCmd.Flags().StringVar(&portalCmdOpts.backupFile, "out", "", "Output archive")
+	portalDownloadCmd.Flags().StringVar(&portalCmdOpts.backupFile, "out", "", "Output archive, or - for stdout")
 	portalDownloadCmd.Flags().StringVar(&portalCmdOpts.resourceGroup, "rg", "", "Resource group containing the APIM instance")
+	portalDownloadCmd.Flags().StringVar(&portalCmdOpts.instanceID, "id", "", "Resource ID of the APIM instance, instead of --apim and --rg")
+	portalDownloadCmd.Flags().BoolVar(&portalCmdOpts.allSubscriptions, "all-subscriptions", false, "Search all accessible subscriptions for --apim")
 	portalDownloadCmd.Flags().BoolVarP(&portalCmdOpts.force, "force", "f", false, "Overwrite existing archive")
+	portalDownloadCmd.Flags().BoolVar(&portalCmdOpts.resume, "resume", false, "Continue an interrupted download, keeping the media already in the archive")
+	portalDownloadCmd.Flags().IntVar(&portalCmdOpts.compression, "compression", 0, "Deflate compression level (1-9) of the archive, 0 to store uncompressed")
 
-	errPanic(portalDownloadCmd.MarkFlagRequired("apim"))
 	errPanic(portalDownloadCmd.MarkFlagRequired("out"))
-	errPanic(portalDownloadCmd.MarkFlagRequired("rg"))
 
-	errPanic(viper.GetViper().BindPFlag("apim", portalDownloadCmd.Flags().Lookup("apim")))
-	errPanic(viper.GetViper().BindPFlag("out", portalDownloadCmd.Flags().Lookup("out")))
-	errPanic(viper.GetViper().BindPFlag("rg", portalDownloadCmd.Flags().Lookup("rg")))
-	errPanic(viper.GetViper().BindPFlag("force", portalDownloadCmd.Flags().Lookup("force")))
+	bindFlag(portalDownloadCmd, "apim", "apim")
+	bindFlag(portalDownloadCmd, "out", "out")
+	bindFlag(portalDownloadCmd, "rg", "rg")
+	bindFlag(portalDownloadCmd, "id", "id")
+	bindFlag(portalDownloadCmd, "all-subscriptions", "all-subscriptions")
+	bindFlag(portalDownloadCmd, "force", "force")
+	bindFlag(portalDownloadCmd, "resume", "resume")
+	bindFlag(portalDownloadCmd, "compression", "compression")
 
 	portalCmd.AddCommand(portalDownloadCmd)
 }
 
-func doPortalDownload() error {
-	info, err := buildApimInfo(azureAPIVersion)
-	if err != nil {
-		return err
-	}
-
-	// Create a ZIP archive
-	aw, err := devportal.NewArchiveWriter(viper.GetString("out"))
-	if err != nil {
-		return err
-	}
-	defer aw.Close()
+// Summary of a download
+type downloadResult struct {
+	Archive string `json:"archive"`
+	apim.DownloadResult
+	Interrupted bool `json:"interrupted,omitempty"`
+}
 
-	// run the download
-	if err := getPortalContentItems(aw, info.apimClient, info.apimMgmtURL); err != nil {
+func doPortalDownload(ctx context.Context) error {
+	result := downloadResult{Archive: viper.GetString("out")}
+	if err := checkStdioArchive(result.Archive); err != nil {
 		return err
 	}
 
-	return downloadPortalBlobs(aw, info.devPortalBlobStorageURL)
-}
-
-func downloadPortalBlobs(aw *devportal.ArchiveWriter, blobURLString string) error {
-	logging.Logger().Infof("Downloading media...")
-
-	u, _ := url.Parse(blobURLString)
-	ctx := context.Background()
-	containerURL := azblob.NewContainerURL(*u, azblob.NewPipeline(azblob.NewAnonymousCredential(), azblob.PipelineOptions{}))
-
-	var cOK, cErr int
-
-	for marker := (azblob.Marker{}); marker.NotDone(); {
-		listBlobs, err := containerURL.ListBlobsFlatSegment(ctx, marker, azblob.ListBlobsSegmentOptions{})
-		if err != nil {
-			return err
-		}
-
-		marker = listBlobs.NextMarker
-
-		for _, blobInfo := range listBlobs.Segment.BlobItems {
-			logging.Logger().Debugf("Found blob: %s", blobInfo.Name)
-
-			blobURL := containerURL.NewBlobURL(blobInfo.Name)
-
-			if err := aw.AddBlob(blobURL); err != nil {
-				logging.Logger().WithError(err).Errorf("Writing BLOB %s", blobInfo.Name)
-				cErr++
-			} else {
-				cOK++
-			}
+	// The result is reported on stderr when stdout carries the archive
+	out := os.Stdout
+	if result.Archive == stdioArchive {
+		if viper.GetString("logging.location") == "stdout" {
+			return fmt.Errorf("cannot write the archive to stdout while logging to stdout")
 		}
+		out = os.Stderr
 	}
 
-	logging.Logger().Infof("  -> Total %d blobs, %d errors", cOK, cErr)
-
-	return nil
-}
-
-func getPortalContentItems(aw *devportal.ArchiveWriter, cli *apimClient, mgmtURL string) error {
-	logging.Logger().Infof("Processing content items...")
-
-	// Get content types used by the portal
-	contentTypes, err := getContentTypes(cli, mgmtURL)
-	if err != nil {
-		return err
+	opts := devportal.WriterOptions{
+		Compression: viper.GetInt("compression"),
+		Logger:      logging.Logger(),
 	}
-
-	// Get content items for each content type
-	var contentItems = make([]interface{}, 0, 200)
-	for _, ct := range contentTypes {
-		subItems, err := getContentItems(cli, mgmtURL, ct)
-		if err != nil {
-			return err
-		}
-
-		contentItems = append(contentItems, subItems...)
-		logging.Logger().Infof("  -> %d %s items", len(subItems), ct)
+	if viper.GetBool("force") {
+		opts.Overwrite = devportal.OverwriteAlways
 	}
 
-	// Write data.json
-	data, err := json.Marshal(contentItems)
+	client, err := newPortalClient(ctx, apiOpContent)
 	if err != nil {
 		return err
 	}
 
-	if err := aw.AddContentItems(data); err != nil {
+	aw, j, err := openArchiveWriter(result.Archive, client.Endpoints().InstanceURL, opts)
+	if err != nil {
 		return err
 	}
-
-	logging.Logger().Infof("  -> Total %d items", len(contentItems))
-
-	return nil
-}
-
-// Get a list of supported content types from the portal
-func getContentTypes(cli *apimClient, mgmtURL string) ([]string, error) {
-	reqURL := fmt.Sprintf("%s/contentTypes", apimMgmtURL(mgmtURL))
-	resp, err := cli.Get(reqURL)
-	if err != nil {
-		return nil, err
+	defer aw.Close()
+	if j != nil {
+		defer j.Close()
 	}
 
-	// Only accept HTTP 2xx codes
-	if resp.StatusCode >= 300 {
-		return nil, fmt.Errorf("status %s received", resp.Status)
+	// run the download
+	result.DownloadResult, err = client.DownloadArchive(ctx, aw)
+	if err != nil && !interrupted(ctx) {
+		return err
 	}
+	result.Interrupted = interrupted(ctx)
 
-	// Grab the body
-	respBody, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		return nil, err
+	// Make sure the archive is complete before reporting success.  An
+	// interrupted download still leaves a readable archive.
+	if err := aw.Close(); err != nil {
+		return err
 	}
 
-	ctResp := apimPortalContentTypesResponse{}
-	if err := json.Unmarshal(respBody, &ctResp); err != nil {
-		return nil, err
-	}
+	finishJournal(j, result.Interrupted || result.Blobs.Errors > 0)
 
-	// Extract the IDs minus the /contentTypes/ prefix
-	types := make([]string, 0, 10)
-	for _, ct := range ctResp.Value {
-		s := strings.TrimPrefix(ct.ID, "/contentTypes/")
-		types = append(types, s)
+	err = writeResultTo(out, result, func(w io.Writer) error {
+		fmt.Fprintf(w, "      Archive: %s\n", result.Archive)
+		fmt.Fprintf(w, "Content items: %d\n", result.ContentItems)
+		fmt.Fprintf(w, "  Media blobs: %s\n", result.Blobs)
+		if result.Interrupted {
+			fmt.Fprintf(w, "  Interrupted: the archive is incomplete\n")
+		}
+		return nil
+	})
+	if err == nil && result.Interrupted {
+		err = errInterrupted
 	}
 
-	logging.Logger().Debugf("Content types: %s", types)
-	return types, nil
+	return err
 }
 
-// Get a list of content items for a given content type
-func getContentItems(cli *apimClient, mgmtURL string, contentType string) ([]interface{}, error) {
-	reqURL := fmt.Sprintf("%s/contentTypes/%s/contentItems", apimMgmtURL(mgmtURL), contentType)
-	resp, err := cli.Get(reqURL)
-	if err != nil {
-		return nil, err
+// Create the archive, or write it to stdout if archive is -.  A file is
+// journalled so an interrupted download can be resumed, carrying over the
+// blobs of an earlier run.
+func openArchiveWriter(archive, target string, opts devportal.WriterOptions) (*devportal.ArchiveWriter, *devportal.Journal, error) {
+	if archive == stdioArchive {
+		aw, err := devportal.NewArchiveWriterTo(os.Stdout, opts)
+		return aw, nil, err
 	}
 
-	// Only accept HTTP 2xx codes
-	if resp.StatusCode >= 300 {
-		return nil, fmt.Errorf("status %s received", resp.Status)
+	// Starting afresh would lose the journal of an interrupted download
+	if !viper.GetBool("resume") && opts.Overwrite != devportal.OverwriteAlways {
+		path := devportal.JournalName(archive, "download")
+		if _, err := os.Stat(path); err == nil {
+			return nil, nil, fmt.Errorf("%s was left by an interrupted download.  Use --resume to continue it, or --force to start again", path)
+		}
 	}
 
-	// Grab the body
-	respBody, err := ioutil.ReadAll(resp.Body)
+	j, err := openJournal(archive, "download", target)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
-	ciResp := apimPortalContentItemsResponse{}
-	if err := json.Unmarshal(respBody, &ciResp); err != nil {
-		return nil, err
+	var aw *devportal.ArchiveWriter
+	if j != nil {
+		aw, err = devportal.ResumeArchiveWriter(archive, j, opts)
+	} else {
+		aw, err = devportal.NewArchiveWriter(archive, opts)
 	}
+	if err != nil {
+		// Keep the items done by an earlier run, but not a journal of nothing
+		if j != nil {
+			if j.Resumed() {
+				j.Close()
+			} else if rerr := j.Remove(); rerr != nil {
+				logging.Logger().WithError(rerr).Warnf("Removing journal")
+			}
+		}
 
-	logging.Logger().Debugf("%d %s items found", len(ciResp.Value), contentType)
+		if os.IsExist(err) {
+			err = fmt.Errorf("%s.  Use --force to overwrite existing file", err)
+		}
+		return nil, nil, err
+	}
 
-	return ciResp.Value, nil
+	return aw, j, nil
 }
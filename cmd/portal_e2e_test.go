@@ -0,0 +1,335 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/Azure/go-autorest/autorest"
+
+	"github.com/jake-scott/apim-tools/internal/pkg/apimfake"
+	"github.com/jake-scott/apim-tools/pkg/devportal"
+)
+
+// Start a fake instance and point the portal commands at it.  The last
+// instance started is the one used.
+func fakeInstance(t *testing.T) *apimfake.Server {
+	f := apimfake.NewServer()
+
+	resourceManager.endpoint = f.ResourceManagerURL()
+	resourceManager.authorizer = autorest.NullAuthorizer{}
+
+	t.Cleanup(func() {
+		f.Close()
+
+		resourceManager.endpoint = ""
+		resourceManager.authorizer = nil
+	})
+
+	return f
+}
+
+// Run a devportal command on the fake instance, as the apim-tools binary
+// does
+func runPortalCommand(t *testing.T, command string, args ...string) error {
+	args = append([]string{"devportal", command,
+		"--config", writeTestConfig(t, ""),
+		"--id", apimfake.InstanceID,
+		"--progress", "none"}, args...)
+
+	return runCommand(context.Background(), args...)
+}
+
+// Fill a portal with some pages and media
+func seedPortal(t *testing.T, f *apimfake.Server) {
+	items := map[string]map[string]interface{}{
+		"/contentTypes/page/contentItems/home": {
+			"properties": map[string]interface{}{"title": "Home", "permalink": "/"},
+		},
+		"/contentTypes/page/contentItems/guide": {
+			"properties": map[string]interface{}{"title": "Guide", "permalink": "/guide"},
+		},
+		"/contentTypes/layout/contentItems/default": {
+			"properties": map[string]interface{}{"title": "Default", "permalinkTemplate": "/"},
+		},
+		"/contentTypes/blob/contentItems/logo": {
+			"properties": map[string]interface{}{"fileName": "logo.png", "blobKey": "logo.png"},
+		},
+	}
+
+	for id, item := range items {
+		if err := f.PutContentItem(id, item); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	f.PutBlob("logo.png", []byte("not really a PNG"))
+	f.PutBlob("docs/guide.pdf", []byte(strings.Repeat("guide ", 1000)))
+}
+
+func TestPortalDownloadUpload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "apim-e2e")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	archive := filepath.Join(dir, "portal.zip")
+
+	src := fakeInstance(t)
+	seedPortal(t, src)
+
+	if err := runPortalCommand(t, "download", "--out", archive); err != nil {
+		t.Fatalf("Downloading: %s", err)
+	}
+
+	if _, err := os.Stat(devportal.JournalName(archive, "download")); !os.IsNotExist(err) {
+		t.Errorf("Journal of a complete download was kept: %v", err)
+	}
+
+	// Upload to another portal with content of its own, which is replaced
+	dst := fakeInstance(t)
+	if err := dst.PutContentItem("/contentTypes/page/contentItems/old", map[string]interface{}{}); err != nil {
+		t.Fatal(err)
+	}
+	dst.PutBlob("old.png", []byte("old"))
+
+	if err := runPortalCommand(t, "upload", "--in", archive); err != nil {
+		t.Fatalf("Uploading: %s", err)
+	}
+
+	if got, want := dst.ContentItems(), src.ContentItems(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Got content items %v, wanted %v", got, want)
+	}
+	if got, want := dst.Blobs(), src.Blobs(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Got blobs %v, wanted %v", got, want)
+	}
+}
+
+// The journal of an interrupted download is kept until it is resumed or the
+// download started again with --force
+func TestPortalDownloadJournal(t *testing.T) {
+	dir, err := ioutil.TempDir("", "apim-e2e")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	archive := filepath.Join(dir, "portal.zip")
+	journal := devportal.JournalName(archive, "download")
+
+	f := fakeInstance(t)
+	seedPortal(t, f)
+
+	if err := runPortalCommand(t, "download", "--out", archive); err != nil {
+		t.Fatalf("Downloading: %s", err)
+	}
+
+	// An archive that exists is not replaced, and no journal is left behind
+	err = runPortalCommand(t, "download", "--out", archive)
+	if err == nil || !strings.Contains(err.Error(), "--force") {
+		t.Errorf("Expected an error downloading over an archive, got %v", err)
+	}
+	if _, err := os.Stat(journal); !os.IsNotExist(err) {
+		t.Errorf("Journal left by a download that did not start: %v", err)
+	}
+
+	// As left by an interrupted download
+	j, err := devportal.OpenJournal(journal, f.ResourceManagerURL()+apimfake.InstanceID, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := j.Record(devportal.JournalEntry{Kind: devportal.JournalBlob, Name: "logo.png"}); err != nil {
+		t.Fatal(err)
+	}
+	j.Close()
+
+	before, err := ioutil.ReadFile(journal)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = runPortalCommand(t, "download", "--out", archive)
+	if err == nil || !strings.Contains(err.Error(), "--resume") {
+		t.Errorf("Expected an error downloading over an interrupted download, got %v", err)
+	}
+	if after, err := ioutil.ReadFile(journal); err != nil || !bytes.Equal(after, before) {
+		t.Errorf("Journal of the interrupted download changed: %v", err)
+	}
+
+	if err := runPortalCommand(t, "download", "--out", archive, "--force"); err != nil {
+		t.Fatalf("Downloading again with --force: %s", err)
+	}
+	if _, err := os.Stat(journal); !os.IsNotExist(err) {
+		t.Errorf("Journal of a complete download was kept: %v", err)
+	}
+}
+
+// An archive in a read-only directory, eg. a mounted build artifact, is
+// uploaded without a journal
+func TestPortalUploadReadOnly(t *testing.T) {
+	dir, err := ioutil.TempDir("", "apim-e2e")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	archive := filepath.Join(dir, "portal.zip")
+
+	src := fakeInstance(t)
+	seedPortal(t, src)
+
+	if err := runPortalCommand(t, "download", "--out", archive); err != nil {
+		t.Fatalf("Downloading: %s", err)
+	}
+
+	// root can write to a read-only directory, but not to a file that is a
+	// directory
+	journal := devportal.JournalName(archive, "upload")
+	if err := os.Mkdir(journal, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(dir, 0555); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chmod(dir, 0755) //nolint:errcheck
+
+	dst := fakeInstance(t)
+	if err := runPortalCommand(t, "upload", "--in", archive); err != nil {
+		t.Fatalf("Uploading: %s", err)
+	}
+	if got, want := dst.Blobs(), src.Blobs(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Got blobs %v, wanted %v", got, want)
+	}
+
+	// Resuming needs the journal
+	if err := runPortalCommand(t, "upload", "--in", archive, "--resume"); err == nil {
+		t.Errorf("Expected an error resuming without a journal")
+	}
+}
+
+// Download to stdout and upload from stdin, as in download | ssh | upload
+func TestPortalPipe(t *testing.T) {
+	dir, err := ioutil.TempDir("", "apim-e2e")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	pipe, err := os.Create(filepath.Join(dir, "pipe"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pipe.Close()
+
+	stdin, stdout := os.Stdin, os.Stdout
+	defer func() { os.Stdin, os.Stdout = stdin, stdout }()
+
+	src := fakeInstance(t)
+	seedPortal(t, src)
+
+	os.Stdout = pipe
+	err = runPortalCommand(t, "download", "--out", "-", "--compression", "9")
+	os.Stdout = stdout
+	if err != nil {
+		t.Fatalf("Downloading: %s", err)
+	}
+
+	if _, err := os.Stat(devportal.JournalName("-", "download")); !os.IsNotExist(err) {
+		t.Errorf("Download to stdout was journalled: %v", err)
+	}
+
+	dst := fakeInstance(t)
+
+	if _, err := pipe.Seek(0, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+	os.Stdin = pipe
+	if err := runPortalCommand(t, "upload", "--in", "-"); err != nil {
+		t.Fatalf("Uploading: %s", err)
+	}
+
+	if got, want := dst.ContentItems(), src.ContentItems(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Got content items %v, wanted %v", got, want)
+	}
+	if got, want := dst.Blobs(), src.Blobs(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Got blobs %v, wanted %v", got, want)
+	}
+
+	if err := runPortalCommand(t, "upload", "--in", "-", "--resume"); err == nil {
+		t.Errorf("Expected an error resuming an upload from stdin")
+	}
+}
+
+func TestPortalUploadVersionMismatch(t *testing.T) {
+	dir, err := ioutil.TempDir("", "apim-e2e")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	archive := filepath.Join(dir, "portal.zip")
+
+	src := fakeInstance(t)
+	seedPortal(t, src)
+
+	if err := runPortalCommand(t, "download", "--out", archive); err != nil {
+		t.Fatalf("Downloading: %s", err)
+	}
+
+	dst := fakeInstance(t)
+	dst.SetCodeVersion("20200101000000")
+
+	err = runPortalCommand(t, "upload", "--in", archive)
+	if err == nil || !strings.Contains(err.Error(), "newer than target") {
+		t.Fatalf("Expected version mismatch uploading to an older portal, got %v", err)
+	}
+	if n := len(dst.ContentItems()); n != 0 {
+		t.Errorf("Refused upload left %d content items", n)
+	}
+
+	if err := runPortalCommand(t, "upload", "--in", archive, "--allow-version-mismatch"); err != nil {
+		t.Fatalf("Uploading with --allow-version-mismatch: %s", err)
+	}
+	if got, want := len(dst.ContentItems()), len(src.ContentItems()); got != want {
+		t.Errorf("Uploaded %d content items, wanted %d", got, want)
+	}
+}
+
+func TestPortalReset(t *testing.T) {
+	f := fakeInstance(t)
+	seedPortal(t, f)
+
+	if err := runPortalCommand(t, "reset"); err != nil {
+		t.Fatalf("Resetting: %s", err)
+	}
+
+	if items := f.ContentItems(); len(items) != 0 {
+		t.Errorf("Content items left after reset: %v", items)
+	}
+	if blobs := f.Blobs(); len(blobs) != 0 {
+		t.Errorf("Blobs left after reset: %v", blobs)
+	}
+}
+
+func TestPortalPublish(t *testing.T) {
+	for _, wait := range []bool{false, true} {
+		f := fakeInstance(t)
+
+		if err := runPortalCommand(t, "publish", fmt.Sprintf("--wait=%t", wait)); err != nil {
+			t.Errorf("Publishing with wait %t: %s", wait, err)
+			continue
+		}
+
+		if n := f.Publishes(); n != 1 {
+			t.Errorf("Publishing with wait %t: portal published %d times", wait, n)
+		}
+	}
+}
@@ -1,11 +1,11 @@
 package cmd
 
 import (
-	"encoding/json"
+	"context"
 	"fmt"
+	"io"
 
 	"github.com/spf13/cobra"
-	"github.com/spf13/viper"
 )
 
 var portalEndpointsCmd = &cobra.Command{
@@ -13,7 +13,7 @@ var portalEndpointsCmd = &cobra.Command{
 	Short: "Display the API Manager Developer Portal endpoints",
 
 	RunE: func(cmd *cobra.Command, args []string) error {
-		if err := doPortalEndpoints(); err != nil {
+		if err := doPortalEndpoints(cmd.Context()); err != nil {
 			return err
 		}
 
@@ -24,14 +24,15 @@ var portalEndpointsCmd = &cobra.Command{
 func init() {
 	portalEndpointsCmd.Flags().StringVar(&portalCmdOpts.apimName, "apim", "", "API Manager instance")
 	portalEndpointsCmd.Flags().StringVar(&portalCmdOpts.resourceGroup, "rg", "", "Resource group containing the APIM instance")
-	portalEndpointsCmd.Flags().BoolVar(&portalCmdOpts.asJSON, "json", false, "Return results as JSON")
+	portalEndpointsCmd.Flags().StringVar(&portalCmdOpts.instanceID, "id", "", "Resource ID of the APIM instance, instead of --apim and --rg")
+	portalEndpointsCmd.Flags().BoolVar(&portalCmdOpts.allSubscriptions, "all-subscriptions", false, "Search all accessible subscriptions for --apim")
+	portalEndpointsCmd.Flags().BoolVar(&portalCmdOpts.asJSON, "json", false, "Return results as JSON (same as --output json)")
 
-	errPanic(portalEndpointsCmd.MarkFlagRequired("apim"))
-	errPanic(portalEndpointsCmd.MarkFlagRequired("rg"))
-
-	errPanic(viper.GetViper().BindPFlag("apim", portalEndpointsCmd.Flags().Lookup("apim")))
-	errPanic(viper.GetViper().BindPFlag("rg", portalEndpointsCmd.Flags().Lookup("rg")))
-	errPanic(viper.GetViper().BindPFlag("json", portalEndpointsCmd.Flags().Lookup("json")))
+	bindFlag(portalEndpointsCmd, "apim", "apim")
+	bindFlag(portalEndpointsCmd, "rg", "rg")
+	bindFlag(portalEndpointsCmd, "id", "id")
+	bindFlag(portalEndpointsCmd, "all-subscriptions", "all-subscriptions")
+	bindFlag(portalEndpointsCmd, "json", "json")
 
 	portalCmd.AddCommand(portalEndpointsCmd)
 }
@@ -42,30 +43,23 @@ type endpointsInfo struct {
 	ApimMgmtURL             string `json:"management_url"`
 }
 
-func doPortalEndpoints() error {
-	info, err := buildApimInfo(azureAPIVersion)
+func doPortalEndpoints(ctx context.Context) error {
+	client, err := newPortalClient(ctx)
 	if err != nil {
 		return err
 	}
 
-	if viper.GetBool("json") {
-		ep := endpointsInfo{
-			DevPortalBlobStorageURL: info.devPortalBlobStorageURL,
-			DevPortalURL:            info.devPortalURL,
-			ApimMgmtURL:             info.apimMgmtURL,
-		}
-
-		b, err := json.MarshalIndent(ep, "", "    ")
-		if err != nil {
-			return err
-		}
-
-		fmt.Println(string(b))
-	} else {
-		fmt.Printf("Developer portal URL: %s\n", info.devPortalURL)
-		fmt.Printf("      Management URL: %s\n", info.apimMgmtURL)
-		fmt.Printf("    Blob storage URL: %s\n", info.devPortalBlobStorageURL)
+	e := client.Endpoints()
+	ep := endpointsInfo{
+		DevPortalBlobStorageURL: e.BlobStorageURL,
+		DevPortalURL:            e.PortalURL,
+		ApimMgmtURL:             e.ManagementURL,
 	}
 
-	return nil
+	return writeResult(ep, func(w io.Writer) error {
+		fmt.Fprintf(w, "Developer portal URL: %s\n", ep.DevPortalURL)
+		fmt.Fprintf(w, "      Management URL: %s\n", ep.ApimMgmtURL)
+		fmt.Fprintf(w, "    Blob storage URL: %s\n", ep.DevPortalBlobStorageURL)
+		return nil
+	})
 }
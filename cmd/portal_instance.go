@@ -0,0 +1,236 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+
+	"github.com/jake-scott/apim-tools/internal/pkg/auth"
+	"github.com/jake-scott/apim-tools/internal/pkg/logging"
+	"github.com/jake-scott/apim-tools/pkg/apim"
+)
+
+// API version of the Resource Manager subscriptions API
+const azureSubscriptionsAPIVersion = "2020-01-01"
+
+// Resource ID of an API Manager instance
+var instanceIDRegexp = regexp.MustCompile(`(?i)^/subscriptions/[^/]+/resourceGroups/[^/]+/providers/Microsoft\.ApiManagement/service/[^/]+$`)
+
+var errNoSubscription = fmt.Errorf("no subscription given: use --subscription, or set auth.subscription in the config or profile")
+
+// An Azure resource, as returned by Resource Manager list operations
+type armResource struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+// Whether the instance was named with --apim but not --id on the command
+// line, in which case an instance ID in the config or profile is ignored
+var instanceNamedOnCommandLine bool
+
+// Note how the command being run identifies the instance
+func configureInstanceOptions(cmd *cobra.Command) {
+	flags := cmd.Flags()
+	instanceNamedOnCommandLine = flags.Lookup("apim") != nil && flags.Changed("apim") && !flags.Changed("id")
+}
+
+// The instance ID given by option, config or profile
+func configuredInstanceID() string {
+	if instanceNamedOnCommandLine {
+		return ""
+	}
+
+	return strings.TrimSuffix(viper.GetString("id"), "/")
+}
+
+// Check that the instance has been identified, by option, config or profile
+func checkInstanceConfig() error {
+	if configuredInstanceID() == "" && viper.GetString("apim") == "" {
+		return fmt.Errorf("no API Manager instance given: use --apim or --id, or set apim in the config or profile")
+	}
+
+	return nil
+}
+
+// The subscription to work in: the configured one, else the default
+// subscription of the Azure CLI
+func currentSubscription() string {
+	if sub := viper.GetString("auth.subscription"); sub != "" {
+		return sub
+	}
+
+	if cfg := auth.Get(); cfg != nil {
+		return cfg.SubscriptionID
+	}
+
+	return ""
+}
+
+// Find the resource ID of the instance to work on.  The ID is taken from
+// --id, or built from --apim and --rg.  When there is no resource group, the
+// instance is looked up by name in the subscription, or in every accessible
+// subscription with --all-subscriptions.
+func resolveInstanceID(ctx context.Context, cli *apim.ResourceManagerClient) (string, error) {
+	if err := checkInstanceConfig(); err != nil {
+		return "", err
+	}
+
+	if id := configuredInstanceID(); id != "" {
+		if !instanceIDRegexp.MatchString(id) {
+			return "", fmt.Errorf("bad instance ID %s, expected /subscriptions/<sub>/resourceGroups/<rg>/providers/Microsoft.ApiManagement/service/<name>", id)
+		}
+
+		return id, nil
+	}
+
+	name := viper.GetString("apim")
+	sub := currentSubscription()
+
+	if rg := viper.GetString("rg"); rg != "" {
+		if sub == "" {
+			return "", errNoSubscription
+		}
+
+		return fmt.Sprintf("/subscriptions/%s/resourceGroups/%s/providers/Microsoft.ApiManagement/service/%s", sub, rg, name), nil
+	}
+
+	subs := []string{sub}
+	if viper.GetBool("all-subscriptions") {
+		var err error
+		subs, err = listSubscriptions(ctx, cli, azureManagementEndpoint())
+		if err != nil {
+			return "", err
+		}
+	} else if sub == "" {
+		return "", errNoSubscription
+	}
+
+	logging.Logger().Infof("Looking for API Manager instance %s in %d subscription(s)", name, len(subs))
+
+	id, err := findInstance(ctx, cli, azureManagementEndpoint(), subs, name)
+	if err != nil {
+		return "", err
+	}
+
+	logging.Logger().Infof("Found instance %s", id)
+
+	return id, nil
+}
+
+// Find the instance called name in the subscriptions.  Subscriptions that
+// can't be listed are skipped when there is more than one.
+func findInstance(ctx context.Context, cli *apim.ResourceManagerClient, armURL string, subs []string, name string) (string, error) {
+	var ids []string
+
+	for _, sub := range subs {
+		listURL := fmt.Sprintf("%s/subscriptions/%s/providers/Microsoft.ApiManagement/service", armURL, sub)
+
+		var services []armResource
+		err := armList(ctx, cli, listURL, func(item json.RawMessage) error {
+			var s armResource
+			if err := json.Unmarshal(item, &s); err != nil {
+				return err
+			}
+			services = append(services, s)
+			return nil
+		})
+		if err != nil {
+			if len(subs) > 1 && ctx.Err() == nil {
+				logging.Logger().WithError(err).Warnf("Skipping subscription %s", sub)
+				continue
+			}
+			return "", fmt.Errorf("listing API Manager instances in subscription %s: %s", sub, err)
+		}
+
+		for _, s := range services {
+			if strings.EqualFold(s.Name, name) {
+				ids = append(ids, s.ID)
+			}
+		}
+	}
+
+	switch len(ids) {
+	case 0:
+		return "", fmt.Errorf("API Manager instance %s not found", name)
+	case 1:
+		return ids[0], nil
+	}
+
+	return "", fmt.Errorf("API Manager instance name %s is ambiguous, use --id with one of: %s", name, strings.Join(ids, ", "))
+}
+
+// List the IDs of the enabled subscriptions the principal can access
+func listSubscriptions(ctx context.Context, cli *apim.ResourceManagerClient, armURL string) ([]string, error) {
+	var subs []string
+
+	listURL := fmt.Sprintf("%s/subscriptions?api-version=%s", armURL, azureSubscriptionsAPIVersion)
+	err := armList(ctx, cli, listURL, func(item json.RawMessage) error {
+		var s struct {
+			SubscriptionID string `json:"subscriptionId"`
+			State          string `json:"state"`
+		}
+		if err := json.Unmarshal(item, &s); err != nil {
+			return err
+		}
+
+		if strings.EqualFold(s.State, "Enabled") {
+			subs = append(subs, s.SubscriptionID)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("listing subscriptions: %s", err)
+	}
+
+	if len(subs) == 0 {
+		return nil, fmt.Errorf("no enabled subscriptions are accessible")
+	}
+
+	return subs, nil
+}
+
+// Call each for every item returned by a Resource Manager list operation,
+// following the nextLink of each page
+func armList(ctx context.Context, cli *apim.ResourceManagerClient, listURL string, each func(json.RawMessage) error) error {
+	for listURL != "" {
+		resp, err := cli.Get(ctx, listURL)
+		if err != nil {
+			return err
+		}
+
+		respBody, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return err
+		}
+
+		// Only accept HTTP 2xx codes
+		if resp.StatusCode >= 300 {
+			return fmt.Errorf("status %s received", resp.Status)
+		}
+
+		page := struct {
+			Value    []json.RawMessage `json:"value"`
+			NextLink string            `json:"nextLink"`
+		}{}
+		if err := json.Unmarshal(respBody, &page); err != nil {
+			return err
+		}
+
+		for _, item := range page.Value {
+			if err := each(item); err != nil {
+				return err
+			}
+		}
+
+		listURL = page.NextLink
+	}
+
+	return nil
+}
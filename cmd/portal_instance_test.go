@@ -0,0 +1,187 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/Azure/go-autorest/autorest"
+	"github.com/spf13/viper"
+
+	"github.com/jake-scott/apim-tools/internal/pkg/apimfake"
+	"github.com/jake-scott/apim-tools/internal/pkg/auth"
+	"github.com/jake-scott/apim-tools/pkg/apim"
+)
+
+const testInstancePrefix = "/providers/Microsoft.ApiManagement/service/"
+
+// A fake Resource Manager with two subscriptions.  sub-b is not readable and
+// sub-a returns its instances over two pages.
+func fakeResourceManager(t *testing.T) *httptest.Server {
+	var srv *httptest.Server
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("api-version") == "" {
+			t.Errorf("No api-version in request for %s", r.URL)
+		}
+
+		switch {
+		case r.URL.Path == "/subscriptions":
+			fmt.Fprint(w, `{"value": [{"subscriptionId": "sub-a", "state": "Enabled"},
+				{"subscriptionId": "sub-b", "state": "Enabled"},
+				{"subscriptionId": "sub-c", "state": "Disabled"}]}`)
+		case r.URL.Path == "/subscriptions/sub-a/providers/Microsoft.ApiManagement/service" && r.URL.Query().Get("page") == "":
+			fmt.Fprintf(w, `{"value": [{"id": "/subscriptions/sub-a/resourceGroups/rg1%[2]sapim-one", "name": "apim-one"}],
+				"nextLink": "%[1]s/subscriptions/sub-a/providers/Microsoft.ApiManagement/service?api-version=2021-08-01&page=2"}`, srv.URL, testInstancePrefix)
+		case r.URL.Path == "/subscriptions/sub-a/providers/Microsoft.ApiManagement/service":
+			fmt.Fprintf(w, `{"value": [{"id": "/subscriptions/sub-a/resourceGroups/rg2%[1]sapim-two", "name": "apim-two"},
+				{"id": "/subscriptions/sub-a/resourceGroups/rg3%[1]sapim-dup", "name": "apim-dup"},
+				{"id": "/subscriptions/sub-a/resourceGroups/rg4%[1]sapim-dup", "name": "apim-dup"}]}`, testInstancePrefix)
+		default:
+			w.WriteHeader(http.StatusForbidden)
+		}
+	}))
+
+	return srv
+}
+
+func TestFindInstance(t *testing.T) {
+	srv := fakeResourceManager(t)
+	defer srv.Close()
+
+	cli := apim.NewResourceManagerClient(autorest.NullAuthorizer{}, azureAPIVersion, nil)
+
+	subs, err := listSubscriptions(context.Background(), cli, srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(subs, ",") != "sub-a,sub-b" {
+		t.Errorf("Got subscriptions %v", subs)
+	}
+
+	tests := []struct {
+		subs      []string
+		name      string
+		want      string
+		expectErr bool
+	}{
+		{[]string{"sub-a"}, "apim-one", "/subscriptions/sub-a/resourceGroups/rg1" + testInstancePrefix + "apim-one", false},
+		{[]string{"sub-a"}, "APIM-TWO", "/subscriptions/sub-a/resourceGroups/rg2" + testInstancePrefix + "apim-two", false},
+		{[]string{"sub-a", "sub-b"}, "apim-two", "/subscriptions/sub-a/resourceGroups/rg2" + testInstancePrefix + "apim-two", false},
+		{[]string{"sub-a"}, "apim-dup", "", true},
+		{[]string{"sub-a"}, "apim-missing", "", true},
+		{[]string{"sub-b"}, "apim-one", "", true},
+	}
+
+	for _, tt := range tests {
+		got, err := findInstance(context.Background(), cli, srv.URL, tt.subs, tt.name)
+		if tt.expectErr {
+			if err == nil {
+				t.Errorf("Expected error finding %s in %v", tt.name, tt.subs)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("Finding %s in %v: %s", tt.name, tt.subs, err)
+		} else if got != tt.want {
+			t.Errorf("Finding %s in %v: got %s, wanted %s", tt.name, tt.subs, got, tt.want)
+		}
+	}
+}
+
+func TestResolveInstanceID(t *testing.T) {
+	defer func() {
+		for _, k := range []string{"id", "apim", "rg", "auth.subscription"} {
+			viper.Set(k, nil)
+		}
+	}()
+
+	// Credentials without a default subscription, in place of any configured
+	// by the commands run by other tests
+	creds := viper.New()
+	creds.Set("auth.use-msi", true)
+	creds.Set("auth.msi-endpoint", "http://127.0.0.1:1/msi/token")
+	if err := auth.Configure(creds); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		id, apim, rg, sub string
+		want              string
+		expectErr         bool
+	}{
+		{"/subscriptions/s/resourceGroups/rg" + testInstancePrefix + "apim/", "", "", "", "/subscriptions/s/resourceGroups/rg" + testInstancePrefix + "apim", false},
+		{"/subscriptions/s/resourceGroups/rg/providers/Microsoft.Web/sites/app", "", "", "", "", true},
+		{"", "apim", "rg", "s", "/subscriptions/s/resourceGroups/rg" + testInstancePrefix + "apim", false},
+		{"", "apim", "rg", "", "", true},
+		{"", "", "rg", "s", "", true},
+	}
+
+	for _, tt := range tests {
+		viper.Set("id", tt.id)
+		viper.Set("apim", tt.apim)
+		viper.Set("rg", tt.rg)
+		viper.Set("auth.subscription", tt.sub)
+
+		got, err := resolveInstanceID(context.Background(), nil)
+		if tt.expectErr {
+			if err == nil {
+				t.Errorf("Expected error resolving %+v", tt)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("Resolving %+v: %s", tt, err)
+		} else if got != tt.want {
+			t.Errorf("Resolving %+v: got %s, wanted %s", tt, got, tt.want)
+		}
+	}
+}
+
+// An instance given on the command line, by ID or by name, takes precedence
+// over an instance ID in the config or profile
+func TestInstanceCommandLinePrecedence(t *testing.T) {
+	f := apimfake.NewServer()
+	defer f.Close()
+
+	resourceManager.endpoint = f.ResourceManagerURL()
+	resourceManager.authorizer = autorest.NullAuthorizer{}
+	defer func() {
+		resourceManager.endpoint = ""
+		resourceManager.authorizer = nil
+	}()
+
+	missing := "/subscriptions/" + apimfake.SubscriptionID + "/resourceGroups/other-rg" + testInstancePrefix + "other"
+	config := writeTestConfig(t, `
+id: `+missing+`
+profiles:
+  prod:
+    id: `+missing+`
+`)
+
+	tests := []struct {
+		args      []string
+		expectErr bool
+	}{
+		{[]string{"devportal", "status", "--config", config}, true},
+		{[]string{"devportal", "status", "--config", config, "--id", apimfake.InstanceID}, false},
+		{[]string{"devportal", "endpoints", "--config", config, "--profile", "prod", "--id", apimfake.InstanceID}, false},
+		{[]string{"devportal", "reset", "--config", config, "--apim", apimfake.Name, "--rg", apimfake.ResourceGroup}, false},
+		{[]string{"devportal", "sastoken", "--config", config, "--profile", "prod", "--apim", apimfake.Name, "--rg", apimfake.ResourceGroup}, false},
+	}
+
+	for _, tt := range tests {
+		err := runCommand(context.Background(), tt.args...)
+		if tt.expectErr {
+			if err == nil {
+				t.Errorf("%v: expected an error with the configured instance", tt.args)
+			}
+		} else if err != nil {
+			t.Errorf("%v: %s", tt.args, err)
+		}
+	}
+}
@@ -0,0 +1,268 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/Azure/azure-storage-blob-go/azblob"
+	"github.com/spf13/viper"
+
+	"github.com/jake-scott/apim-tools/internal/pkg/logging"
+	"github.com/jake-scott/apim-tools/pkg/apim"
+)
+
+// Hostnames that url items may not point at unless overridden in config
+var defaultLintDenyHosts = []string{
+	`^localhost$`,
+	`\.localhost$`,
+	`^127\.`,
+	`\.local$`,
+}
+
+// Documents used by the portal itself that are not referenced by other items
+var lintSystemDocuments = map[string]bool{
+	"/contentTypes/document/contentItems/configuration": true,
+	"/contentTypes/document/contentItems/navigation":    true,
+	"/contentTypes/document/contentItems/stylesheet":    true,
+}
+
+// A problem found with the portal content
+type lintIssue struct {
+	ItemID  string
+	Message string
+}
+
+func (i lintIssue) String() string {
+	return fmt.Sprintf("%s: %s", i.ItemID, i.Message)
+}
+
+// Run the content checks against the live portal content, returning an
+// error if any fail
+func lintPortal(ctx context.Context, client *apim.Client) error {
+	logging.Logger().Infof("Checking portal content...")
+
+	deny, err := lintDenyHosts()
+	if err != nil {
+		return err
+	}
+
+	// Get all of the content items
+	contentItems, err := client.ContentItems(ctx)
+	if err != nil {
+		return err
+	}
+
+	// .. and the names of the blobs in the media container
+	media := client.MediaContainer()
+	blobs, err := listBlobNames(ctx, media)
+	if err != nil {
+		return err
+	}
+
+	issues := lintPortalContent(contentItems, blobs, media.URL(), deny)
+	for _, issue := range issues {
+		logging.Logger().Errorf("Content check: %s", issue)
+	}
+
+	if len(issues) > 0 {
+		return fmt.Errorf("%d content check(s) failed, not publishing", len(issues))
+	}
+
+	logging.Logger().Infof("  -> %d items checked, no problems found", len(contentItems))
+
+	return nil
+}
+
+// Compile the url host deny-list from the config
+func lintDenyHosts() ([]*regexp.Regexp, error) {
+	patterns := viper.GetStringSlice("lint.deny-hosts")
+	if len(patterns) == 0 {
+		patterns = defaultLintDenyHosts
+	}
+
+	deny := make([]*regexp.Regexp, 0, len(patterns))
+	for _, p := range patterns {
+		re, err := regexp.Compile("(?i)" + p)
+		if err != nil {
+			return nil, fmt.Errorf("bad lint deny-host pattern %s: %s", p, err)
+		}
+
+		deny = append(deny, re)
+	}
+
+	return deny, nil
+}
+
+// Return the set of blob names in the container
+func listBlobNames(ctx context.Context, containerURL *azblob.ContainerURL) (map[string]bool, error) {
+	blobs := make(map[string]bool)
+
+	for marker := (azblob.Marker{}); marker.NotDone(); {
+		listBlobs, err := containerURL.ListBlobsFlatSegment(ctx, marker, azblob.ListBlobsSegmentOptions{})
+		if err != nil {
+			return nil, err
+		}
+
+		marker = listBlobs.NextMarker
+
+		for _, blobInfo := range listBlobs.Segment.BlobItems {
+			blobs[blobInfo.Name] = true
+		}
+	}
+
+	return blobs, nil
+}
+
+// Check the content items:
+//   - every page has a title and a permalink that no other page uses
+//   - every document is referenced by a page, layout or block
+//   - every page, layout or block refers to a document that exists
+//   - media stored in the portal's container exists in the container
+//   - no url item points at a host on the deny-list
+func lintPortalContent(items []map[string]interface{}, blobs map[string]bool, mediaURL url.URL, deny []*regexp.Regexp) (issues []lintIssue) {
+	documents := make(map[string]bool)
+	referenced := make(map[string]bool)
+	permalinks := make(map[string]string)
+
+	for _, item := range items {
+		id, _ := item["id"].(string)
+
+		switch contentItemType(id) {
+		case "document":
+			documents[id] = true
+		case "page":
+			for locale, props := range localisedProperties(item) {
+				if stringProperty(props, "title") == "" {
+					issues = append(issues, lintIssue{id, "page has no title"})
+				}
+
+				permalink := stringProperty(props, "permalink")
+				key := locale + ":" + permalink
+				switch {
+				case permalink == "":
+					issues = append(issues, lintIssue{id, "page has no permalink"})
+				case permalinks[key] != "":
+					issues = append(issues, lintIssue{id, fmt.Sprintf("permalink %s is also used by %s", permalink, permalinks[key])})
+				default:
+					permalinks[key] = id
+				}
+			}
+		case "blob":
+			// Each locale may refer to the same media
+			missing := make(map[string]bool)
+			for _, props := range localisedProperties(item) {
+				if name := mediaBlobName(props, mediaURL); name != "" && !blobs[name] && !missing[name] {
+					missing[name] = true
+					issues = append(issues, lintIssue{id, fmt.Sprintf("media %s does not exist in the blob container", name)})
+				}
+			}
+		case "url":
+			for _, props := range localisedProperties(item) {
+				u, err := url.Parse(stringProperty(props, "permalink"))
+				if err != nil || u.Hostname() == "" {
+					continue
+				}
+
+				for _, re := range deny {
+					if re.MatchString(u.Hostname()) {
+						issues = append(issues, lintIssue{id, fmt.Sprintf("url points at denied host %s", u.Hostname())})
+						break
+					}
+				}
+			}
+		}
+
+		// Pages, layouts and blocks refer to their document
+		for _, props := range localisedProperties(item) {
+			if docID := stringProperty(props, "documentId"); docID != "" {
+				referenced["/"+strings.TrimPrefix(docID, "/")] = true
+			}
+		}
+	}
+
+	for id := range referenced {
+		if !documents[id] {
+			issues = append(issues, lintIssue{id, "document is referenced but does not exist"})
+		}
+	}
+
+	for id := range documents {
+		if !referenced[id] && !lintSystemDocuments[id] {
+			issues = append(issues, lintIssue{id, "document is not referenced by any page, layout or block"})
+		}
+	}
+
+	sort.Slice(issues, func(i, j int) bool {
+		if issues[i].ItemID != issues[j].ItemID {
+			return issues[i].ItemID < issues[j].ItemID
+		}
+		return issues[i].Message < issues[j].Message
+	})
+
+	return issues
+}
+
+// Return the type name from a content item ID of the form
+// /contentTypes/<type>/contentItems/<name>
+func contentItemType(id string) string {
+	parts := strings.Split(strings.TrimPrefix(id, "/"), "/")
+	if len(parts) < 2 || parts[0] != "contentTypes" {
+		return ""
+	}
+
+	return parts[1]
+}
+
+// Return the per-locale properties of a content item, keyed by locale.
+// Items that are not localised have a single set of properties keyed by
+// the empty string.
+func localisedProperties(item map[string]interface{}) map[string]map[string]interface{} {
+	out := make(map[string]map[string]interface{})
+
+	props, ok := item["properties"].(map[string]interface{})
+	if !ok {
+		return out
+	}
+
+	for k, v := range props {
+		if m, ok := v.(map[string]interface{}); ok {
+			out[k] = m
+		}
+	}
+
+	if len(out) == 0 {
+		out[""] = props
+	}
+
+	return out
+}
+
+func stringProperty(props map[string]interface{}, name string) string {
+	s, _ := props[name].(string)
+	return strings.TrimSpace(s)
+}
+
+// Return the name of the blob in the media container that the (localised)
+// properties of a blob content item refer to, or the empty string if they
+// refer to media held elsewhere
+func mediaBlobName(props map[string]interface{}, mediaURL url.URL) string {
+	if key := stringProperty(props, "blobKey"); key != "" {
+		return key
+	}
+
+	u, err := url.Parse(stringProperty(props, "downloadUrl"))
+	if err != nil || !strings.EqualFold(u.Host, mediaURL.Host) {
+		return ""
+	}
+
+	containerPath := strings.TrimSuffix(mediaURL.Path, "/") + "/"
+	if !strings.HasPrefix(u.Path, containerPath) {
+		return ""
+	}
+
+	return strings.TrimPrefix(u.Path, containerPath)
+}
@@ -0,0 +1,59 @@
+package cmd
+
+import (
+	"encoding/json"
+	"net/url"
+	"reflect"
+	"regexp"
+	"testing"
+)
+
+const lintTestContent = `[
+	{"id": "/contentTypes/document/contentItems/configuration", "properties": {"nodes": []}},
+	{"id": "/contentTypes/document/contentItems/home", "properties": {"nodes": []}},
+	{"id": "/contentTypes/document/contentItems/about", "properties": {"nodes": []}},
+	{"id": "/contentTypes/document/contentItems/orphan", "properties": {"nodes": []}},
+	{"id": "/contentTypes/page/contentItems/home", "properties": {"en_us": {
+		"title": "Home", "permalink": "/", "documentId": "contentTypes/document/contentItems/home"}}},
+	{"id": "/contentTypes/page/contentItems/about", "properties": {"en_us": {
+		"title": "", "permalink": "/about", "documentId": "contentTypes/document/contentItems/about"}}},
+	{"id": "/contentTypes/page/contentItems/about2", "properties": {"en_us": {
+		"title": "About", "permalink": "/about", "documentId": "/contentTypes/document/contentItems/missing"}}},
+	{"id": "/contentTypes/blob/contentItems/logo", "properties": {"en_us": {
+		"downloadUrl": "https://cdn.paperbits.io/images/logo.svg"}}},
+	{"id": "/contentTypes/blob/contentItems/hero", "properties": {"en_us": {
+		"blobKey": "hero", "downloadUrl": "https://portalst.blob.core.windows.net/content/hero?sv=2019-12-12&sig=abc"}}},
+	{"id": "/contentTypes/blob/contentItems/gone", "properties": {
+		"en_us": {"downloadUrl": "https://portalst.blob.core.windows.net/content/gone"},
+		"fr_fr": {"downloadUrl": "https://portalst.blob.core.windows.net/content/gone"}}},
+	{"id": "/contentTypes/blob/contentItems/lost", "properties": {"en_us": {"blobKey": "lost"}}},
+	{"id": "/contentTypes/url/contentItems/docs", "properties": {"permalink": "https://docs.example.com/"}},
+	{"id": "/contentTypes/url/contentItems/dev", "properties": {"permalink": "http://localhost:8080/apis"}},
+	{"id": "/contentTypes/url/contentItems/signout", "properties": {"permalink": "#signout"}}
+]`
+
+func TestLintPortalContent(t *testing.T) {
+	var items []map[string]interface{}
+	if err := json.Unmarshal([]byte(lintTestContent), &items); err != nil {
+		t.Fatal(err)
+	}
+
+	mediaURL, _ := url.Parse("https://portalst.blob.core.windows.net/content?sv=2019-12-12&sig=abc")
+	blobs := map[string]bool{"hero": true}
+	deny := []*regexp.Regexp{regexp.MustCompile(`(?i)^localhost$`)}
+
+	want := []lintIssue{
+		{"/contentTypes/blob/contentItems/gone", "media gone does not exist in the blob container"},
+		{"/contentTypes/blob/contentItems/lost", "media lost does not exist in the blob container"},
+		{"/contentTypes/document/contentItems/missing", "document is referenced but does not exist"},
+		{"/contentTypes/document/contentItems/orphan", "document is not referenced by any page, layout or block"},
+		{"/contentTypes/page/contentItems/about", "page has no title"},
+		{"/contentTypes/page/contentItems/about2", "permalink /about is also used by /contentTypes/page/contentItems/about"},
+		{"/contentTypes/url/contentItems/dev", "url points at denied host localhost"},
+	}
+
+	got := lintPortalContent(items, blobs, *mediaURL, deny)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Got %v, wanted %v", got, want)
+	}
+}
@@ -4,20 +4,37 @@ import (
 	"context"
 	"errors"
 	"fmt"
-	"net/http"
-	"time"
+	"io"
 
-	"github.com/jake-scott/apim-tools/internal/pkg/logging"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
+
+	"github.com/jake-scott/apim-tools/pkg/apim"
 )
 
 var portalPublishCmd = &cobra.Command{
 	Use:   "publish",
 	Short: "Publish the API Manager Developer Portal",
+	Long: `Publish the API Manager Developer Portal.
+
+For a self-hosted portal (--self-hosted), the output of the portal's publish
+pipeline (usually dist/website) is uploaded to the static website container
+instead.  Files in the container that are not in the publish output are
+deleted, unless --nodelete is given or any file fails to upload.
+
+With --lint, the portal content is checked before publishing and the portal
+is not published if any check fails:
+  * every page has a title and a unique permalink
+  * every document is referenced by a page, layout or block
+  * media stored in the portal's blob container exists
+  * no url item points at a host matching the deny-list (--lint-deny-host)`,
 
 	RunE: func(cmd *cobra.Command, args []string) error {
-		if err := doPortalPublish(); err != nil {
+		if err := doPortalPublish(cmd.Context()); err != nil {
+			if interrupted(cmd.Context()) {
+				return errInterrupted
+			}
+
 			if errors.Is(err, context.DeadlineExceeded) {
 				return fmt.Errorf("publish timed out")
 			}
@@ -32,105 +49,87 @@ var portalPublishCmd = &cobra.Command{
 func init() {
 	portalPublishCmd.Flags().StringVar(&portalCmdOpts.apimName, "apim", "", "API Manager instance")
 	portalPublishCmd.Flags().StringVar(&portalCmdOpts.resourceGroup, "rg", "", "Resource group containing the APIM instance")
+	portalPublishCmd.Flags().StringVar(&portalCmdOpts.instanceID, "id", "", "Resource ID of the APIM instance, instead of --apim and --rg")
+	portalPublishCmd.Flags().BoolVar(&portalCmdOpts.allSubscriptions, "all-subscriptions", false, "Search all accessible subscriptions for --apim")
 	portalPublishCmd.Flags().BoolVarP(&portalCmdOpts.wait, "wait", "w", false, "Wait for completion")
-
-	errPanic(portalPublishCmd.MarkFlagRequired("apim"))
-	errPanic(portalPublishCmd.MarkFlagRequired("rg"))
-
-	errPanic(viper.GetViper().BindPFlag("apim", portalPublishCmd.Flags().Lookup("apim")))
-	errPanic(viper.GetViper().BindPFlag("rg", portalPublishCmd.Flags().Lookup("rg")))
-	errPanic(viper.GetViper().BindPFlag("wait", portalPublishCmd.Flags().Lookup("wait")))
+	portalPublishCmd.Flags().StringVar(&portalCmdOpts.websiteDir, "website-dir", "", "Self-hosted portal publish output directory")
+	portalPublishCmd.Flags().BoolVar(&portalCmdOpts.nodelete, "nodelete", false, "Do not delete self-hosted portal website files that are not in --website-dir")
+	portalPublishCmd.Flags().BoolVar(&portalCmdOpts.lint, "lint", false, "Check the portal content before publishing")
+	portalPublishCmd.Flags().StringSliceVar(&portalCmdOpts.lintDenyHosts, "lint-deny-host", nil, "Regular expression matching url item hosts to reject (repeatable)")
+
+	bindFlag(portalPublishCmd, "apim", "apim")
+	bindFlag(portalPublishCmd, "rg", "rg")
+	bindFlag(portalPublishCmd, "id", "id")
+	bindFlag(portalPublishCmd, "all-subscriptions", "all-subscriptions")
+	bindFlag(portalPublishCmd, "wait", "wait")
+	bindFlag(portalPublishCmd, "website-dir", "website-dir")
+	bindFlag(portalPublishCmd, "nodelete", "nodelete")
+	bindFlag(portalPublishCmd, "lint.enabled", "lint")
+	bindFlag(portalPublishCmd, "lint.deny-hosts", "lint-deny-host")
 
 	portalCmd.AddCommand(portalPublishCmd)
 }
 
-func doPortalPublish() error {
-	info, err := buildApimInfo(azureAPIVersion)
-	if err != nil {
-		return err
+func doPortalPublish(ctx context.Context) (err error) {
+	var ops []apiOperation
+	if viper.GetBool("lint.enabled") {
+		ops = append(ops, apiOpContent)
 	}
 
-	// Get the current publish date
-	status1, err := getDevportalStatus(info.devPortalURL)
+	client, err := newPortalClient(ctx, ops...)
 	if err != nil {
 		return err
 	}
-	logging.Logger().Debugf("Initial portal status: %+v", status1)
-
-	// If the last publish is not at least a minute ago, wait until it is at least
-	// a minute old.  This is necessary because the publish date only has a
-	// per-minute resolution
-	//
-	waitUntil := time.Date(status1.PortalVersion.Year(), status1.PortalVersion.Month(),
-		status1.PortalVersion.Day(), status1.PortalVersion.Hour(),
-		status1.PortalVersion.Minute(), 0, 0, time.UTC).Add(time.Minute)
-	if waitUntil.After(time.Now()) {
-		waitFor := time.Until(waitUntil)
-		logging.Logger().Infof("Waiting for %s before publishing portal", waitFor.Truncate(time.Second))
-		time.Sleep(waitFor)
-	}
 
-	// Trigger the publish
-	reqURL := fmt.Sprintf("%s/publish", info.devPortalURL)
-	req, err := http.NewRequest("POST", reqURL, nil)
-	if err != nil {
-		return err
-	}
+	var result apim.PublishResult
+	defer func() { auditOperation("publish", client.Endpoints().InstanceURL, &result, err) }()
 
-	resp, err := info.apimClient.Do(req)
-	if err != nil {
-		return err
+	if client.SelfHosted() {
+		if err := checkSelfHostedPublish(); err != nil {
+			return err
+		}
 	}
 
-	// Only accept HTTP 2xx codes
-	if resp.StatusCode >= 300 {
-		return fmt.Errorf("publishing portal, got %s", resp.Status)
+	// Don't publish content that fails the checks
+	if viper.GetBool("lint.enabled") {
+		if err := lintPortal(ctx, client); err != nil {
+			return err
+		}
 	}
 
-	if !viper.GetBool("wait") {
-		logging.Logger().Infof("Developer portal publish triggered")
-		return nil
+	result, err = client.Publish(ctx, apim.PublishOptions{
+		Wait:       viper.GetBool("wait"),
+		WebsiteDir: viper.GetString("website-dir"),
+		NoDelete:   viper.GetBool("nodelete"),
+	})
+	if err != nil {
+		return err
 	}
 
-	logging.Logger().Infoln("Waiting (max 5 mins) for publish to complete")
-
-	// 5 minute max wait for the portal to be deployed and published
-	d := time.Now().Add(time.Minute * 5)
-	ctx, cancel := context.WithDeadline(context.Background(), d)
-	defer cancel()
-
-	// Loop waiting for initial deployment
-	for {
-		isDeployed, err := isDevportalDeployedWithContext(ctx, info.devPortalURL)
-		if err != nil {
-			return err
+	return writeResult(result, func(w io.Writer) error {
+		fmt.Fprintf(w, "Publish status: %s\n", result.Status)
+		if result.PublishDate != "" {
+			fmt.Fprintf(w, "  Published at: %s\n", result.PublishDate)
 		}
-
-		if isDeployed {
-			logging.Logger().Debugln("Devportal is deployed")
-			break
+		if result.Files != nil {
+			fmt.Fprintf(w, " Website files: %s\n", result.Files)
 		}
-
-		logging.Logger().Debugln("Devportal not yet deployed..")
-		time.Sleep(5 * time.Second)
-	}
-
-	// Wait for the publish date to change
-	for {
-		status2, err := getDevportalStatusWithContext(ctx, info.devPortalURL)
-		if err != nil {
-			return err
+		if result.DeletedFiles != nil {
+			fmt.Fprintf(w, " Deleted files: %s\n", result.DeletedFiles)
 		}
+		return nil
+	})
+}
 
-		if status1.PortalVersion != status2.PortalVersion {
-			logging.Logger().Debugln("Devportal is published")
-			break
-		}
+// Check the options needed to publish a self-hosted portal are given
+func checkSelfHostedPublish() error {
+	if viper.GetString("website-dir") == "" {
+		return fmt.Errorf("--website-dir is required to publish a self-hosted portal")
+	}
 
-		logging.Logger().Debugln("Devportal not yet published..")
-		time.Sleep(5 * time.Second)
+	if viper.GetString("self-hosted.website-sas-url") == "" && viper.GetString("self-hosted.storage-connection-string") == "" {
+		return fmt.Errorf("self-hosted portal requires --storage-connection-string or --website-sas-url to publish")
 	}
 
-	logging.Logger().Infoln("Developer portal published")
 	return nil
 }
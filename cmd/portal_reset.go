@@ -2,14 +2,12 @@ package cmd
 
 import (
 	"context"
-	"net/http"
-	"net/url"
+	"fmt"
+	"io"
 
-	"github.com/Azure/azure-storage-blob-go/azblob"
 	"github.com/spf13/cobra"
-	"github.com/spf13/viper"
 
-	"github.com/jake-scott/apim-tools/internal/pkg/logging"
+	"github.com/jake-scott/apim-tools/pkg/apim"
 )
 
 var portalResetCmd = &cobra.Command{
@@ -20,7 +18,7 @@ var portalResetCmd = &cobra.Command{
 NOTE: THIS OPTION IS DESTRUCTIVE AND CANNOT BE REVERSED.`,
 
 	RunE: func(cmd *cobra.Command, args []string) error {
-		if err := doPortalReset(); err != nil {
+		if err := doPortalReset(cmd.Context()); err != nil {
 			return err
 		}
 
@@ -31,117 +29,50 @@ NOTE: THIS OPTION IS DESTRUCTIVE AND CANNOT BE REVERSED.`,
 func init() {
 	portalResetCmd.Flags().StringVar(&portalCmdOpts.apimName, "apim", "", "API Manager instance")
 	portalResetCmd.Flags().StringVar(&portalCmdOpts.resourceGroup, "rg", "", "Resource group containing the APIM instance")
+	portalResetCmd.Flags().StringVar(&portalCmdOpts.instanceID, "id", "", "Resource ID of the APIM instance, instead of --apim and --rg")
+	portalResetCmd.Flags().BoolVar(&portalCmdOpts.allSubscriptions, "all-subscriptions", false, "Search all accessible subscriptions for --apim")
 
-	errPanic(portalResetCmd.MarkFlagRequired("apim"))
-	errPanic(portalResetCmd.MarkFlagRequired("rg"))
-
-	errPanic(viper.GetViper().BindPFlag("apim", portalResetCmd.Flags().Lookup("apim")))
-	errPanic(viper.GetViper().BindPFlag("rg", portalResetCmd.Flags().Lookup("rg")))
+	bindFlag(portalResetCmd, "apim", "apim")
+	bindFlag(portalResetCmd, "rg", "rg")
+	bindFlag(portalResetCmd, "id", "id")
+	bindFlag(portalResetCmd, "all-subscriptions", "all-subscriptions")
 
 	portalCmd.AddCommand(portalResetCmd)
 }
 
-func doPortalReset() error {
-	info, err := buildApimInfo(azureAPIVersion)
-	if err != nil {
-		return err
-	}
-
-	// run the reset
-	if err := deletePortalContentItems(info.apimClient, info.apimMgmtURL); err != nil {
-		return err
-	}
-
-	return resetPortalBlobs(info.devPortalBlobStorageURL)
+// Summary of a reset
+type resetResult struct {
+	apim.ResetResult
+	Interrupted bool `json:"interrupted,omitempty"`
 }
 
-func deletePortalContentItems(cli *apimClient, mgmtURL string) error {
-	logging.Logger().Info("Deleting portal content items")
-
-	// Get content types used by the portal
-	contentTypes, err := getContentTypes(cli, mgmtURL)
+func doPortalReset(ctx context.Context) (err error) {
+	client, err := newPortalClient(ctx, apiOpContent)
 	if err != nil {
 		return err
 	}
 
-	// Get content items for each content type
-	var contentItems []map[string]interface{}
-	for _, ct := range contentTypes {
-		subItems, err := getContentItemsAsMap(cli, mgmtURL, ct)
-		if err != nil {
-			return err
-		}
-
-		contentItems = append(contentItems, subItems...)
-	}
-
-	var cOK, cErr int
+	var result resetResult
+	defer func() { auditOperation("reset", client.Endpoints().InstanceURL, &result, err) }()
 
-	// Delete the content items
-	for _, item := range contentItems {
-		id := item["id"].(string)
-
-		reqURL := apimMgmtURL(mgmtURL) + id
-		req, err := http.NewRequest("DELETE", reqURL, nil)
-		if err != nil {
-			return err
-		}
-
-		resp, err := cli.Do(req)
-		if err != nil {
-			cErr++
-			logging.Logger().Errorf("Deleting %s: %s", id, err)
-			continue
-		}
-
-		// Only accept HTTP 2xx codes
-		if resp.StatusCode >= 300 {
-			cErr++
-			logging.Logger().Errorf("Deleting %s: %s", id, resp.Status)
-			continue
-		}
-
-		cOK++
+	// run the reset
+	result.ResetResult, err = client.Reset(ctx)
+	if err != nil && !interrupted(ctx) {
+		return err
 	}
+	result.Interrupted = interrupted(ctx)
 
-	logging.Logger().Infof("Deleted %d content items, %d errors", cOK, cErr)
-
-	return nil
-}
-
-func resetPortalBlobs(blobURLString string) error {
-	logging.Logger().Infof("Deleting blobs")
-
-	u, _ := url.Parse(blobURLString)
-	ctx := context.Background()
-	containerURL := azblob.NewContainerURL(*u, azblob.NewPipeline(azblob.NewAnonymousCredential(), azblob.PipelineOptions{}))
-
-	var cOK, cErr int
-
-	for marker := (azblob.Marker{}); marker.NotDone(); {
-		listBlobs, err := containerURL.ListBlobsFlatSegment(ctx, marker, azblob.ListBlobsSegmentOptions{})
-		if err != nil {
-			return err
-		}
-
-		marker = listBlobs.NextMarker
-
-		for _, blobInfo := range listBlobs.Segment.BlobItems {
-			logging.Logger().Debugf("Deleting blob: %s", blobInfo.Name)
-
-			blobURL := containerURL.NewBlobURL(blobInfo.Name)
-
-			_, err = blobURL.Delete(ctx, azblob.DeleteSnapshotsOptionNone, azblob.BlobAccessConditions{})
-			if err != nil {
-				logging.Logger().WithError(err).Errorf("Deleting BLOB %s", blobInfo.Name)
-				cErr++
-			} else {
-				cOK++
-			}
+	err = writeResult(result, func(w io.Writer) error {
+		fmt.Fprintf(w, "Deleted content items: %s\n", result.DeletedContentItems)
+		fmt.Fprintf(w, "  Deleted media blobs: %s\n", result.DeletedBlobs)
+		if result.Interrupted {
+			fmt.Fprintf(w, "          Interrupted: the portal was only partly reset\n")
 		}
+		return nil
+	})
+	if err == nil && result.Interrupted {
+		err = errInterrupted
 	}
 
-	logging.Logger().Infof("Deleted %d blobs, %d errors", cOK, cErr)
-
-	return nil
+	return err
 }
@@ -1,19 +1,32 @@
 package cmd
 
 import (
-	"encoding/json"
+	"context"
 	"fmt"
+	"io"
+	"strings"
+	"time"
 
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
+
+	"github.com/jake-scott/apim-tools/pkg/apim"
 )
 
 var portalSastokenCmd = &cobra.Command{
 	Use:   "sastoken",
 	Short: "Vend a Shared Access Signature token for the API Manager Developer Portal",
+	Long: `Vend a Shared Access Signature token for the API Manager Management API.
+
+By default the token is for the Administrator user (ID 1), is signed with the
+primary key and is valid for 30 minutes.
+
+The token is printed on its own by default.  Use --as header to print a
+ready-to-use Authorization header, or --as curl to print a curl config file
+(use with curl -K).`,
 
 	RunE: func(cmd *cobra.Command, args []string) error {
-		if err := doPortalSastoken(); err != nil {
+		if err := doPortalSastoken(cmd.Context()); err != nil {
 			return err
 		}
 
@@ -24,42 +37,135 @@ var portalSastokenCmd = &cobra.Command{
 func init() {
 	portalSastokenCmd.Flags().StringVar(&portalCmdOpts.apimName, "apim", "", "API Manager instance")
 	portalSastokenCmd.Flags().StringVar(&portalCmdOpts.resourceGroup, "rg", "", "Resource group containing the APIM instance")
-	portalSastokenCmd.Flags().BoolVar(&portalCmdOpts.asJSON, "json", false, "Return results as JSON")
+	portalSastokenCmd.Flags().StringVar(&portalCmdOpts.instanceID, "id", "", "Resource ID of the APIM instance, instead of --apim and --rg")
+	portalSastokenCmd.Flags().BoolVar(&portalCmdOpts.allSubscriptions, "all-subscriptions", false, "Search all accessible subscriptions for --apim")
+	portalSastokenCmd.Flags().BoolVar(&portalCmdOpts.asJSON, "json", false, "Return results as JSON (same as --output json)")
+	portalSastokenCmd.Flags().StringVar(&portalCmdOpts.tokenUser, "user", "1", "APIM user ID to vend the token for (1 is Administrator)")
+	portalSastokenCmd.Flags().StringVar(&portalCmdOpts.tokenKeyType, "key-type", "primary", "Key to sign the token with: primary or secondary")
+	portalSastokenCmd.Flags().DurationVar(&portalCmdOpts.tokenTTL, "ttl", apim.DefaultTokenValidity, "How long the token is valid for")
+	portalSastokenCmd.Flags().StringVar(&portalCmdOpts.tokenExpiry, "expiry", "", "When the token expires (RFC3339), instead of --ttl")
+	portalSastokenCmd.Flags().StringVar(&portalCmdOpts.tokenAs, "as", "token", "Print the token as: token, header or curl")
 
-	errPanic(portalSastokenCmd.MarkFlagRequired("apim"))
-	errPanic(portalSastokenCmd.MarkFlagRequired("rg"))
-
-	errPanic(viper.GetViper().BindPFlag("apim", portalSastokenCmd.Flags().Lookup("apim")))
-	errPanic(viper.GetViper().BindPFlag("rg", portalSastokenCmd.Flags().Lookup("rg")))
-	errPanic(viper.GetViper().BindPFlag("json", portalSastokenCmd.Flags().Lookup("json")))
+	bindFlag(portalSastokenCmd, "apim", "apim")
+	bindFlag(portalSastokenCmd, "rg", "rg")
+	bindFlag(portalSastokenCmd, "id", "id")
+	bindFlag(portalSastokenCmd, "all-subscriptions", "all-subscriptions")
+	bindFlag(portalSastokenCmd, "json", "json")
+	bindFlag(portalSastokenCmd, "sastoken.user", "user")
+	bindFlag(portalSastokenCmd, "sastoken.key-type", "key-type")
+	bindFlag(portalSastokenCmd, "sastoken.ttl", "ttl")
+	bindFlag(portalSastokenCmd, "sastoken.expiry", "expiry")
+	bindFlag(portalSastokenCmd, "sastoken.as", "as")
 
 	portalCmd.AddCommand(portalSastokenCmd)
 }
 
 type sastokenInfo struct {
 	SasToken string `json:"token"`
+	User     string `json:"user"`
+	KeyType  string `json:"key_type"`
+	Expiry   string `json:"expiry"`
+	Header   string `json:"header"`
 }
 
-func doPortalSastoken() error {
-	info, err := buildApimInfo(azureAPIVersion)
+func doPortalSastoken(ctx context.Context) error {
+	if err := checkInstanceConfig(); err != nil {
+		return err
+	}
+
+	opts, err := sasTokenOptionsFromConfig()
+	if err != nil {
+		return err
+	}
+
+	as := viper.GetString("sastoken.as")
+	switch as {
+	case "token", "header", "curl":
+	default:
+		return fmt.Errorf("bad --as value %q, expected token, header or curl", as)
+	}
+
+	apiVersion, err := selectAPIVersion(apiOpSasToken)
+	if err != nil {
+		return err
+	}
+
+	cli, err := newAzureClient(apiVersion)
 	if err != nil {
 		return err
 	}
 
-	if viper.GetBool("json") {
-		ep := sastokenInfo{
-			SasToken: info.apimSasToken,
+	id, err := resolveInstanceID(ctx, cli)
+	if err != nil {
+		return err
+	}
+
+	token, err := apim.SasToken(ctx, cli, azureManagementEndpoint()+id, opts)
+	if err != nil {
+		return err
+	}
+
+	ep := sastokenInfo{
+		SasToken: token,
+		User:     opts.UserID,
+		KeyType:  opts.KeyType,
+		Expiry:   opts.Expiry.UTC().Format(time.RFC3339),
+		Header:   sasAuthorizationHeader(token),
+	}
+
+	return writeResult(ep, func(w io.Writer) (err error) {
+		switch as {
+		case "header":
+			_, err = fmt.Fprintln(w, ep.Header)
+		case "curl":
+			_, err = fmt.Fprintf(w, "header = \"%s\"\n", curlConfigEscape(ep.Header))
+		default:
+			_, err = fmt.Fprintln(w, token)
 		}
+		return err
+	})
+}
+
+// Build the token options from the command line or config
+func sasTokenOptionsFromConfig() (opts apim.TokenOptions, err error) {
+	opts = apim.DefaultTokenOptions()
 
-		b, err := json.MarshalIndent(ep, "", "    ")
+	opts.UserID = viper.GetString("sastoken.user")
+	if opts.UserID == "" {
+		return opts, fmt.Errorf("--user must not be empty")
+	}
+
+	opts.KeyType = viper.GetString("sastoken.key-type")
+	if opts.KeyType != "primary" && opts.KeyType != "secondary" {
+		return opts, fmt.Errorf("bad --key-type value %q, expected primary or secondary", opts.KeyType)
+	}
+
+	if expiry := viper.GetString("sastoken.expiry"); expiry != "" {
+		opts.Expiry, err = time.Parse(time.RFC3339, expiry)
 		if err != nil {
-			return err
+			return opts, fmt.Errorf("bad --expiry value: %s", err)
 		}
-
-		fmt.Println(string(b))
 	} else {
-		fmt.Println(info.apimSasToken)
+		ttl := viper.GetDuration("sastoken.ttl")
+		if ttl <= 0 {
+			return opts, fmt.Errorf("--ttl must be positive")
+		}
+		opts.Expiry = time.Now().Add(ttl)
+	}
+
+	if !opts.Expiry.After(time.Now()) {
+		return opts, fmt.Errorf("token expiry %s is in the past", opts.Expiry.Format(time.RFC3339))
 	}
 
-	return nil
+	return opts, nil
+}
+
+// The Authorization header for a SAS token
+func sasAuthorizationHeader(token string) string {
+	return "Authorization: SharedAccessSignature " + token
+}
+
+// Escape a string for use within double quotes in a curl config file
+func curlConfigEscape(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s)
 }
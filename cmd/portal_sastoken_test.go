@@ -0,0 +1,19 @@
+package cmd
+
+import "testing"
+
+func TestCurlConfigEscape(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{`Authorization: SharedAccessSignature 1&202010022203&fl09Xy+Ta==`, `Authorization: SharedAccessSignature 1&202010022203&fl09Xy+Ta==`},
+		{`a "quoted" value`, `a \"quoted\" value`},
+		{`back\slash`, `back\\slash`},
+	}
+
+	for _, tt := range tests {
+		if got := curlConfigEscape(tt.in); got != tt.want {
+			t.Errorf("Escaping %q: got %q, wanted %q", tt.in, got, tt.want)
+		}
+	}
+}
@@ -1,9 +1,9 @@
 package cmd
 
 import (
-	"encoding/json"
+	"context"
 	"fmt"
-	"strconv"
+	"io"
 	"time"
 
 	"github.com/jake-scott/apim-tools/internal/pkg/logging"
@@ -16,7 +16,7 @@ var portalStatusCmd = &cobra.Command{
 	Short: "Display the API Manager Developer Portal status",
 
 	RunE: func(cmd *cobra.Command, args []string) error {
-		if err := doPortalStatus(); err != nil {
+		if err := doPortalStatus(cmd.Context()); err != nil {
 			return err
 		}
 
@@ -27,14 +27,15 @@ var portalStatusCmd = &cobra.Command{
 func init() {
 	portalStatusCmd.Flags().StringVar(&portalCmdOpts.apimName, "apim", "", "API Manager instance")
 	portalStatusCmd.Flags().StringVar(&portalCmdOpts.resourceGroup, "rg", "", "Resource group containing the APIM instance")
-	portalStatusCmd.Flags().BoolVarP(&portalCmdOpts.asJSON, "json", "j", false, "Return results as JSON")
+	portalStatusCmd.Flags().StringVar(&portalCmdOpts.instanceID, "id", "", "Resource ID of the APIM instance, instead of --apim and --rg")
+	portalStatusCmd.Flags().BoolVar(&portalCmdOpts.allSubscriptions, "all-subscriptions", false, "Search all accessible subscriptions for --apim")
+	portalStatusCmd.Flags().BoolVarP(&portalCmdOpts.asJSON, "json", "j", false, "Return results as JSON (same as --output json)")
 
-	errPanic(portalStatusCmd.MarkFlagRequired("apim"))
-	errPanic(portalStatusCmd.MarkFlagRequired("rg"))
-
-	errPanic(viper.GetViper().BindPFlag("apim", portalStatusCmd.Flags().Lookup("apim")))
-	errPanic(viper.GetViper().BindPFlag("rg", portalStatusCmd.Flags().Lookup("rg")))
-	errPanic(viper.GetViper().BindPFlag("json", portalStatusCmd.Flags().Lookup("json")))
+	bindFlag(portalStatusCmd, "apim", "apim")
+	bindFlag(portalStatusCmd, "rg", "rg")
+	bindFlag(portalStatusCmd, "id", "id")
+	bindFlag(portalStatusCmd, "all-subscriptions", "all-subscriptions")
+	bindFlag(portalStatusCmd, "json", "json")
 
 	portalCmd.AddCommand(portalStatusCmd)
 }
@@ -46,84 +47,47 @@ type portalStatusOutput struct {
 	Version     string `json:"version"`
 }
 
-func doPortalStatus() error {
-	info, err := buildApimInfo(azureAPIVersion)
-	if err != nil {
-		return err
+func doPortalStatus(ctx context.Context) error {
+	// Otherwise the managed portal would be reported
+	if viper.GetBool("self-hosted.enabled") && viper.GetString("self-hosted.portal-url") == "" {
+		return fmt.Errorf("the status of a self-hosted portal needs --portal-url")
 	}
 
-	status, err := getDevportalStatus(info.devPortalURL)
+	client, err := newPortalClient(ctx)
 	if err != nil {
 		return err
 	}
-	logging.Logger().Debugf("Portal status: %+v", status)
 
-	isDeployed, err := isDevportalDeployed(info.devPortalURL)
+	status, err := client.Status(ctx)
 	if err != nil {
 		return err
 	}
+	logging.Logger().Debugf("Portal status: %+v", status)
 
-	if viper.GetBool("json") {
-		var dateStr string
-		if status.PortalVersion != (time.Time{}) {
-			dateStr = status.PortalVersion.Format(time.RFC3339)
-		}
-
-		// Convert to the output format
-		ss := portalStatusOutput{
-			IsDeployed:  isDeployed,
-			CodeVersion: status.CodeVersion,
-			Version:     status.Version,
-			PublishDate: dateStr,
-		}
+	var dateStr string
+	if !status.PublishDate.IsZero() {
+		dateStr = status.PublishDate.Format(time.RFC3339)
+	}
 
-		b, err := json.MarshalIndent(ss, "", "    ")
-		if err != nil {
-			return err
-		}
+	// Convert to the output format
+	ss := portalStatusOutput{
+		IsDeployed:  status.Deployed,
+		CodeVersion: status.CodeVersion,
+		Version:     status.Version,
+		PublishDate: dateStr,
+	}
 
-		fmt.Println(string(b))
-	} else {
+	return writeResult(ss, func(w io.Writer) error {
 		var dateStr string
-		if status.PortalVersion != (time.Time{}) {
-			dateStr = status.PortalVersion.Local().Format(time.RFC822)
+		if !status.PublishDate.IsZero() {
+			dateStr = status.PublishDate.Local().Format(time.RFC822)
 		} else {
 			dateStr = "[Not published]"
 		}
-		fmt.Printf(" Is deployed: %t\n", isDeployed)
-		fmt.Printf("Published at: %s\n", dateStr)
-		fmt.Printf("Code version: %s\n", status.CodeVersion)
-		fmt.Printf("     Version: %s\n", status.Version)
-	}
-
-	return nil
-}
-
-func parsePublishDate(s string) (t time.Time, err error) {
-	if len(s) < 12 {
-		return
-	}
-
-	yy, err := strconv.Atoi(s[0:4])
-	if err != nil {
-		return
-	}
-	mM, err := strconv.Atoi(s[4:6])
-	if err != nil {
-		return
-	}
-	dd, err := strconv.Atoi(s[6:8])
-	if err != nil {
-		return
-	}
-	hh, err := strconv.Atoi(s[8:10])
-	if err != nil {
-		return
-	}
-	mm, err := strconv.Atoi(s[10:12])
-	if err != nil {
-		return
-	}
-
-	return time.Date(yy, time.Month(mM), dd, hh, mm, 0, 0, time.UTC), nil
+		fmt.Fprintf(w, " Is deployed: %t\n", status.Deployed)
+		fmt.Fprintf(w, "Published at: %s\n", dateStr)
+		fmt.Fprintf(w, "Code version: %s\n", status.CodeVersion)
+		fmt.Fprintf(w, "     Version: %s\n", status.Version)
+		return nil
+	})
 }
@@ -0,0 +1,148 @@
+package cmd
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/Azure/azure-storage-blob-go/azblob"
+	"github.com/spf13/viper"
+
+	"github.com/jake-scott/apim-tools/internal/pkg/auth"
+	"github.com/jake-scott/apim-tools/internal/pkg/logging"
+	"github.com/jake-scott/apim-tools/pkg/apim"
+)
+
+// Blob storage account details parsed from a connection string
+type storageAccount struct {
+	blobEndpoint string
+	credential   azblob.Credential
+	sasToken     string
+}
+
+// Parse an Azure storage account connection string, returning the blob
+// endpoint and a credential to use with it.  Both account key and shared
+// access signature connection strings are supported.
+func parseStorageConnectionString(cs string) (*storageAccount, error) {
+	kv := make(map[string]string)
+
+	for _, part := range strings.Split(cs, ";") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+
+		i := strings.Index(part, "=")
+		if i < 1 {
+			return nil, fmt.Errorf("bad storage connection string element: %s", part)
+		}
+
+		kv[strings.ToLower(part[:i])] = part[i+1:]
+	}
+
+	sa := &storageAccount{}
+
+	accountName := kv["accountname"]
+
+	// Use an explicit blob endpoint if there is one, else construct one
+	sa.blobEndpoint = kv["blobendpoint"]
+	if sa.blobEndpoint == "" {
+		if accountName == "" {
+			return nil, fmt.Errorf("storage connection string has no AccountName or BlobEndpoint")
+		}
+
+		protocol := kv["defaultendpointsprotocol"]
+		if protocol == "" {
+			protocol = "https"
+		}
+
+		// Default to the storage suffix of the configured Azure cloud
+		suffix := kv["endpointsuffix"]
+		if suffix == "" {
+			suffix = auth.Environment().StorageEndpointSuffix
+		}
+
+		sa.blobEndpoint = fmt.Sprintf("%s://%s.blob.%s", protocol, accountName, suffix)
+	}
+	sa.blobEndpoint = strings.TrimSuffix(sa.blobEndpoint, "/")
+
+	switch {
+	case kv["accountkey"] != "":
+		if accountName == "" {
+			return nil, fmt.Errorf("storage connection string has an AccountKey but no AccountName")
+		}
+
+		cred, err := azblob.NewSharedKeyCredential(accountName, kv["accountkey"])
+		if err != nil {
+			return nil, fmt.Errorf("bad storage account key: %s", err)
+		}
+		sa.credential = cred
+	case kv["sharedaccesssignature"] != "":
+		sa.sasToken = strings.TrimPrefix(kv["sharedaccesssignature"], "?")
+		sa.credential = azblob.NewAnonymousCredential()
+	default:
+		return nil, fmt.Errorf("storage connection string has no AccountKey or SharedAccessSignature")
+	}
+
+	return sa, nil
+}
+
+// containerURL returns the URL of a container in the storage account
+func (sa *storageAccount) containerURL(container string) (*azblob.ContainerURL, error) {
+	u, err := url.Parse(sa.blobEndpoint + "/" + url.PathEscape(container))
+	if err != nil {
+		return nil, err
+	}
+	u.RawQuery = sa.sasToken
+
+	c := azblob.NewContainerURL(*u, azblob.NewPipeline(sa.credential, blobPipelineOptions()))
+	return &c, nil
+}
+
+// Build the self-hosted portal storage configuration.  Media and website
+// containers may be specified as SAS URLs, or as container names within the
+// storage account identified by the connection string
+func getSelfHostedConfig() (*apim.SelfHosted, error) {
+	var sa *storageAccount
+	if cs := viper.GetString("self-hosted.storage-connection-string"); cs != "" {
+		var err error
+		sa, err = parseStorageConnectionString(cs)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	c := &apim.SelfHosted{
+		PortalURL: strings.TrimSuffix(viper.GetString("self-hosted.portal-url"), "/"),
+	}
+
+	var err error
+
+	// Media container
+	switch {
+	case viper.GetString("self-hosted.media-sas-url") != "":
+		c.Media, err = apim.ContainerURLFromSasURL(viper.GetString("self-hosted.media-sas-url"), tracingHTTPClient())
+	case sa != nil:
+		c.Media, err = sa.containerURL(viper.GetString("self-hosted.media-container"))
+	default:
+		err = fmt.Errorf("self-hosted portal requires --storage-connection-string or --media-sas-url")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// Static website container, optional unless publishing
+	switch {
+	case viper.GetString("self-hosted.website-sas-url") != "":
+		c.Website, err = apim.ContainerURLFromSasURL(viper.GetString("self-hosted.website-sas-url"), tracingHTTPClient())
+	case sa != nil:
+		c.Website, err = sa.containerURL(viper.GetString("self-hosted.website-container"))
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	logging.Logger().Debugf("Self-hosted portal: %s, media container: %s", c.PortalURL, c.Media.URL().Path)
+
+	return c, nil
+}
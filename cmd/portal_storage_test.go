@@ -0,0 +1,59 @@
+package cmd
+
+import "testing"
+
+func TestParseStorageConnectionString(t *testing.T) {
+	tests := []struct {
+		cs           string
+		blobEndpoint string
+		sasToken     string
+		expectErr    bool
+	}{
+		{
+			"DefaultEndpointsProtocol=https;AccountName=portalst;AccountKey=c2VjcmV0;EndpointSuffix=core.windows.net",
+			"https://portalst.blob.core.windows.net", "", false,
+		},
+		{
+			"AccountName=portalst;AccountKey=c2VjcmV0",
+			"https://portalst.blob.core.windows.net", "", false,
+		},
+		{
+			"AccountName=portalst;AccountKey=c2VjcmV0;EndpointSuffix=core.chinacloudapi.cn;",
+			"https://portalst.blob.core.chinacloudapi.cn", "", false,
+		},
+		{
+			"BlobEndpoint=http://127.0.0.1:10000/devstoreaccount1/;AccountName=devstoreaccount1;AccountKey=c2VjcmV0",
+			"http://127.0.0.1:10000/devstoreaccount1", "", false,
+		},
+		{
+			"BlobEndpoint=https://portalst.blob.core.windows.net;SharedAccessSignature=sv=2019-12-12&sig=abc",
+			"https://portalst.blob.core.windows.net", "sv=2019-12-12&sig=abc", false,
+		},
+		{"AccountKey=c2VjcmV0", "", "", true},
+		{"AccountName=portalst", "", "", true},
+		{"AccountName=portalst;AccountKey=!!notbase64", "", "", true},
+		{"AccountName", "", "", true},
+	}
+
+	for _, tt := range tests {
+		sa, err := parseStorageConnectionString(tt.cs)
+		if tt.expectErr {
+			if err == nil {
+				t.Errorf("Expected error parsing %q", tt.cs)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("Parsing %q: %s", tt.cs, err)
+			continue
+		}
+
+		if sa.blobEndpoint != tt.blobEndpoint {
+			t.Errorf("Parsing %q: got endpoint %s, wanted %s", tt.cs, sa.blobEndpoint, tt.blobEndpoint)
+		}
+		if sa.sasToken != tt.sasToken {
+			t.Errorf("Parsing %q: got SAS token %s, wanted %s", tt.cs, sa.sasToken, tt.sasToken)
+		}
+	}
+}
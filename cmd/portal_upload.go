@@ -3,18 +3,18 @@ package cmd
 import (
 	"bytes"
 	"context"
-	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
-	"net/http"
-	"net/url"
+	"os"
 
-	"github.com/Azure/azure-storage-blob-go/azblob"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 
-	"github.com/jake-scott/apim-tools/internal/pkg/devportal"
 	"github.com/jake-scott/apim-tools/internal/pkg/logging"
+	"github.com/jake-scott/apim-tools/pkg/apim"
+	"github.com/jake-scott/apim-tools/pkg/devportal"
 )
 
 var portalUploadCmd = &cobra.Command{
@@ -24,10 +24,20 @@ var portalUploadCmd = &cobra.Command{
 
 By default, media that exists on the portal but is not in the archive, is
 deleted from the portal.  This behaviour can be controlled with the --nodelete
-option.`,
+option.
+
+The upload is refused if the archive was taken from a portal with a newer
+code version than the target portal, as the target may not be able to render
+the content.  Use --allow-version-mismatch to upload anyway.
+
+An upload that fails part way or is interrupted can be continued with
+--resume, which skips the content items and media already uploaded.
+
+With --in - the archive is read from stdin, eg. piped from a download run
+elsewhere.  Such an upload cannot be resumed.`,
 
 	RunE: func(cmd *cobra.Command, args []string) error {
-		if err := doPortalUpload(); err != nil {
+		if err := doPortalUpload(cmd.Context()); err != nil {
 			return err
 		}
 
@@ -37,251 +47,122 @@ option.`,
 
 func init() {
 	portalUploadCmd.Flags().StringVar(&portalCmdOpts.apimName, "apim", "", "API Manager instance")
-	portalUploadCmd.Flags().StringVar(&portalCmdOpts.backupFile, "in", "", "Zip archive to upload")
+	portalUploadCmd.Flags().StringVar(&portalCmdOpts.backupFile, "in", "", "Zip archive to upload, or - for stdin")
 	portalUploadCmd.Flags().StringVar(&portalCmdOpts.resourceGroup, "rg", "", "Resource group containing the APIM instance")
+	portalUploadCmd.Flags().StringVar(&portalCmdOpts.instanceID, "id", "", "Resource ID of the APIM instance, instead of --apim and --rg")
+	portalUploadCmd.Flags().BoolVar(&portalCmdOpts.allSubscriptions, "all-subscriptions", false, "Search all accessible subscriptions for --apim")
 	portalUploadCmd.Flags().BoolVar(&portalCmdOpts.nodelete, "nodelete", false, "Do not delete extraneous media from portal")
+	portalUploadCmd.Flags().BoolVar(&portalCmdOpts.resume, "resume", false, "Continue an interrupted upload, skipping the items already uploaded")
+	portalUploadCmd.Flags().BoolVar(&portalCmdOpts.allowVersionMismatch, "allow-version-mismatch", false, "Upload even if the target portal code version is older than the archive")
 
-	errPanic(portalUploadCmd.MarkFlagRequired("apim"))
 	errPanic(portalUploadCmd.MarkFlagRequired("in"))
-	errPanic(portalUploadCmd.MarkFlagRequired("rg"))
 
-	errPanic(viper.GetViper().BindPFlag("apim", portalUploadCmd.Flags().Lookup("apim")))
-	errPanic(viper.GetViper().BindPFlag("in", portalUploadCmd.Flags().Lookup("in")))
-	errPanic(viper.GetViper().BindPFlag("rg", portalUploadCmd.Flags().Lookup("rg")))
-	errPanic(viper.GetViper().BindPFlag("nodelete", portalUploadCmd.Flags().Lookup("nodelete")))
+	bindFlag(portalUploadCmd, "apim", "apim")
+	bindFlag(portalUploadCmd, "in", "in")
+	bindFlag(portalUploadCmd, "rg", "rg")
+	bindFlag(portalUploadCmd, "id", "id")
+	bindFlag(portalUploadCmd, "all-subscriptions", "all-subscriptions")
+	bindFlag(portalUploadCmd, "nodelete", "nodelete")
+	bindFlag(portalUploadCmd, "allow-version-mismatch", "allow-version-mismatch")
+	bindFlag(portalUploadCmd, "resume", "resume")
 
 	portalCmd.AddCommand(portalUploadCmd)
 }
 
-func doPortalUpload() error {
-	info, err := buildApimInfo(azureAPIVersion)
-	if err != nil {
-		return err
-	}
-
-	// Get a blob container object
-	u, _ := url.Parse(info.devPortalBlobStorageURL)
-	containerURL := azblob.NewContainerURL(*u, azblob.NewPipeline(azblob.NewAnonymousCredential(), azblob.PipelineOptions{}))
-
-	// Keep a list of what we uploaded
-	var blobList = make([]string, 0, 100)
-	var contentItemList = make([]string, 0, 100)
+// Summary of an upload
+type uploadResult struct {
+	Archive string `json:"archive"`
+	apim.UploadResult
+	Interrupted bool `json:"interrupted,omitempty"`
+}
 
-	// process the archive
-	ar, err := devportal.NewArchiveReader(viper.GetString("in"))
+func doPortalUpload(ctx context.Context) (err error) {
+	client, err := newPortalClient(ctx, apiOpContent)
 	if err != nil {
 		return err
 	}
-	defer ar.Close()
 
-	// Setup the callbacks
-	ar = ar.WithBlobHandler(func(name string, f devportal.ZipReadSeeker) error {
-		return uploadBlob(&containerURL, name, f, &blobList)
-	}).WithIndexHandler(func(f devportal.ZipReadSeeker) error {
-		return uploadContentItems(info.apimClient, info.apimMgmtURL, f, &contentItemList)
-	})
+	result := uploadResult{Archive: viper.GetString("in")}
+	defer func() { auditOperation("upload", client.Endpoints().InstanceURL, &result, err) }()
 
-	// Upload the content
-	if err := ar.Process(); err != nil {
+	if err := checkStdioArchive(result.Archive); err != nil {
 		return err
 	}
 
-	// Delete extra content unless told not to
-	if viper.GetBool("nodelete") {
-		logging.Logger().Infoln("Not deleting extra content (--nodelete)")
-	} else {
-		err = deleteExtraBlobs(&containerURL, blobList)
-		err2 := deleteExtraMediaItems(info.apimClient, info.apimMgmtURL, contentItemList)
-
-		switch {
-		case err == nil && err2 != nil:
-			err = err2
-		case err != nil && err2 != nil:
-			err = fmt.Errorf("deleting: %s AND %s", err, err2)
-		}
-	}
-
-	return err
-}
-
-func deleteExtraMediaItems(cli *apimClient, mgmtURL string, mediaList []string) error {
-	// Get content types used by the portal
-	contentTypes, err := getContentTypes(cli, mgmtURL)
+	// process the archive
+	ar, err := openArchiveReader(result.Archive)
 	if err != nil {
 		return err
 	}
+	defer ar.Close()
 
-	// Get content items for each content type
-	var allContentIds []string
-	for _, ct := range contentTypes {
-		subItems, err := getContentItemsAsMap(cli, mgmtURL, ct)
-		if err != nil {
-			return err
-		}
-
-		for _, item := range subItems {
-			allContentIds = append(allContentIds, item["id"].(string))
-		}
-	}
-
-	logging.Logger().Debugf("Found %d content items on portal", len(allContentIds))
-
-	// Find content items on the portal that were not in the Zip archive
-	extraItems := sliceSubtract(toInterfaceSlice(allContentIds), toInterfaceSlice(mediaList))
-
-	// Delete the extras
-	var cOK, cErr int
-	for _, idI := range extraItems {
-		id := idI.(string)
-
-		reqURL := apimMgmtURL(mgmtURL) + id
-		req, err := http.NewRequest("DELETE", reqURL, nil)
-		if err != nil {
-			return err
-		}
-
-		resp, err := cli.Do(req)
-		if err != nil {
-			cErr++
-			logging.Logger().Errorf("Deleting %s: %s", id, err)
-			continue
-		}
-
-		// Only accept HTTP 2xx codes
-		if resp.StatusCode >= 300 {
-			cErr++
-			logging.Logger().Errorf("Deleting %s: %s", id, resp.Status)
-			continue
-		}
-
-		cOK++
-	}
-
-	logging.Logger().Infof("Deleted %d extra content items, %d errors", cOK, cErr)
-
-	return nil
-}
-
-func deleteExtraBlobs(url *azblob.ContainerURL, blobList []string) error {
-	ctx := context.Background()
-	// Get a list of blobs in the container
-	var allBlobs = make([]string, 0, 100)
-
-	for marker := (azblob.Marker{}); marker.NotDone(); {
-		listBlobs, err := url.ListBlobsFlatSegment(ctx, marker, azblob.ListBlobsSegmentOptions{})
+	// Record the items uploaded so an interrupted upload can be resumed.  An
+	// archive read from stdin cannot be read again, so is not journalled.
+	var j *devportal.Journal
+	if result.Archive != stdioArchive {
+		j, err = openJournal(result.Archive, "upload", client.Endpoints().InstanceURL)
 		if err != nil {
 			return err
 		}
-
-		marker = listBlobs.NextMarker
-
-		for _, blobInfo := range listBlobs.Segment.BlobItems {
-			allBlobs = append(allBlobs, blobInfo.Name)
+		if j != nil {
+			defer j.Close()
 		}
 	}
 
-	logging.Logger().Debugf("Found %d blobs in container", len(allBlobs))
-
-	// Find blobs in the container that were not in the Zip archive
-	extraBlobs := sliceSubtract(toInterfaceSlice(allBlobs), toInterfaceSlice(blobList))
-
-	// Delete the extras
-	var cOK, cErr int
-	for _, blobNameI := range extraBlobs {
-		blobName := blobNameI.(string)
-		logging.Logger().Debugf("Deleting blob: %s", blobName)
-		blobURL := url.NewBlobURL(blobName)
-
-		_, err := blobURL.Delete(ctx, azblob.DeleteSnapshotsOptionNone, azblob.BlobAccessConditions{})
-		if err != nil {
-			logging.Logger().WithError(err).Errorf("Deleting BLOB %s", blobName)
-			cErr++
-		} else {
-			cOK++
-		}
+	opts := apim.UploadOptions{
+		NoDelete:             viper.GetBool("nodelete"),
+		AllowVersionMismatch: viper.GetBool("allow-version-mismatch"),
+		Journal:              j,
 	}
 
-	logging.Logger().Infof("Deleted %d extra media blobs, %d errors", cOK, cErr)
-
-	return nil
-}
-
-//nolint:interfacer
-func uploadContentItem(cli *apimClient, mgmtURL string, id string, item interface{}) error {
-	reqURL := apimMgmtURL(mgmtURL) + id
-
-	var requestBody []byte
-	requestBody, err := json.Marshal(item)
-	if err != nil {
-		return err
+	// Upload the content
+	result.UploadResult, err = client.Upload(ctx, &ar, opts)
+	if errors.Is(err, apim.ErrVersionMismatch) {
+		// Nothing was uploaded, so there is nothing to resume
+		finishJournal(j, false)
+		return fmt.Errorf("%s.  Use --allow-version-mismatch to upload anyway", err)
 	}
-
-	req, err := http.NewRequest("PUT", reqURL, ioutil.NopCloser(bytes.NewBuffer(requestBody)))
-	if err != nil {
+	if err != nil && !interrupted(ctx) {
 		return err
 	}
-	req.Header.Set("Content-Type", "application/json")
+	result.Interrupted = interrupted(ctx)
 
-	resp, err := cli.Do(req)
-	if err != nil {
-		return err
-	}
+	finishJournal(j, result.Interrupted || result.ContentItems.Errors > 0 || result.Blobs.Errors > 0)
 
-	// Only accept HTTP 2xx codes
-	if resp.StatusCode >= 300 {
-		return fmt.Errorf("status %s received", resp.Status)
+	err = writeResult(result, func(w io.Writer) error {
+		fmt.Fprintf(w, "              Archive: %s\n", result.Archive)
+		fmt.Fprintf(w, "        Content items: %s\n", result.ContentItems)
+		fmt.Fprintf(w, "          Media blobs: %s\n", result.Blobs)
+		if !opts.NoDelete {
+			fmt.Fprintf(w, "Deleted content items: %s\n", result.DeletedContentItems)
+			fmt.Fprintf(w, "  Deleted media blobs: %s\n", result.DeletedBlobs)
+		}
+		if result.Interrupted {
+			fmt.Fprintf(w, "          Interrupted: the rest of the archive was not uploaded\n")
+		}
+		return nil
+	})
+	if err == nil && result.Interrupted {
+		err = errInterrupted
 	}
 
-	logging.Logger().Debugf("Uploaded item %s", id)
-	return nil
+	return err
 }
 
-func uploadContentItems(cli *apimClient, mgmtURL string, f devportal.ZipReadSeeker, list *[]string) error {
-	// Get the index contents
-	data, err := ioutil.ReadAll(&f)
-	if err != nil {
-		return err
-	}
-
-	var items []map[string]interface{}
-	if err := json.Unmarshal(data, &items); err != nil {
-		return err
-	}
-
-	logging.Logger().Infof("Processing %d content items", len(items))
-
-	var cOK, cErr int
-
-	// Grab the ID from each item and upload the item
-	for _, item := range items {
-		key := item["id"].(string)
-		delete(item, "id")
-
-		err := uploadContentItem(cli, mgmtURL, key, item)
+// Open the archive, or read it from stdin if archive is -.  A Zip archive
+// is read from its end, so stdin is read into memory first.
+func openArchiveReader(archive string) (ar devportal.ArchiveReader, err error) {
+	if archive != stdioArchive {
+		ar, err = devportal.NewArchiveReader(archive)
+	} else {
+		var data []byte
+		data, err = ioutil.ReadAll(os.Stdin)
 		if err != nil {
-			logging.Logger().Errorf("Uploading content item %s: %s", key, err)
-			cErr++
-		} else {
-			*list = append(*list, key)
-			cOK++
+			return ar, fmt.Errorf("reading archive from stdin: %s", err)
 		}
-	}
-
-	logging.Logger().Infof("  -> Total %d items, %d errors", cOK, cErr)
 
-	return nil
-}
-
-func uploadBlob(url *azblob.ContainerURL, name string, f devportal.ZipReadSeeker, list *[]string) error {
-	logging.Logger().Debugf("Uploading media blob %s", name)
-	blobURL := url.NewBlockBlobURL(name)
-	_, err := blobURL.Upload(context.Background(), &f, azblob.BlobHTTPHeaders{ContentType: "text/plain"}, azblob.Metadata{}, azblob.BlobAccessConditions{})
-
-	if err != nil {
-		return err
+		ar, err = devportal.NewArchiveReaderAt(bytes.NewReader(data), int64(len(data)))
 	}
 
-	*list = append(*list, name)
-
-	return nil
+	return ar.WithLogger(logging.Logger()), err
 }
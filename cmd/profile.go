@@ -0,0 +1,24 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/spf13/viper"
+)
+
+// Merge the settings of the selected profile over the top level config, so
+// that profiles.<name>.apim is read as apim, profiles.<name>.auth.tenant as
+// auth.tenant and so on.  Command line options still take precedence.
+func applyProfile(v *viper.Viper) error {
+	name := v.GetString("profile")
+	if name == "" {
+		return nil
+	}
+
+	key := "profiles." + name
+	if !v.IsSet(key) {
+		return fmt.Errorf("profile %s not found in the config", name)
+	}
+
+	return v.MergeConfigMap(v.GetStringMap(key))
+}
@@ -0,0 +1,118 @@
+package cmd
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/Azure/go-autorest/autorest"
+	"github.com/spf13/viper"
+
+	"github.com/jake-scott/apim-tools/internal/pkg/apimfake"
+)
+
+const profileTestConfig = `
+apim: dev-apim
+rg: dev-rg
+auth:
+  tenant: shared-tenant
+  subscription: dev-sub
+profiles:
+  prod:
+    apim: prod-apim
+    rg: prod-rg
+    auth:
+      subscription: prod-sub
+`
+
+func TestApplyProfile(t *testing.T) {
+	tests := []struct {
+		profile      string
+		apim         string
+		subscription string
+		expectErr    bool
+	}{
+		{"", "dev-apim", "dev-sub", false},
+		{"prod", "prod-apim", "prod-sub", false},
+		{"staging", "", "", true},
+	}
+
+	for _, tt := range tests {
+		v := viper.New()
+		v.SetConfigType("yaml")
+		if err := v.ReadConfig(strings.NewReader(profileTestConfig)); err != nil {
+			t.Fatal(err)
+		}
+		v.Set("profile", tt.profile)
+
+		err := applyProfile(v)
+		if tt.expectErr {
+			if err == nil {
+				t.Errorf("Expected error applying profile %q", tt.profile)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("Applying profile %q: %s", tt.profile, err)
+			continue
+		}
+
+		if got := v.GetString("apim"); got != tt.apim {
+			t.Errorf("Profile %q: got apim %s, wanted %s", tt.profile, got, tt.apim)
+		}
+		if got := v.GetString("auth.subscription"); got != tt.subscription {
+			t.Errorf("Profile %q: got subscription %s, wanted %s", tt.profile, got, tt.subscription)
+		}
+
+		// Settings not in the profile are inherited
+		if got := v.GetString("auth.tenant"); got != "shared-tenant" {
+			t.Errorf("Profile %q: got tenant %s, wanted shared-tenant", tt.profile, got)
+		}
+	}
+}
+
+// Options given to a command take precedence over the selected profile,
+// whichever command defines them
+func TestProfileCommandLinePrecedence(t *testing.T) {
+	f := apimfake.NewServer()
+	defer f.Close()
+
+	resourceManager.endpoint = f.ResourceManagerURL()
+	resourceManager.authorizer = autorest.NullAuthorizer{}
+	defer func() {
+		resourceManager.endpoint = ""
+		resourceManager.authorizer = nil
+	}()
+
+	config := writeTestConfig(t, `
+apim: dev-apim
+rg: dev-rg
+profiles:
+  prod:
+    apim: prod-apim
+    rg: prod-rg
+`)
+
+	for _, command := range []string{"status", "endpoints", "reset", "upload"} {
+		args := []string{"devportal", command, "--config", config, "--profile", "prod"}
+		if command == "upload" {
+			args = append(args, "--in", "no-such-archive.zip")
+		}
+
+		// The profile's instance does not exist
+		if err := runCommand(context.Background(), args...); err == nil {
+			t.Errorf("%s: expected an error with the profile's instance", command)
+		}
+
+		err := runCommand(context.Background(), append(args, "--apim", apimfake.Name, "--rg", apimfake.ResourceGroup)...)
+		if command == "upload" {
+			// The instance was found, the archive was not
+			if err == nil || !strings.Contains(err.Error(), "no-such-archive.zip") {
+				t.Errorf("%s: expected the archive to be missing, got %v", command, err)
+			}
+		} else if err != nil {
+			t.Errorf("%s: %s", command, err)
+		}
+	}
+}
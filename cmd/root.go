@@ -1,8 +1,10 @@
 package cmd
 
 import (
+	"errors"
 	"fmt"
 	"os"
+	"strings"
 
 	"github.com/mitchellh/go-homedir"
 	"github.com/sirupsen/logrus"
@@ -11,27 +13,73 @@ import (
 
 	"github.com/jake-scott/apim-tools/internal/pkg/auth"
 	"github.com/jake-scott/apim-tools/internal/pkg/logging"
+	"github.com/jake-scott/apim-tools/internal/pkg/progress"
+	"github.com/jake-scott/apim-tools/pkg/apim"
 	"github.com/jake-scott/apim-tools/version"
 )
 
 var (
 	cfgFile        string
+	profile        string
 	debug          bool
+	outputSpec     string
 	subscriptionID string
 	clientID       string
 	clientSecret   string
 	certPath       string
 	certPass       string
 	tenant         string
+	environment    string
+	metadataURL    string
+	traceHTTP      string
+	progressMode   string
+	useMSI         bool
+	msiClientID    string
+	msiEndpoint    string
+	federatedToken string
+	useOIDC        bool
 )
 
 const (
-	azureLoginEndpoint      = "https://login.microsoftonline.com"
-	azureManagementEndpoint = "https://management.azure.com"
-	azureAPIVersion         = "2019-12-01"
-	tokenValidityPeriod     = 30 // minutes
+	azureAPIVersion = apim.DefaultAPIVersion // default management API version
+
+	envPrefix = "APIM_TOOLS"
 )
 
+// Annotation for commands that run without Azure credentials
+const skipAuthAnnotation = "skip-auth"
+
+// Maps config keys to environment variable names
+var envKeyReplacer = strings.NewReplacer(".", "_", "-", "_")
+
+// A config key and the option it is bound to
+type flagKey struct {
+	key  string
+	flag string
+}
+
+// Config keys that the global options are bound to
+var globalFlagKeys = []flagKey{
+	{"profile", "profile"},
+	{"debug", "debug"},
+	{"output", "output"},
+	{"trace-http", "trace-http"},
+	{"progress", "progress"},
+	{"auth.subscription", "subscription"},
+	{"auth.client-id", "client-id"},
+	{"auth.client-secret", "client-secret"},
+	{"auth.cert-file", "cert-file"},
+	{"auth.cert-password", "cert-password"},
+	{"auth.tenant", "tenant"},
+	{"auth.use-msi", "use-msi"},
+	{"auth.msi-client-id", "msi-client-id"},
+	{"auth.msi-endpoint", "msi-endpoint"},
+	{"auth.federated-token-file", "federated-token-file"},
+	{"auth.use-oidc", "use-oidc"},
+	{"auth.environment", "environment"},
+	{"auth.metadata-endpoint", "metadata-endpoint"},
+}
+
 // rootCmd represents the base command when called without any subcommands
 var rootCmd = &cobra.Command{
 	Use:   "apim-tools",
@@ -45,8 +93,22 @@ var rootCmd = &cobra.Command{
 // Execute adds all child commands to the root command and sets flags appropriately.
 // This is called by main.main(). It only needs to happen once to the rootCmd.
 func Execute() {
-	if err := rootCmd.Execute(); err != nil {
+	// Commands stop cleanly on Ctrl-C
+	ctx, stop := signalContext()
+	err := rootCmd.ExecuteContext(ctx)
+	stop()
+
+	// The trace is most useful when something went wrong
+	writeHTTPTrace()
+
+	// Send any log entries still held for syslog or Azure Monitor
+	logging.Close()
+
+	if err != nil {
 		fmt.Println(err)
+		if errors.Is(err, errInterrupted) {
+			os.Exit(130)
+		}
 		os.Exit(1)
 	}
 }
@@ -55,7 +117,11 @@ func init() {
 	cobra.OnInitialize(initConfig)
 
 	rootCmd.PersistentFlags().StringVar(&cfgFile, "config", "", "config file (default is $HOME/.apim-tools.yaml)")
+	rootCmd.PersistentFlags().StringVar(&profile, "profile", "", "config profile to use (default is $APIM_TOOLS_PROFILE)")
 	rootCmd.PersistentFlags().BoolVarP(&debug, "debug", "d", false, "enable debugging (default: false)")
+	rootCmd.PersistentFlags().StringVarP(&outputSpec, "output", "o", "text", "output format: text, json, yaml, table or template=<go-template>")
+	rootCmd.PersistentFlags().StringVar(&traceHTTP, "trace-http", "", "record HTTP requests and responses to a HAR file")
+	rootCmd.PersistentFlags().StringVar(&progressMode, "progress", "auto", "show transfer progress: auto, bar, log or none")
 
 	rootCmd.PersistentFlags().StringVar(&subscriptionID, "subscription", "", "Azure subscription ID")
 	rootCmd.PersistentFlags().StringVar(&clientID, "client-id", "", "OAuth client ID (AAD App ID)")
@@ -63,14 +129,17 @@ func init() {
 	rootCmd.PersistentFlags().StringVar(&certPath, "cert-file", "", "PKCS12 (.pfx) cert/key")
 	rootCmd.PersistentFlags().StringVar(&certPass, "cert-password", "", "cert-file passphrase")
 	rootCmd.PersistentFlags().StringVar(&tenant, "tenant", "", "Azure tenant name or ID")
+	rootCmd.PersistentFlags().BoolVar(&useMSI, "use-msi", false, "Authenticate with a managed identity")
+	rootCmd.PersistentFlags().StringVar(&msiClientID, "msi-client-id", "", "Client ID of a user-assigned managed identity")
+	rootCmd.PersistentFlags().StringVar(&msiEndpoint, "msi-endpoint", "", "Managed identity endpoint (default: detected)")
+	rootCmd.PersistentFlags().StringVar(&federatedToken, "federated-token-file", "", "File containing an OIDC token for workload identity federation")
+	rootCmd.PersistentFlags().BoolVar(&useOIDC, "use-oidc", false, "Authenticate with a GitHub Actions OIDC token")
+	rootCmd.PersistentFlags().StringVar(&environment, "environment", "", "Azure cloud: public, usgovernment, china or german (default public)")
+	rootCmd.PersistentFlags().StringVar(&metadataURL, "metadata-endpoint", "", "Resource Manager endpoint to load a custom cloud's metadata from")
 
-	errPanic(viper.BindPFlag("debug", rootCmd.PersistentFlags().Lookup("debug")))
-	errPanic(viper.BindPFlag("auth.subscription", rootCmd.PersistentFlags().Lookup("subscription")))
-	errPanic(viper.BindPFlag("auth.client-id", rootCmd.PersistentFlags().Lookup("client-id")))
-	errPanic(viper.BindPFlag("auth.client-secret", rootCmd.PersistentFlags().Lookup("client-secret")))
-	errPanic(viper.BindPFlag("auth.cert-file", rootCmd.PersistentFlags().Lookup("cert-file")))
-	errPanic(viper.BindPFlag("auth.cert-pass", rootCmd.PersistentFlags().Lookup("subscription")))
-	errPanic(viper.BindPFlag("auth.tenant", rootCmd.PersistentFlags().Lookup("tenant")))
+	for _, b := range globalFlagKeys {
+		errPanic(viper.BindPFlag(b.key, rootCmd.PersistentFlags().Lookup(b.flag)))
+	}
 }
 
 func er(msg interface{}) {
@@ -95,29 +164,101 @@ func initConfig() {
 		viper.SetConfigName(".apim-tools")
 	}
 
-	viper.AutomaticEnv() // read in environment variables that match
+	// read in environment variables that match, eg. APIM_TOOLS_AUTH_CLIENT_ID
+	// for auth.client-id
+	viper.SetEnvPrefix(envPrefix)
+	viper.SetEnvKeyReplacer(envKeyReplacer)
+	viper.AutomaticEnv()
 
 	// If a config file is found, read it in.
 	if err := viper.ReadInConfig(); err == nil {
 		fmt.Fprintln(os.Stderr, "Using config file:", viper.ConfigFileUsed())
 	}
+
+	// Settings from the selected profile override the top level ones
+	if err := applyProfile(viper.GetViper()); err != nil {
+		er(err)
+	}
 }
 
 // Runs before any command handlers - set up logging and auth
 func doConfigure(cmd *cobra.Command, args []string) error {
+	// Options of the command being run take precedence over the config
+	if err := bindCommandFlags(cmd); err != nil {
+		return err
+	}
+	configureInstanceOptions(cmd)
+
+	// Some commands don't talk to Azure, and Key Vault or keyring secrets
+	// are not fetched for them
+	skipAuth := cmd.Annotations[skipAuthAnnotation] != ""
+
+	// Replace secret references with the secrets themselves
+	if !skipAuth {
+		if err := resolveSecrets(); err != nil {
+			return err
+		}
+	}
+
 	if viper.GetBool("debug") {
 		logrus.SetLevel(logrus.DebugLevel)
 	}
 
-	// Initialize logging
-	if err := logging.Configure(viper.GetViper()); err != nil {
+	// Initialize logging.  Azure Monitor may need a secret or a token.
+	configureLogging := logging.Configure
+	if skipAuth {
+		configureLogging = logging.ConfigureLocal
+	}
+	if err := configureLogging(viper.GetViper()); err != nil {
+		return err
+	}
+
+	if err := configureOutput(); err != nil {
 		return err
 	}
 
+	configureHTTPTrace()
+
+	if err := progress.Configure(viper.GetString("progress")); err != nil {
+		return err
+	}
+
+	if skipAuth {
+		return nil
+	}
+
 	if err := auth.Configure(viper.GetViper()); err != nil {
 		return err
 	}
 
+	// Azure Monitor log targets may need a token
+	logging.SetAuthorizer(auth.Authorizer)
+
+	return nil
+}
+
+// Config keys bound to the options of each command.  viper binds a key to
+// one flag only, and many commands have eg. --apim, so the keys are bound to
+// the options of the command being run when it starts.
+var commandFlagKeys = make(map[*cobra.Command][]flagKey)
+
+// Bind the config key to the option of cmd when cmd is run
+func bindFlag(cmd *cobra.Command, key, flag string) {
+	if cmd.Flags().Lookup(flag) == nil {
+		panic(fmt.Sprintf("command %s has no --%s option", cmd.Name(), flag))
+	}
+
+	commandFlagKeys[cmd] = append(commandFlagKeys[cmd], flagKey{key, flag})
+}
+
+// Bind the config keys of the command being run to its options
+func bindCommandFlags(cmd *cobra.Command) error {
+	for _, b := range commandFlagKeys[cmd] {
+		if err := viper.BindPFlag(b.key, cmd.Flags().Lookup(b.flag)); err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
 
@@ -0,0 +1,65 @@
+package cmd
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+// Credentials that configure without contacting Azure, for tests that run
+// commands against a fake instance
+const testAuthConfig = `
+auth:
+  tenant: 00000000-0000-0000-0000-000000000001
+  client-id: 00000000-0000-0000-0000-000000000002
+  client-secret: not-a-secret
+  subscription: 00000000-0000-0000-0000-000000000000
+`
+
+// Write a config file for the test, with the test credentials
+func writeTestConfig(t *testing.T, config string) string {
+	dir, err := ioutil.TempDir("", "apim-config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	path := filepath.Join(dir, "apim-tools.yaml")
+	if err := ioutil.WriteFile(path, []byte(testAuthConfig+config), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	return path
+}
+
+// Run a command line as the apim-tools binary does.  The options given to
+// earlier runs are cleared first, as cobra keeps them.  The options of
+// every command are cleared, as those of different commands may share a
+// variable.
+func runCommand(ctx context.Context, args ...string) error {
+	resetFlags(rootCmd)
+
+	rootCmd.SetArgs(args)
+	return rootCmd.ExecuteContext(ctx)
+}
+
+// Clear the options of cmd and its subcommands
+func resetFlags(cmd *cobra.Command) {
+	for _, fs := range []*pflag.FlagSet{cmd.Flags(), cmd.PersistentFlags()} {
+		fs.VisitAll(func(f *pflag.Flag) {
+			if f.Changed {
+				errPanic(f.Value.Set(f.DefValue))
+				f.Changed = false
+			}
+		})
+	}
+
+	for _, c := range cmd.Commands() {
+		resetFlags(c)
+	}
+}
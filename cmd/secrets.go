@@ -0,0 +1,50 @@
+package cmd
+
+import (
+	"github.com/Azure/go-autorest/autorest"
+	"github.com/spf13/viper"
+
+	"github.com/jake-scott/apim-tools/internal/pkg/auth"
+	"github.com/jake-scott/apim-tools/internal/pkg/secrets"
+)
+
+// Config keys whose values may be secret references
+var secretConfigKeys = []string{
+	"auth.client-secret",
+	"auth.cert-password",
+	"self-hosted.storage-connection-string",
+	"self-hosted.media-sas-url",
+	"self-hosted.website-sas-url",
+	"logging.azure-monitor.shared-key",
+}
+
+// Resolve any secret references in the config.  Key Vault secrets are
+// fetched with a credential that needs no secrets of its own.
+func resolveSecrets() error {
+	v := viper.GetViper()
+
+	found := false
+	for _, key := range secretConfigKeys {
+		if secrets.IsReference(v.GetString(key)) {
+			found = true
+		}
+	}
+
+	if !found {
+		return nil
+	}
+
+	env, err := auth.EnvironmentFromConfig(v)
+	if err != nil {
+		return err
+	}
+
+	r := &secrets.Resolver{
+		KeyVault: secrets.KeyVault(env.KeyVaultDNSSuffix, env.ResourceIdentifiers.KeyVault,
+			func(resource string) (autorest.Authorizer, error) {
+				return auth.SecretsAuthorizer(v, resource)
+			}),
+	}
+
+	return r.ResolveConfig(v, secretConfigKeys)
+}
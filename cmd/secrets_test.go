@@ -0,0 +1,43 @@
+package cmd
+
+import (
+	"context"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+// Secret references are resolved by the commands that talk to Azure only
+func TestResolveSecretsWhenNeeded(t *testing.T) {
+	fakeInstance(t)
+
+	secretEnv := configEnvName("auth.client-secret")
+	defer func() {
+		os.Unsetenv(secretEnv)
+		os.Unsetenv("APIM_TOOLS_TEST_SECRET")
+		viper.Set("auth.client-secret", nil)
+	}()
+
+	// Fetching the secret would need Azure
+	os.Setenv(secretEnv, "keyvault://no-such-vault/secret")
+	for _, args := range [][]string{{"version"}, {"config", "show"}} {
+		if err := runCommand(context.Background(), append(args, "--config", writeTestConfig(t, ""))...); err != nil {
+			t.Errorf("%s: %s", strings.Join(args, " "), err)
+		}
+	}
+
+	os.Setenv(secretEnv, "env://APIM_TOOLS_TEST_SECRET")
+	if err := runPortalCommand(t, "status"); err == nil || !strings.Contains(err.Error(), "APIM_TOOLS_TEST_SECRET") {
+		t.Errorf("Expected the unresolved secret to be reported, got %v", err)
+	}
+
+	os.Setenv("APIM_TOOLS_TEST_SECRET", "not-a-secret")
+	if err := runPortalCommand(t, "status"); err != nil {
+		t.Errorf("status: %s", err)
+	}
+	if got := viper.GetString("auth.client-secret"); got != "not-a-secret" {
+		t.Errorf("Got client secret %q, wanted the resolved secret", got)
+	}
+}
@@ -0,0 +1,68 @@
+package cmd
+
+import (
+	"net/http"
+
+	"github.com/Azure/azure-storage-blob-go/azblob"
+	"github.com/spf13/viper"
+
+	"github.com/jake-scott/apim-tools/internal/pkg/har"
+	"github.com/jake-scott/apim-tools/internal/pkg/logging"
+	"github.com/jake-scott/apim-tools/pkg/apim"
+	"github.com/jake-scott/apim-tools/version"
+)
+
+// Records HTTP requests when --trace-http is given
+var httpRecorder *har.Recorder
+
+// Start recording HTTP requests if a trace file was requested
+func configureHTTPTrace() {
+	if viper.GetString("trace-http") != "" {
+		httpRecorder = har.NewRecorder("apim-tools", version.Version)
+	}
+}
+
+// The transport used for requests to Azure and the developer portal
+func httpTransport() http.RoundTripper {
+	if httpRecorder == nil {
+		return http.DefaultTransport
+	}
+
+	return httpRecorder.Transport(http.DefaultTransport)
+}
+
+// An HTTP client for requests that need no Azure credentials
+func httpClient() *http.Client {
+	return &http.Client{Transport: httpTransport()}
+}
+
+// An HTTP client for library requests if they are being traced, else nil
+// so that the libraries keep their default clients
+func tracingHTTPClient() *http.Client {
+	if httpRecorder == nil {
+		return nil
+	}
+
+	return httpClient()
+}
+
+// Options for blob storage pipelines.  The azblob default sender is kept
+// unless requests are being traced.
+func blobPipelineOptions() azblob.PipelineOptions {
+	return apim.PipelineOptions(tracingHTTPClient())
+}
+
+// Write the recorded requests to the trace file
+func writeHTTPTrace() {
+	if httpRecorder == nil {
+		return
+	}
+
+	path := viper.GetString("trace-http")
+	if err := httpRecorder.WriteFile(path); err != nil {
+		logging.Logger().WithError(err).Errorf("Writing HTTP trace to %s", path)
+		return
+	}
+
+	logging.Logger().Infof("%d HTTP requests written to %s", httpRecorder.Entries(), path)
+}
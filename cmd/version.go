@@ -1,18 +1,18 @@
 package cmd
 
 import (
-	"encoding/json"
 	"fmt"
+	"io"
 
 	"github.com/spf13/cobra"
-	"github.com/spf13/viper"
 
 	"github.com/jake-scott/apim-tools/version"
 )
 
 var versionCmd = &cobra.Command{
-	Use:   "version",
-	Short: "Display the version number of the tool",
+	Use:         "version",
+	Short:       "Display the version number of the tool",
+	Annotations: map[string]string{skipAuthAnnotation: "true"},
 
 	RunE: func(cmd *cobra.Command, args []string) error {
 		if err := doVersion(); err != nil {
@@ -24,8 +24,8 @@ var versionCmd = &cobra.Command{
 }
 
 func init() {
-	versionCmd.Flags().BoolVar(&portalCmdOpts.asJSON, "json", false, "Return version as JSON")
-	errPanic(viper.GetViper().BindPFlag("json", versionCmd.Flags().Lookup("json")))
+	versionCmd.Flags().BoolVar(&portalCmdOpts.asJSON, "json", false, "Return version as JSON (same as --output json)")
+	bindFlag(versionCmd, "json", "json")
 
 	rootCmd.AddCommand(versionCmd)
 }
@@ -35,20 +35,12 @@ type versionResult struct {
 }
 
 func doVersion() error {
-	if viper.GetBool("json") {
-		v := versionResult{
-			Version: version.Version,
-		}
-
-		b, err := json.MarshalIndent(v, "", "    ")
-		if err != nil {
-			return err
-		}
-
-		fmt.Println(string(b))
-	} else {
-		fmt.Printf("apim-tools version %s\n", version.Version)
+	v := versionResult{
+		Version: version.Version,
 	}
 
-	return nil
+	return writeResult(v, func(w io.Writer) error {
+		_, err := fmt.Fprintf(w, "apim-tools version %s\n", version.Version)
+		return err
+	})
 }
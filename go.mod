@@ -3,12 +3,17 @@ module github.com/jake-scott/apim-tools
 go 1.14
 
 require (
+	github.com/Azure/azure-pipeline-go v0.2.2
 	github.com/Azure/azure-storage-blob-go v0.10.0
 	github.com/Azure/go-autorest/autorest v0.11.10
+	github.com/Azure/go-autorest/autorest/adal v0.9.5
 	github.com/google/uuid v1.1.2
 	github.com/hashicorp/go-azure-helpers v0.12.0
 	github.com/mitchellh/go-homedir v1.1.0
 	github.com/sirupsen/logrus v1.7.0
+	github.com/spf13/cast v1.3.0
 	github.com/spf13/cobra v1.0.0
+	github.com/spf13/pflag v1.0.3
 	github.com/spf13/viper v1.7.1
+	gopkg.in/yaml.v2 v2.2.4
 )
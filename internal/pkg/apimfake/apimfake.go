@@ -0,0 +1,474 @@
+package apimfake
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+ *  A fake API Manager instance for tests, needing no Azure account.  It serves
+ *  the parts of Resource Manager, the management API, the developer portal
+ *  and the portal's media blob container that apim-tools uses, each on its
+ *  own httptest server, and keeps the portal content in memory.
+ */
+
+// Identifiers of the fake instance
+const (
+	SubscriptionID = "00000000-0000-0000-0000-000000000000"
+	ResourceGroup  = "apim-rg"
+	Name           = "apim-fake"
+	InstanceID     = "/subscriptions/" + SubscriptionID + "/resourceGroups/" + ResourceGroup +
+		"/providers/Microsoft.ApiManagement/service/" + Name
+)
+
+// The management API is addressed with placeholder IDs, as the instance is
+// already known from the host name
+const mgmtPrefix = "/subscriptions/00000/resourceGroups/00000/providers/Microsoft.ApiManagement/service/00000"
+
+// Content types served by the management API
+var defaultContentTypes = []string{"blob", "document", "layout", "navigation", "page", "url"}
+
+// The portal publish date format, to the minute
+const portalVersionFormat = "200601021504"
+
+// Server is a fake API Manager instance
+type Server struct {
+	mu sync.Mutex
+
+	arm    *httptest.Server
+	mgmt   *httptest.Server
+	portal *httptest.Server
+	blob   *httptest.Server
+
+	// SAS tokens issued for the management API
+	tokens map[string]bool
+
+	contentTypes []string
+	items        map[string]map[string]interface{}
+	blobs        map[string]*blob
+	etag         int
+
+	deployed      bool
+	codeVersion   string
+	version       string
+	portalVersion time.Time
+	publishes     int
+}
+
+// NewServer starts a fake instance with a deployed, empty portal.  Close
+// must be called to stop it.
+func NewServer() *Server {
+	s := &Server{
+		tokens:        make(map[string]bool),
+		contentTypes:  defaultContentTypes,
+		items:         make(map[string]map[string]interface{}),
+		blobs:         make(map[string]*blob),
+		deployed:      true,
+		codeVersion:   "20200925173036",
+		version:       "2.11.0",
+		portalVersion: time.Date(2020, 10, 1, 12, 0, 0, 0, time.UTC),
+	}
+
+	s.arm = httptest.NewServer(http.HandlerFunc(s.serveARM))
+	s.mgmt = httptest.NewServer(s.withToken(s.serveMgmt))
+	s.portal = httptest.NewServer(http.HandlerFunc(s.servePortal))
+	s.blob = httptest.NewServer(http.HandlerFunc(s.serveBlob))
+
+	return s
+}
+
+// Close stops the servers
+func (s *Server) Close() {
+	s.arm.Close()
+	s.mgmt.Close()
+	s.portal.Close()
+	s.blob.Close()
+}
+
+// ResourceManagerURL is the Resource Manager endpoint to use in place of
+// that of the Azure cloud
+func (s *Server) ResourceManagerURL() string {
+	return s.arm.URL
+}
+
+// ManagementURL is the management API URL of the instance
+func (s *Server) ManagementURL() string {
+	return s.mgmt.URL
+}
+
+// PortalURL is the developer portal URL of the instance
+func (s *Server) PortalURL() string {
+	return s.portal.URL
+}
+
+// ContainerURL is the URL of the portal media container, without a SAS
+func (s *Server) ContainerURL() string {
+	return s.blob.URL + "/" + AccountName + "/" + ContainerName
+}
+
+// SetDeployed sets whether the portal has been deployed.  An undeployed
+// portal has no status.
+func (s *Server) SetDeployed(deployed bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.deployed = deployed
+}
+
+// SetCodeVersion sets the portal code version reported in its status
+func (s *Server) SetCodeVersion(codeVersion string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.codeVersion = codeVersion
+}
+
+// Publishes returns the number of times the portal has been published
+func (s *Server) Publishes() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.publishes
+}
+
+// PutContentItem adds or replaces a content item, id being of the form
+// /contentTypes/<type>/contentItems/<name>
+func (s *Server) PutContentItem(id string, item map[string]interface{}) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.putItem(id, item)
+}
+
+// ContentItems returns a copy of the content items by ID
+func (s *Server) ContentItems() map[string]map[string]interface{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	out := make(map[string]map[string]interface{}, len(s.items))
+	for id, item := range s.items {
+		out[id] = copyItem(item)
+	}
+
+	return out
+}
+
+// Resource Manager: the instance and its user tokens
+func (s *Server) serveARM(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Query().Get("api-version") == "" {
+		armError(w, http.StatusBadRequest, "MissingApiVersionParameter", "The api-version query parameter is required")
+		return
+	}
+
+	path := strings.TrimSuffix(r.URL.Path, "/")
+	switch {
+	case r.Method == "GET" && strings.EqualFold(path, InstanceID):
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"id":       InstanceID,
+			"name":     Name,
+			"location": "West Europe",
+			"sku":      map[string]interface{}{"name": "Developer", "capacity": 1},
+			"properties": map[string]interface{}{
+				"provisioningState":  "Succeeded",
+				"gatewayUrl":         "https://" + Name + ".azure-api.net",
+				"managementApiURL":   s.mgmt.URL,
+				"developerPortalURL": s.portal.URL,
+			},
+		})
+
+	case r.Method == "GET" && strings.EqualFold(path, "/subscriptions/"+SubscriptionID+"/providers/Microsoft.ApiManagement/service"):
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"value": []map[string]string{{"id": InstanceID, "name": Name}},
+		})
+
+	case r.Method == "POST" && strings.HasPrefix(strings.ToLower(path), strings.ToLower(InstanceID+"/users/")) &&
+		strings.HasSuffix(path, "/token"):
+		s.serveToken(w, r)
+
+	default:
+		armError(w, http.StatusNotFound, "ResourceNotFound", "The resource "+path+" was not found")
+	}
+}
+
+// Issue a management API SAS token
+func (s *Server) serveToken(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Properties struct {
+			KeyType string `json:"keyType"`
+			Expiry  string `json:"expiry"`
+		} `json:"properties"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		armError(w, http.StatusBadRequest, "InvalidRequestContent", err.Error())
+		return
+	}
+
+	expiry, err := time.Parse(time.RFC3339Nano, req.Properties.Expiry)
+	if err != nil {
+		armError(w, http.StatusBadRequest, "ValidationError", "Invalid expiry: "+err.Error())
+		return
+	}
+
+	if req.Properties.KeyType != "primary" && req.Properties.KeyType != "secondary" {
+		armError(w, http.StatusBadRequest, "ValidationError", "Invalid key type "+req.Properties.KeyType)
+		return
+	}
+
+	s.mu.Lock()
+	token := fmt.Sprintf("integration&%s&fake%d", expiry.UTC().Format(portalVersionFormat), len(s.tokens))
+	s.tokens[token] = true
+	s.mu.Unlock()
+
+	writeJSON(w, http.StatusOK, map[string]string{"value": token})
+}
+
+// Reject management API and portal requests without an issued SAS token
+func (s *Server) withToken(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "SharedAccessSignature ")
+
+		s.mu.Lock()
+		ok := s.tokens[token]
+		s.mu.Unlock()
+
+		if !ok {
+			armError(w, http.StatusUnauthorized, "Unauthorized", "Invalid SharedAccessSignature")
+			return
+		}
+
+		next(w, r)
+	}
+}
+
+// Management API: media secrets and portal content
+func (s *Server) serveMgmt(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Query().Get("api-version") == "" {
+		armError(w, http.StatusBadRequest, "MissingApiVersionParameter", "The api-version query parameter is required")
+		return
+	}
+
+	if !strings.HasPrefix(r.URL.Path, mgmtPrefix+"/") {
+		armError(w, http.StatusNotFound, "ResourceNotFound", "The resource "+r.URL.Path+" was not found")
+		return
+	}
+
+	path := strings.TrimPrefix(r.URL.Path, mgmtPrefix)
+	parts := strings.Split(strings.Trim(path, "/"), "/")
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch {
+	case r.Method == "POST" && path == "/portalSettings/mediaContent/listSecrets":
+		writeJSON(w, http.StatusOK, map[string]string{
+			"containerSasUrl": s.ContainerURL() + "?sv=2019-12-12&sr=c&sp=racwdl&sig=fake",
+		})
+
+	case r.Method == "GET" && path == "/contentTypes":
+		var types []map[string]string
+		for _, ct := range s.contentTypes {
+			types = append(types, map[string]string{"id": "/contentTypes/" + ct, "name": ct})
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{"value": types})
+
+	case len(parts) == 3 && parts[0] == "contentTypes" && parts[2] == "contentItems" && r.Method == "GET":
+		if !s.hasContentType(parts[1]) {
+			armError(w, http.StatusNotFound, "ResourceNotFound", "Content type "+parts[1]+" not found")
+			return
+		}
+
+		items := []map[string]interface{}{}
+		for _, id := range s.sortedItemIDs() {
+			if strings.HasPrefix(id, "/contentTypes/"+parts[1]+"/") {
+				items = append(items, s.items[id])
+			}
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{"value": items, "count": len(items)})
+
+	case len(parts) == 4 && parts[0] == "contentTypes" && parts[2] == "contentItems":
+		s.serveContentItem(w, r, path)
+
+	default:
+		armError(w, http.StatusNotFound, "ResourceNotFound", "The resource "+path+" was not found")
+	}
+}
+
+// Get, put or delete a content item
+func (s *Server) serveContentItem(w http.ResponseWriter, r *http.Request, id string) {
+	switch r.Method {
+	case "GET":
+		item, ok := s.items[id]
+		if !ok {
+			armError(w, http.StatusNotFound, "ResourceNotFound", "Content item "+id+" not found")
+			return
+		}
+		writeJSON(w, http.StatusOK, item)
+
+	case "PUT":
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			armError(w, http.StatusBadRequest, "InvalidRequestContent", err.Error())
+			return
+		}
+
+		var item map[string]interface{}
+		if err := json.Unmarshal(body, &item); err != nil {
+			armError(w, http.StatusBadRequest, "InvalidRequestContent", err.Error())
+			return
+		}
+
+		_, exists := s.items[id]
+		if err := s.putItem(id, item); err != nil {
+			armError(w, http.StatusNotFound, "ResourceNotFound", err.Error())
+			return
+		}
+
+		status := http.StatusCreated
+		if exists {
+			status = http.StatusOK
+		}
+		writeJSON(w, status, s.items[id])
+
+	case "DELETE":
+		if _, ok := s.items[id]; !ok {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		delete(s.items, id)
+		w.WriteHeader(http.StatusOK)
+
+	default:
+		armError(w, http.StatusMethodNotAllowed, "MethodNotAllowed", r.Method+" not allowed")
+	}
+}
+
+// Store a content item as the management API returns it
+func (s *Server) putItem(id string, item map[string]interface{}) error {
+	parts := strings.Split(strings.Trim(id, "/"), "/")
+	if len(parts) != 4 || parts[0] != "contentTypes" || parts[2] != "contentItems" {
+		return fmt.Errorf("bad content item ID %s", id)
+	}
+
+	if !s.hasContentType(parts[1]) {
+		return fmt.Errorf("content type %s not found", parts[1])
+	}
+
+	item = copyItem(item)
+	item["id"] = id
+	item["type"] = "Microsoft.ApiManagement/service/contentTypes/contentItems"
+	item["name"] = parts[3]
+
+	s.items[id] = item
+
+	return nil
+}
+
+func (s *Server) hasContentType(ct string) bool {
+	for _, t := range s.contentTypes {
+		if t == ct {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (s *Server) sortedItemIDs() []string {
+	ids := make([]string, 0, len(s.items))
+	for id := range s.items {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	return ids
+}
+
+// Developer portal: the site itself, its status and publishing
+func (s *Server) servePortal(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	deployed := s.deployed
+	s.mu.Unlock()
+
+	switch {
+	case r.Method == "GET" && r.URL.Path == "/":
+		if !deployed {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html")
+		fmt.Fprint(w, "<html><body>Developer portal</body></html>")
+
+	case r.Method == "GET" && r.URL.Path == "/internal-status-0123456789abcdef":
+		if !deployed {
+			http.NotFound(w, r)
+			return
+		}
+
+		s.mu.Lock()
+		status := map[string]interface{}{
+			"Status":        1,
+			"PortalVersion": s.portalVersion.Format(portalVersionFormat),
+			"CodeVersion":   s.codeVersion,
+			"Version":       s.version,
+		}
+		s.mu.Unlock()
+
+		writeJSON(w, http.StatusOK, status)
+
+	case r.Method == "POST" && r.URL.Path == "/publish":
+		s.withToken(s.publish)(w, r)
+
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// Publishing deploys the portal and changes its version, which has a per
+// minute resolution
+func (s *Server) publish(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now().UTC().Truncate(time.Minute)
+	if !now.After(s.portalVersion) {
+		now = s.portalVersion.Add(time.Minute)
+	}
+
+	s.portalVersion = now
+	s.deployed = true
+	s.publishes++
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v) //nolint:errcheck
+}
+
+// Resource Manager style error response
+func armError(w http.ResponseWriter, status int, code, message string) {
+	writeJSON(w, status, map[string]interface{}{
+		"error": map[string]string{"code": code, "message": message},
+	})
+}
+
+// A deep enough copy of a content item that the caller cannot change the
+// stored item's top level fields
+func copyItem(item map[string]interface{}) map[string]interface{} {
+	out := make(map[string]interface{}, len(item))
+	for k, v := range item {
+		out[k] = v
+	}
+
+	return out
+}
@@ -0,0 +1,128 @@
+package apimfake
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/Azure/azure-storage-blob-go/azblob"
+)
+
+func TestContainer(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+
+	for i := 0; i < 5; i++ {
+		s.PutBlob(fmt.Sprintf("media/%d.png", i), []byte{byte(i)})
+	}
+
+	u, _ := url.Parse(s.ContainerURL() + "?sig=test")
+	c := azblob.NewContainerURL(*u, azblob.NewPipeline(azblob.NewAnonymousCredential(), azblob.PipelineOptions{}))
+	ctx := context.Background()
+
+	// Blobs are listed in pages
+	var names []string
+	pages := 0
+	for marker := (azblob.Marker{}); marker.NotDone(); pages++ {
+		list, err := c.ListBlobsFlatSegment(ctx, marker, azblob.ListBlobsSegmentOptions{MaxResults: 2})
+		if err != nil {
+			t.Fatal(err)
+		}
+		marker = list.NextMarker
+
+		for _, b := range list.Segment.BlobItems {
+			if b.Properties.ContentLength == nil || *b.Properties.ContentLength != 1 {
+				t.Errorf("Blob %s listed with bad length", b.Name)
+			}
+			names = append(names, b.Name)
+		}
+	}
+
+	if pages != 3 || len(names) != 5 || names[0] != "media/0.png" || names[4] != "media/4.png" {
+		t.Errorf("Listed %v in %d pages", names, pages)
+	}
+
+	// Upload, download and delete
+	blob := c.NewBlockBlobURL("docs/new file.txt")
+	if _, err := blob.Upload(ctx, strings.NewReader("hello"), azblob.BlobHTTPHeaders{ContentType: "text/plain"},
+		azblob.Metadata{}, azblob.BlobAccessConditions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	dl, err := blob.Download(ctx, 0, 0, azblob.BlobAccessConditions{}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body := dl.Body(azblob.RetryReaderOptions{})
+	data, err := ioutil.ReadAll(body)
+	body.Close()
+	if err != nil || string(data) != "hello" || dl.ContentType() != "text/plain" {
+		t.Errorf("Downloaded %q (%s), %v", data, dl.ContentType(), err)
+	}
+
+	if _, err := blob.Delete(ctx, azblob.DeleteSnapshotsOptionNone, azblob.BlobAccessConditions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = blob.Delete(ctx, azblob.DeleteSnapshotsOptionNone, azblob.BlobAccessConditions{})
+	if serr, ok := err.(azblob.StorageError); !ok || serr.ServiceCode() != azblob.ServiceCodeBlobNotFound {
+		t.Errorf("Deleting a missing blob: got %v", err)
+	}
+
+	// Requests need a SAS
+	resp, err := http.Get(s.ContainerURL() + "?restype=container&comp=list")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusForbidden {
+		t.Errorf("Listing without a SAS: got %s", resp.Status)
+	}
+}
+
+func TestManagementNeedsToken(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+
+	reqURL := s.ManagementURL() + mgmtPrefix + "/contentTypes?api-version=2021-08-01"
+
+	get := func(token string) int {
+		req, _ := http.NewRequest("GET", reqURL, nil)
+		req.Header.Set("Authorization", "SharedAccessSignature "+token)
+
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+
+		return resp.StatusCode
+	}
+
+	if status := get("made-up"); status != http.StatusUnauthorized {
+		t.Errorf("Request with a made up token: got %d", status)
+	}
+
+	resp, err := http.Post(s.ResourceManagerURL()+InstanceID+"/users/1/token?api-version=2021-08-01", "application/json",
+		strings.NewReader(`{"properties": {"keyType": "primary", "expiry": "2030-01-01T00:00:00Z"}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	var token struct {
+		Value string `json:"value"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		t.Fatal(err)
+	}
+
+	if status := get(token.Value); status != http.StatusOK {
+		t.Errorf("Request with issued token %s: got %d", token.Value, status)
+	}
+}
@@ -0,0 +1,252 @@
+package apimfake
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+ *  The portal media container, served the way the Azurite storage emulator
+ *  does: path style URLs of the form /<account>/<container>/<blob>.  Only the
+ *  operations used on a container SAS URL are implemented: list, put, get and
+ *  delete of block blobs.
+ */
+
+// Storage account and container of the portal media, named as Azurite's
+// default account
+const (
+	AccountName   = "devstoreaccount1"
+	ContainerName = "content"
+)
+
+// Storage service version reported in responses
+const storageVersion = "2019-12-12"
+
+// Most blobs returned by a list request
+const maxListResults = 5000
+
+type blob struct {
+	data         []byte
+	contentType  string
+	etag         string
+	lastModified time.Time
+}
+
+// PutBlob adds or replaces a blob in the media container
+func (s *Server) PutBlob(name string, data []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.putBlob(name, data, "application/octet-stream")
+}
+
+// Blobs returns a copy of the contents of the media container by name
+func (s *Server) Blobs() map[string][]byte {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	out := make(map[string][]byte, len(s.blobs))
+	for name, b := range s.blobs {
+		out[name] = append([]byte(nil), b.data...)
+	}
+
+	return out
+}
+
+func (s *Server) putBlob(name string, data []byte, contentType string) *blob {
+	s.etag++
+
+	b := &blob{
+		data:         data,
+		contentType:  contentType,
+		etag:         fmt.Sprintf(`"0x8D8%013X"`, s.etag),
+		lastModified: time.Now().UTC().Truncate(time.Second),
+	}
+	s.blobs[name] = b
+
+	return b
+}
+
+func (s *Server) serveBlob(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("x-ms-version", storageVersion)
+
+	// Every request carries the SAS returned by listSecrets
+	if r.URL.Query().Get("sig") == "" {
+		storageError(w, http.StatusForbidden, "AuthenticationFailed", "Server failed to authenticate the request.")
+		return
+	}
+
+	prefix := "/" + AccountName + "/" + ContainerName
+	if r.URL.Path != prefix && !strings.HasPrefix(r.URL.Path, prefix+"/") {
+		storageError(w, http.StatusNotFound, "ContainerNotFound", "The specified container does not exist.")
+		return
+	}
+
+	name := strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, prefix), "/")
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if name == "" {
+		q := r.URL.Query()
+		if r.Method == "GET" && q.Get("restype") == "container" && q.Get("comp") == "list" {
+			s.listBlobs(w, r)
+			return
+		}
+
+		storageError(w, http.StatusBadRequest, "UnsupportedQueryParameter", "Only listing blobs is supported.")
+		return
+	}
+
+	switch r.Method {
+	case "PUT":
+		if t := r.Header.Get("x-ms-blob-type"); t != "BlockBlob" {
+			storageError(w, http.StatusBadRequest, "InvalidHeaderValue", "Unsupported blob type "+t)
+			return
+		}
+
+		data, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			storageError(w, http.StatusBadRequest, "InvalidInput", err.Error())
+			return
+		}
+
+		contentType := r.Header.Get("x-ms-blob-content-type")
+		if contentType == "" {
+			contentType = "application/octet-stream"
+		}
+
+		b := s.putBlob(name, data, contentType)
+		blobHeaders(w, b)
+		w.Header().Set("x-ms-request-server-encrypted", "true")
+		w.WriteHeader(http.StatusCreated)
+
+	case "GET", "HEAD":
+		b, ok := s.blobs[name]
+		if !ok {
+			storageError(w, http.StatusNotFound, "BlobNotFound", "The specified blob does not exist.")
+			return
+		}
+
+		blobHeaders(w, b)
+		w.Header().Set("Content-Type", b.contentType)
+		w.Header().Set("Content-Length", strconv.Itoa(len(b.data)))
+		w.Header().Set("x-ms-blob-type", "BlockBlob")
+		w.WriteHeader(http.StatusOK)
+
+		if r.Method == "GET" {
+			w.Write(b.data) //nolint:errcheck
+		}
+
+	case "DELETE":
+		if _, ok := s.blobs[name]; !ok {
+			storageError(w, http.StatusNotFound, "BlobNotFound", "The specified blob does not exist.")
+			return
+		}
+
+		delete(s.blobs, name)
+		w.WriteHeader(http.StatusAccepted)
+
+	default:
+		storageError(w, http.StatusMethodNotAllowed, "UnsupportedHttpVerb", "The resource doesn't support the specified HTTP verb.")
+	}
+}
+
+// The XML of a flat blob listing
+type enumerationResults struct {
+	XMLName         xml.Name   `xml:"EnumerationResults"`
+	ServiceEndpoint string     `xml:"ServiceEndpoint,attr"`
+	ContainerName   string     `xml:"ContainerName,attr"`
+	Prefix          string     `xml:"Prefix"`
+	Marker          string     `xml:"Marker"`
+	MaxResults      int        `xml:"MaxResults"`
+	Blobs           []blobItem `xml:"Blobs>Blob"`
+	NextMarker      string     `xml:"NextMarker"`
+}
+
+type blobItem struct {
+	Name       string `xml:"Name"`
+	Properties struct {
+		LastModified  string `xml:"Last-Modified"`
+		Etag          string `xml:"Etag"`
+		ContentLength int    `xml:"Content-Length"`
+		ContentType   string `xml:"Content-Type"`
+		BlobType      string `xml:"BlobType"`
+	} `xml:"Properties"`
+}
+
+// List the blobs in name order, a page at a time.  The marker is the name
+// of the first blob of the next page.
+func (s *Server) listBlobs(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+
+	max := maxListResults
+	if m := q.Get("maxresults"); m != "" {
+		n, err := strconv.Atoi(m)
+		if err != nil || n < 1 {
+			storageError(w, http.StatusBadRequest, "OutOfRangeQueryParameterValue", "Bad maxresults "+m)
+			return
+		}
+		if n < max {
+			max = n
+		}
+	}
+
+	res := enumerationResults{
+		ServiceEndpoint: "http://" + r.Host + "/" + AccountName,
+		ContainerName:   ContainerName,
+		Prefix:          q.Get("prefix"),
+		Marker:          q.Get("marker"),
+		MaxResults:      max,
+	}
+
+	names := make([]string, 0, len(s.blobs))
+	for name := range s.blobs {
+		if strings.HasPrefix(name, res.Prefix) && name >= res.Marker {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	if len(names) > max {
+		res.NextMarker = names[max]
+		names = names[:max]
+	}
+
+	for _, name := range names {
+		b := s.blobs[name]
+
+		item := blobItem{Name: name}
+		item.Properties.LastModified = b.lastModified.Format(http.TimeFormat)
+		item.Properties.Etag = b.etag
+		item.Properties.ContentLength = len(b.data)
+		item.Properties.ContentType = b.contentType
+		item.Properties.BlobType = "BlockBlob"
+
+		res.Blobs = append(res.Blobs, item)
+	}
+
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprint(w, xml.Header)
+	xml.NewEncoder(w).Encode(res) //nolint:errcheck
+}
+
+func blobHeaders(w http.ResponseWriter, b *blob) {
+	w.Header().Set("ETag", b.etag)
+	w.Header().Set("Last-Modified", b.lastModified.Format(http.TimeFormat))
+}
+
+// Storage style error response
+func storageError(w http.ResponseWriter, status int, code, message string) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.Header().Set("x-ms-error-code", code)
+	w.WriteHeader(status)
+	fmt.Fprintf(w, "%s<Error><Code>%s</Code><Message>%s</Message></Error>", xml.Header, code, message)
+}
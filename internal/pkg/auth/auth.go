@@ -2,41 +2,159 @@ package auth
 
 import (
 	"fmt"
+	"os"
 
+	"github.com/Azure/go-autorest/autorest"
+	"github.com/Azure/go-autorest/autorest/adal"
+	"github.com/Azure/go-autorest/autorest/azure"
 	"github.com/hashicorp/go-azure-helpers/authentication"
 	"github.com/spf13/viper"
 
 	"github.com/jake-scott/apim-tools/internal/pkg/logging"
 )
 
+// Authentication method names
+const (
+	MethodClientCertificate = "client certificate"
+	MethodClientSecret      = "client secret"
+	MethodFederatedToken    = "federated token"
+	MethodManagedIdentity   = "managed identity"
+	MethodAzureCLI          = "Azure CLI"
+)
+
 var authCfg *authentication.Config
+var authEnv *azure.Environment
+var authMethod string
+
+// Source of federated tokens, when using workload identity federation
+var authAssertion assertionFunc
 
-// Configure application wide Azure authentication
+// Configure application wide Azure authentication.  The first applicable
+// method is used, in this order:
+//
+//  1. client certificate, when auth.cert-file is set
+//  2. client secret, when auth.client-secret is set
+//  3. federated token, when auth.federated-token-file is set or auth.use-oidc
+//     is set inside GitHub Actions
+//  4. managed identity, when auth.use-msi is set
+//  5. the Azure CLI
 func Configure(v *viper.Viper) error {
+	var err error
+
+	authAssertion = federatedAssertion(v)
+	authMethod = selectMethod(v, authAssertion != nil)
+
+	if authMethod == MethodFederatedToken {
+		authCfg, err = federatedConfig(v)
+	} else {
+		authAssertion = nil
+		authCfg, err = buildConfig(v, authMethod)
+	}
+
+	if err != nil {
+		return fmt.Errorf("error building AzureRM Client using %s authentication: %s", authMethod, err)
+	}
+
+	logging.Logger().Debugf("Auth method: %s, tenant: %s, subscription: %s, client: %s",
+		authMethod, authCfg.TenantID, authCfg.SubscriptionID, authCfg.ClientID)
+
+	authEnv, err = resolveEnvironment(v.GetString("auth.metadata-endpoint"), environmentName(v.GetString("auth.environment"), authCfg))
+	if err != nil {
+		return err
+	}
+
+	logging.Logger().Debugf("Azure environment %s: login %s, management %s",
+		authEnv.Name, authEnv.ActiveDirectoryEndpoint, authEnv.ResourceManagerEndpoint)
+
+	return nil
+}
+
+// Choose the authentication method, in order of precedence
+func selectMethod(v *viper.Viper, haveFederatedToken bool) string {
+	switch {
+	case v.GetString("auth.cert-file") != "":
+		return MethodClientCertificate
+	case v.GetString("auth.client-secret") != "":
+		return MethodClientSecret
+	case haveFederatedToken:
+		return MethodFederatedToken
+	case v.GetBool("auth.use-msi") || v.GetBool("use-msi"):
+		return MethodManagedIdentity
+	}
+
+	return MethodAzureCLI
+}
+
+// Build the configuration for the methods handled by the Hashicorp helpers.
+// Only the credentials for method are passed on so that the helpers pick
+// the same method.
+func buildConfig(v *viper.Viper, method string) (*authentication.Config, error) {
 	builder := &authentication.Builder{
-		SubscriptionID:     v.GetString("auth.subscription"),
-		ClientID:           v.GetString("auth.client-id"),
-		ClientSecret:       v.GetString("auth.client-secret"),
-		TenantID:           v.GetString("auth.tenant"),
-		Environment:        v.GetString("auth.environment"),
-		MsiEndpoint:        v.GetString("auth.msi-endpoint"),
-		ClientCertPassword: v.GetString("auth.cert-password"),
-		ClientCertPath:     v.GetString("auth.cert-file"),
+		SubscriptionID: v.GetString("auth.subscription"),
+		ClientID:       v.GetString("auth.client-id"),
+		TenantID:       v.GetString("auth.tenant"),
+		Environment:    v.GetString("auth.environment"),
+		MetadataURL:    v.GetString("auth.metadata-endpoint"),
+		MsiEndpoint:    v.GetString("auth.msi-endpoint"),
 
 		// Feature Toggles
 		SupportsClientCertAuth:         true,
 		SupportsClientSecretAuth:       true,
-		SupportsManagedServiceIdentity: v.GetBool("use-msi"),
+		SupportsManagedServiceIdentity: method == MethodManagedIdentity,
 		SupportsAzureCliToken:          true,
 		SupportsAuxiliaryTenants:       false,
 	}
 
-	var err error
-	authCfg, err = builder.Build()
-	logging.Logger().Debugf("Auth config: %+v", authCfg)
+	switch method {
+	case MethodClientCertificate:
+		builder.ClientCertPath = v.GetString("auth.cert-file")
+		builder.ClientCertPassword = v.GetString("auth.cert-password")
+	case MethodClientSecret:
+		builder.ClientSecret = v.GetString("auth.client-secret")
+	case MethodManagedIdentity:
+		// A user-assigned managed identity is selected by its client ID
+		if id := v.GetString("auth.msi-client-id"); id != "" {
+			builder.ClientID = id
+		}
+	}
 
-	if err != nil {
-		return fmt.Errorf("error building AzureRM Client: %s", err)
+	return builder.Build()
+}
+
+// Build the configuration for federated token authentication.  The client
+// and tenant IDs may come from the environment variables set by the Azure
+// workload identity webhook.
+func federatedConfig(v *viper.Viper) (*authentication.Config, error) {
+	cfg := &authentication.Config{
+		SubscriptionID: v.GetString("auth.subscription"),
+		ClientID:       firstNonEmpty(v.GetString("auth.client-id"), os.Getenv("AZURE_CLIENT_ID")),
+		TenantID:       firstNonEmpty(v.GetString("auth.tenant"), os.Getenv("AZURE_TENANT_ID")),
+		Environment:    v.GetString("auth.environment"),
+		MetadataURL:    v.GetString("auth.metadata-endpoint"),
+	}
+
+	switch {
+	case cfg.ClientID == "":
+		return nil, fmt.Errorf("federated token authentication requires a client ID")
+	case cfg.TenantID == "":
+		return nil, fmt.Errorf("federated token authentication requires a tenant")
+	case cfg.SubscriptionID == "":
+		return nil, fmt.Errorf("federated token authentication requires a subscription ID")
+	}
+
+	return cfg, nil
+}
+
+// Return the federated token source configured, if any
+func federatedAssertion(v *viper.Viper) assertionFunc {
+	if path := firstNonEmpty(v.GetString("auth.federated-token-file"), os.Getenv("AZURE_FEDERATED_TOKEN_FILE")); path != "" {
+		return fileAssertion(path)
+	}
+
+	requestURL := os.Getenv("ACTIONS_ID_TOKEN_REQUEST_URL")
+	requestToken := os.Getenv("ACTIONS_ID_TOKEN_REQUEST_TOKEN")
+	if v.GetBool("auth.use-oidc") && requestURL != "" && requestToken != "" {
+		return githubActionsAssertion(requestURL, requestToken)
 	}
 
 	return nil
@@ -46,3 +164,128 @@ func Configure(v *viper.Viper) error {
 func Get() *authentication.Config {
 	return authCfg
 }
+
+// Method returns the name of the authentication method in use
+func Method() string {
+	return authMethod
+}
+
+// Environment returns the Azure cloud that the application is working in
+func Environment() *azure.Environment {
+	if authEnv == nil {
+		env := azure.PublicCloud
+		return &env
+	}
+
+	return authEnv
+}
+
+// Authorizer returns an authorizer that decorates requests to resource with
+// an access token from the configured authentication method
+func Authorizer(resource string) (autorest.Authorizer, error) {
+	if authCfg == nil {
+		return nil, fmt.Errorf("Azure authentication is not configured")
+	}
+
+	return authorizer(authCfg, Environment(), authAssertion, resource)
+}
+
+// SecretsAuthorizer returns an authorizer for resource that uses only the
+// methods needing no secrets from the configuration: a federated token, a
+// managed identity or the Azure CLI.  It is used to fetch those secrets
+// before Configure runs.
+func SecretsAuthorizer(v *viper.Viper, resource string) (autorest.Authorizer, error) {
+	assertion := federatedAssertion(v)
+
+	var cfg *authentication.Config
+	var err error
+
+	switch {
+	case assertion != nil:
+		cfg, err = federatedConfig(v)
+	case v.GetBool("auth.use-msi") || v.GetBool("use-msi"):
+		cfg, err = buildConfig(v, MethodManagedIdentity)
+	default:
+		cfg, err = buildConfig(v, MethodAzureCLI)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	env, err := EnvironmentFromConfig(v)
+	if err != nil {
+		return nil, err
+	}
+
+	return authorizer(cfg, env, assertion, resource)
+}
+
+func authorizer(cfg *authentication.Config, env *azure.Environment, assertion assertionFunc, resource string) (autorest.Authorizer, error) {
+	sender := autorest.CreateSender()
+
+	oauthConfig, err := cfg.BuildOAuthConfig(env.ActiveDirectoryEndpoint)
+	if err != nil {
+		return nil, err
+	}
+
+	if assertion == nil {
+		return cfg.GetAuthorizationToken(sender, oauthConfig, resource)
+	}
+
+	spt, err := adal.NewServicePrincipalTokenWithSecret(*oauthConfig.OAuth, cfg.ClientID, resource, federatedTokenSecret{assertion})
+	if err != nil {
+		return nil, err
+	}
+	spt.SetSender(sender)
+
+	return autorest.NewBearerAuthorizer(spt), nil
+}
+
+// EnvironmentFromConfig returns the Azure cloud named in the configuration,
+// for use before Configure runs
+func EnvironmentFromConfig(v *viper.Viper) (*azure.Environment, error) {
+	return resolveEnvironment(v.GetString("auth.metadata-endpoint"), environmentName(v.GetString("auth.environment"), nil))
+}
+
+// The configured environment name, falling back to the environment of the
+// Azure CLI profile when authenticating with the CLI, then the public cloud
+func environmentName(configured string, cfg *authentication.Config) string {
+	switch {
+	case configured != "":
+		return configured
+	case cfg != nil && cfg.Environment != "":
+		return cfg.Environment
+	}
+
+	return "public"
+}
+
+// Look up the named cloud environment (public, usgovernment, china or
+// german), or load the environment from a custom metadata endpoint
+func resolveEnvironment(metadataEndpoint, name string) (*azure.Environment, error) {
+	if metadataEndpoint != "" {
+		env, err := authentication.LoadEnvironmentFromUrl(metadataEndpoint)
+		if err != nil {
+			return nil, fmt.Errorf("loading Azure environment: %s", err)
+		}
+
+		return env, nil
+	}
+
+	env, err := authentication.DetermineEnvironment(name)
+	if err != nil {
+		return nil, fmt.Errorf("unknown Azure environment %q, expected public, usgovernment, china or german", name)
+	}
+
+	return env, nil
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+
+	return ""
+}
@@ -0,0 +1,58 @@
+package auth
+
+import (
+	"testing"
+
+	"github.com/hashicorp/go-azure-helpers/authentication"
+)
+
+func TestResolveEnvironment(t *testing.T) {
+	tests := []struct {
+		name       string
+		management string
+		storage    string
+		expectErr  bool
+	}{
+		{"public", "https://management.azure.com/", "core.windows.net", false},
+		{"usgovernment", "https://management.usgovcloudapi.net/", "core.usgovcloudapi.net", false},
+		{"china", "https://management.chinacloudapi.cn/", "core.chinacloudapi.cn", false},
+		{"AzureUSGovernmentCloud", "https://management.usgovcloudapi.net/", "core.usgovcloudapi.net", false},
+		{"moon", "", "", true},
+	}
+
+	for _, tt := range tests {
+		env, err := resolveEnvironment("", tt.name)
+		if tt.expectErr {
+			if err == nil {
+				t.Errorf("Expected error resolving %q", tt.name)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("Resolving %q: %s", tt.name, err)
+			continue
+		}
+
+		if env.ResourceManagerEndpoint != tt.management {
+			t.Errorf("Resolving %q: got management endpoint %s, wanted %s", tt.name, env.ResourceManagerEndpoint, tt.management)
+		}
+		if env.StorageEndpointSuffix != tt.storage {
+			t.Errorf("Resolving %q: got storage suffix %s, wanted %s", tt.name, env.StorageEndpointSuffix, tt.storage)
+		}
+	}
+}
+
+func TestEnvironmentName(t *testing.T) {
+	cli := &authentication.Config{Environment: "china"}
+
+	if got := environmentName("usgovernment", cli); got != "usgovernment" {
+		t.Errorf("Configured environment: got %s", got)
+	}
+	if got := environmentName("", cli); got != "china" {
+		t.Errorf("CLI environment: got %s", got)
+	}
+	if got := environmentName("", nil); got != "public" {
+		t.Errorf("Default environment: got %s", got)
+	}
+}
@@ -0,0 +1,109 @@
+package auth
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/Azure/go-autorest/autorest/adal"
+)
+
+/*
+ *  Workload identity federation: a service principal authenticates with an
+ *  OIDC token issued by a trusted identity provider such as Kubernetes or
+ *  GitHub Actions, presented to Azure AD as a client assertion
+ */
+
+// Audience that Azure AD expects federated tokens to be issued for
+const federatedTokenAudience = "api://AzureADTokenExchange"
+
+// assertionFunc returns a current OIDC token to use as a client assertion
+type assertionFunc func() (string, error)
+
+// federatedTokenSecret is an adal.ServicePrincipalSecret that presents a
+// federated token in place of a client secret.  A fresh assertion is
+// obtained each time the access token is refreshed, as the federated tokens
+// are short lived.
+type federatedTokenSecret struct {
+	assertion assertionFunc
+}
+
+// SetAuthenticationValues implements adal.ServicePrincipalSecret
+func (s federatedTokenSecret) SetAuthenticationValues(spt *adal.ServicePrincipalToken, v *url.Values) error {
+	token, err := s.assertion()
+	if err != nil {
+		return err
+	}
+
+	v.Set("client_assertion", token)
+	v.Set("client_assertion_type", "urn:ietf:params:oauth:client-assertion-type:jwt-bearer")
+
+	return nil
+}
+
+// Read the federated token from a file, as projected into Kubernetes pods
+// by the Azure workload identity webhook.  The file is re-read every time
+// as the token is rotated.
+func fileAssertion(path string) assertionFunc {
+	return func() (string, error) {
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			return "", fmt.Errorf("reading federated token: %s", err)
+		}
+
+		token := strings.TrimSpace(string(b))
+		if token == "" {
+			return "", fmt.Errorf("federated token file %s is empty", path)
+		}
+
+		return token, nil
+	}
+}
+
+// Request a federated token from the GitHub Actions OIDC provider.  The
+// workflow must have the id-token: write permission.
+func githubActionsAssertion(requestURL, requestToken string) assertionFunc {
+	return func() (string, error) {
+		u, err := url.Parse(requestURL)
+		if err != nil {
+			return "", fmt.Errorf("bad GitHub Actions token request URL: %s", err)
+		}
+
+		q := u.Query()
+		q.Set("audience", federatedTokenAudience)
+		u.RawQuery = q.Encode()
+
+		req, err := http.NewRequest("GET", u.String(), nil)
+		if err != nil {
+			return "", err
+		}
+		req.Header.Set("Authorization", "Bearer "+requestToken)
+
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return "", fmt.Errorf("requesting GitHub Actions token: %s", err)
+		}
+		defer resp.Body.Close()
+
+		// Only accept HTTP 2xx codes
+		if resp.StatusCode >= 300 {
+			return "", fmt.Errorf("requesting GitHub Actions token: status %s received", resp.Status)
+		}
+
+		tokenResp := struct {
+			Value string `json:"value"`
+		}{}
+		if err := json.NewDecoder(resp.Body).Decode(&tokenResp); err != nil {
+			return "", fmt.Errorf("decoding GitHub Actions token: %s", err)
+		}
+
+		if tokenResp.Value == "" {
+			return "", fmt.Errorf("GitHub Actions returned an empty token")
+		}
+
+		return tokenResp.Value, nil
+	}
+}
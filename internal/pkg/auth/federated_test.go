@@ -0,0 +1,112 @@
+package auth
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+func TestFileAssertion(t *testing.T) {
+	dir, err := ioutil.TempDir("", "apim-tools-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "token")
+	assertion := fileAssertion(path)
+
+	if _, err := assertion(); err == nil {
+		t.Errorf("Expected error reading a missing token file")
+	}
+
+	// The file is re-read each time, picking up a rotated token
+	for _, token := range []string{"first.jwt", "second.jwt"} {
+		if err := ioutil.WriteFile(path, []byte(token+"\n"), 0600); err != nil {
+			t.Fatal(err)
+		}
+
+		got, err := assertion()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != token {
+			t.Errorf("Got token %q, wanted %q", got, token)
+		}
+	}
+}
+
+func TestGithubActionsAssertion(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer request-token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if r.URL.Query().Get("audience") != federatedTokenAudience {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		_, _ = w.Write([]byte(`{"value": "github.jwt"}`))
+	}))
+	defer srv.Close()
+
+	got, err := githubActionsAssertion(srv.URL+"/token?api-version=2.0", "request-token")()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != "github.jwt" {
+		t.Errorf("Got token %q, wanted github.jwt", got)
+	}
+
+	if _, err := githubActionsAssertion(srv.URL, "wrong-token")(); err == nil {
+		t.Errorf("Expected error with a bad request token")
+	}
+}
+
+func TestFederatedTokenSecret(t *testing.T) {
+	s := federatedTokenSecret{func() (string, error) { return "token.jwt", nil }}
+
+	v := url.Values{}
+	if err := s.SetAuthenticationValues(nil, &v); err != nil {
+		t.Fatal(err)
+	}
+
+	if v.Get("client_assertion") != "token.jwt" {
+		t.Errorf("Got client_assertion %q", v.Get("client_assertion"))
+	}
+	if v.Get("client_assertion_type") != "urn:ietf:params:oauth:client-assertion-type:jwt-bearer" {
+		t.Errorf("Got client_assertion_type %q", v.Get("client_assertion_type"))
+	}
+}
+
+func TestSelectMethod(t *testing.T) {
+	tests := []struct {
+		config    map[string]interface{}
+		federated bool
+		want      string
+	}{
+		{map[string]interface{}{}, false, MethodAzureCLI},
+		{map[string]interface{}{"auth.use-msi": true}, false, MethodManagedIdentity},
+		{map[string]interface{}{"use-msi": true}, false, MethodManagedIdentity},
+		{map[string]interface{}{"auth.use-msi": true}, true, MethodFederatedToken},
+		{map[string]interface{}{"auth.client-secret": "s"}, true, MethodClientSecret},
+		{map[string]interface{}{"auth.client-secret": "s", "auth.cert-file": "c.pfx"}, true, MethodClientCertificate},
+	}
+
+	for _, tt := range tests {
+		v := viper.New()
+		for k, val := range tt.config {
+			v.Set(k, val)
+		}
+
+		if got := selectMethod(v, tt.federated); got != tt.want {
+			t.Errorf("Config %v, federated %v: got %s, wanted %s", tt.config, tt.federated, got, tt.want)
+		}
+	}
+}
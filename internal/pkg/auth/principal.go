@@ -0,0 +1,104 @@
+package auth
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Azure/go-autorest/autorest"
+	"github.com/Azure/go-autorest/autorest/adal"
+)
+
+// Principal describes the identity that access tokens are issued to
+type Principal struct {
+	Method         string    `json:"method"`
+	Environment    string    `json:"environment"`
+	TenantID       string    `json:"tenant_id"`
+	SubscriptionID string    `json:"subscription_id"`
+	ClientID       string    `json:"client_id,omitempty"`
+	ObjectID       string    `json:"object_id"`
+	Name           string    `json:"name,omitempty"`
+	TokenExpiry    time.Time `json:"token_expiry"`
+}
+
+// The access token claims that identify the principal
+type tokenClaims struct {
+	ObjectID   string `json:"oid"`
+	TenantID   string `json:"tid"`
+	AppID      string `json:"appid"`
+	UPN        string `json:"upn"`
+	UniqueName string `json:"unique_name"`
+	Expiry     int64  `json:"exp"`
+}
+
+// WhoAmI obtains a Resource Manager access token and returns the principal
+// it was issued to
+func WhoAmI() (*Principal, error) {
+	token, err := accessToken(Environment().TokenAudience)
+	if err != nil {
+		return nil, fmt.Errorf("getting access token with %s: %s", authMethod, err)
+	}
+
+	claims, err := parseTokenClaims(token)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &Principal{
+		Method:         authMethod,
+		Environment:    Environment().Name,
+		TenantID:       claims.TenantID,
+		SubscriptionID: authCfg.SubscriptionID,
+		ClientID:       claims.AppID,
+		ObjectID:       claims.ObjectID,
+		Name:           firstNonEmpty(claims.UPN, claims.UniqueName),
+		TokenExpiry:    time.Unix(claims.Expiry, 0).UTC(),
+	}
+
+	return p, nil
+}
+
+// Get a fresh access token for resource
+func accessToken(resource string) (string, error) {
+	authz, err := Authorizer(resource)
+	if err != nil {
+		return "", err
+	}
+
+	ba, ok := authz.(*autorest.BearerAuthorizer)
+	if !ok {
+		return "", fmt.Errorf("unexpected authorizer type %T", authz)
+	}
+
+	tp := ba.TokenProvider()
+	if r, ok := tp.(adal.Refresher); ok {
+		if err := r.EnsureFresh(); err != nil {
+			return "", err
+		}
+	}
+
+	return tp.OAuthToken(), nil
+}
+
+// Decode the claims from an access token.  The signature is not verified,
+// the claims are only used for display.
+func parseTokenClaims(token string) (*tokenClaims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("access token is not a JWT")
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(parts[1], "="))
+	if err != nil {
+		return nil, fmt.Errorf("decoding access token: %s", err)
+	}
+
+	claims := &tokenClaims{}
+	if err := json.Unmarshal(payload, claims); err != nil {
+		return nil, fmt.Errorf("decoding access token: %s", err)
+	}
+
+	return claims, nil
+}
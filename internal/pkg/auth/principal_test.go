@@ -0,0 +1,35 @@
+package auth
+
+import (
+	"encoding/base64"
+	"testing"
+)
+
+func TestParseTokenClaims(t *testing.T) {
+	payload := base64.RawURLEncoding.EncodeToString([]byte(
+		`{"oid":"0b5f2b3c-0000-0000-0000-000000000001","tid":"72f988bf-0000-0000-0000-000000000002","appid":"e1f509c4","upn":"jo@example.com","exp":1603200000}`))
+
+	claims, err := parseTokenClaims("eyJhbGciOiJSUzI1NiJ9." + payload + ".c2ln")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if claims.ObjectID != "0b5f2b3c-0000-0000-0000-000000000001" {
+		t.Errorf("Got object ID %s", claims.ObjectID)
+	}
+	if claims.TenantID != "72f988bf-0000-0000-0000-000000000002" {
+		t.Errorf("Got tenant ID %s", claims.TenantID)
+	}
+	if claims.AppID != "e1f509c4" || claims.UPN != "jo@example.com" {
+		t.Errorf("Got app ID %s, UPN %s", claims.AppID, claims.UPN)
+	}
+	if claims.Expiry != 1603200000 {
+		t.Errorf("Got expiry %d", claims.Expiry)
+	}
+
+	for _, bad := range []string{"", "not-a-jwt", "a.!!!.c", "a." + base64.RawURLEncoding.EncodeToString([]byte("[]")) + ".c"} {
+		if _, err := parseTokenClaims(bad); err == nil {
+			t.Errorf("Expected error parsing %q", bad)
+		}
+	}
+}
@@ -0,0 +1,438 @@
+package har
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptrace"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jake-scott/apim-tools/internal/pkg/logging"
+)
+
+/*
+ *  Records the requests made through an http.RoundTripper, with headers,
+ *  timings and bodies, so they can be written out as a HAR file.  Credentials
+ *  are masked before anything is recorded.
+ */
+
+// Bodies longer than this are truncated
+const maxBodyText = 64 * 1024
+
+// Replaces the credentials in sensitive headers
+const redacted = "REDACTED"
+
+// Headers that carry credentials, whose value is masked after the scheme
+var sensitiveHeaders = map[string]bool{
+	"Authorization":                  true,
+	"Proxy-Authorization":            true,
+	"Cookie":                         true,
+	"Set-Cookie":                     true,
+	"X-Ms-Copy-Source-Authorization": true,
+}
+
+// Recorder collects the requests made through its transports
+type Recorder struct {
+	mu      sync.Mutex
+	creator Creator
+	entries []*Entry
+}
+
+// NewRecorder returns an empty recorder.  The name and version identify the
+// application in the HAR file.
+func NewRecorder(name, version string) *Recorder {
+	return &Recorder{
+		creator: Creator{Name: name, Version: version},
+		entries: []*Entry{},
+	}
+}
+
+// Transport returns a RoundTripper that sends requests with next, recording
+// them in r.  If next is nil http.DefaultTransport is used.
+func (r *Recorder) Transport(next http.RoundTripper) http.RoundTripper {
+	if next == nil {
+		next = http.DefaultTransport
+	}
+
+	return &transport{rec: r, next: next}
+}
+
+// Entries returns the number of requests recorded so far
+func (r *Recorder) Entries() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return len(r.entries)
+}
+
+// Write writes the recorded requests to w in HAR format
+func (r *Recorder) Write(w io.Writer) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	b, err := json.MarshalIndent(File{Log{Version, r.creator, r.entries}}, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	_, err = w.Write(b)
+	return err
+}
+
+// WriteFile writes the recorded requests to the named file in HAR format
+func (r *Recorder) WriteFile(path string) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+
+	if err := r.Write(f); err != nil {
+		f.Close()
+		return err
+	}
+
+	return f.Close()
+}
+
+type transport struct {
+	rec  *Recorder
+	next http.RoundTripper
+}
+
+func (t *transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	tm := &timer{start: time.Now()}
+
+	// WithContext returns a copy, so the caller's request is left alone
+	req = req.WithContext(httptrace.WithClientTrace(req.Context(), tm.clientTrace()))
+
+	reqBody := &capture{mimeType: req.Header.Get("Content-Type")}
+	if req.Body != nil && req.Body != http.NoBody {
+		req.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.TeeReader(req.Body, reqBody), req.Body}
+	}
+
+	u := redactURL(req.URL)
+	e := &Entry{
+		StartedDateTime: tm.start.Format(time.RFC3339Nano),
+		Request: Request{
+			Method:      req.Method,
+			URL:         u.String(),
+			HTTPVersion: "HTTP/1.1",
+			Cookies:     []NameValue{},
+			Headers:     headers(req.Header),
+			QueryString: queryString(u),
+			HeadersSize: -1,
+			BodySize:    -1,
+		},
+		Response: Response{
+			Cookies:     []NameValue{},
+			Headers:     []NameValue{},
+			HeadersSize: -1,
+			BodySize:    -1,
+		},
+	}
+	t.rec.add(e)
+
+	resp, err := t.next.RoundTrip(req)
+	if err != nil {
+		t.rec.finish(e, tm, reqBody, nil, logging.Redact(err.Error()))
+		return resp, err
+	}
+
+	respBody := &capture{mimeType: resp.Header.Get("Content-Type")}
+
+	t.rec.mu.Lock()
+	e.Request.HTTPVersion = resp.Proto
+	e.Response.Status = resp.StatusCode
+	e.Response.StatusText = strings.TrimSpace(strings.TrimPrefix(resp.Status, fmt.Sprint(resp.StatusCode)))
+	e.Response.HTTPVersion = resp.Proto
+	e.Response.Headers = headers(resp.Header)
+	e.Response.RedirectURL = logging.Redact(resp.Header.Get("Location"))
+	t.rec.mu.Unlock()
+
+	// The entry is complete once the caller has read or closed the body
+	resp.Body = &recordingBody{
+		ReadCloser: resp.Body,
+		content:    respBody,
+		done:       func() { t.rec.finish(e, tm, reqBody, respBody, "") },
+	}
+
+	return resp, nil
+}
+
+func (r *Recorder) add(e *Entry) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.entries = append(r.entries, e)
+}
+
+// Fill in the bodies and timings of an entry once the response is complete
+func (r *Recorder) finish(e *Entry, tm *timer, reqBody, respBody *capture, comment string) {
+	end := time.Now()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	e.Time, e.Timings = tm.timings(end)
+	e.Comment = comment
+
+	if size, text, comment := reqBody.contents(); size > 0 {
+		e.Request.BodySize = size
+		e.Request.PostData = &PostData{
+			MimeType: reqBody.mimeType,
+			Params:   []NameValue{},
+			Text:     text,
+			Comment:  comment,
+		}
+	} else {
+		e.Request.BodySize = 0
+	}
+
+	if respBody != nil {
+		size, text, comment := respBody.contents()
+		e.Response.BodySize = size
+		e.Response.Content = Content{
+			Size:     size,
+			MimeType: respBody.mimeType,
+			Text:     text,
+			Comment:  comment,
+		}
+	}
+}
+
+// Response body that completes its entry at EOF or when closed
+type recordingBody struct {
+	io.ReadCloser
+
+	content *capture
+	done    func()
+	once    sync.Once
+}
+
+func (b *recordingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.content.Write(p[:n])
+
+	if err == io.EOF {
+		b.once.Do(b.done)
+	}
+
+	return n, err
+}
+
+func (b *recordingBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(b.done)
+
+	return err
+}
+
+// capture counts the bytes of a body, keeping the start of textual bodies
+type capture struct {
+	mu       sync.Mutex
+	mimeType string
+	size     int64
+	buf      bytes.Buffer
+}
+
+func (c *capture) Write(p []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	n := len(p)
+	c.size += int64(n)
+
+	if room := maxBodyText - c.buf.Len(); room > 0 && isText(c.mimeType) {
+		if len(p) > room {
+			p = p[:room]
+		}
+		c.buf.Write(p)
+	}
+
+	return n, nil
+}
+
+// The size of the body, its redacted text and a comment explaining anything
+// left out
+func (c *capture) contents() (size int64, text, comment string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	switch {
+	case c.size == 0:
+		return 0, "", ""
+	case !isText(c.mimeType):
+		return c.size, "", fmt.Sprintf("%d bytes of %s content not recorded", c.size, c.mimeType)
+	case c.size > int64(c.buf.Len()):
+		comment = fmt.Sprintf("truncated to %d of %d bytes", c.buf.Len(), c.size)
+	}
+
+	return c.size, logging.Redact(c.buf.String()), comment
+}
+
+// Whether a body of this type is worth recording
+func isText(mimeType string) bool {
+	mimeType = strings.ToLower(mimeType)
+
+	if strings.HasPrefix(mimeType, "text/") {
+		return true
+	}
+
+	for _, t := range []string{"json", "xml", "x-www-form-urlencoded", "javascript"} {
+		if strings.Contains(mimeType, t) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func redactURL(u *url.URL) *url.URL {
+	r, err := url.Parse(logging.Redact(u.String()))
+	if err != nil {
+		return &url.URL{Scheme: u.Scheme, Host: u.Host, Path: u.Path}
+	}
+
+	return r
+}
+
+func queryString(u *url.URL) []NameValue {
+	q := u.Query()
+
+	keys := make([]string, 0, len(q))
+	for k := range q {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	out := []NameValue{}
+	for _, k := range keys {
+		for _, v := range q[k] {
+			out = append(out, NameValue{k, v})
+		}
+	}
+
+	return out
+}
+
+func headers(h http.Header) []NameValue {
+	keys := make([]string, 0, len(h))
+	for k := range h {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	out := []NameValue{}
+	for _, k := range keys {
+		for _, v := range h[k] {
+			out = append(out, NameValue{k, headerValue(k, v)})
+		}
+	}
+
+	return out
+}
+
+// Mask the credentials in a header, keeping the authorization scheme
+func headerValue(name, value string) string {
+	if !sensitiveHeaders[http.CanonicalHeaderKey(name)] {
+		return logging.Redact(value)
+	}
+
+	if i := strings.IndexByte(value, ' '); i > 0 {
+		return value[:i+1] + redacted
+	}
+
+	return redacted
+}
+
+// timer notes when each phase of a request happens
+type timer struct {
+	mu sync.Mutex
+
+	start     time.Time
+	dnsStart  time.Time
+	dnsDone   time.Time
+	connStart time.Time
+	connDone  time.Time
+	tlsStart  time.Time
+	tlsDone   time.Time
+	gotConn   time.Time
+	wrote     time.Time
+	firstByte time.Time
+}
+
+func (t *timer) clientTrace() *httptrace.ClientTrace {
+	mark := func(p *time.Time) {
+		t.mu.Lock()
+		*p = time.Now()
+		t.mu.Unlock()
+	}
+
+	return &httptrace.ClientTrace{
+		DNSStart:             func(httptrace.DNSStartInfo) { mark(&t.dnsStart) },
+		DNSDone:              func(httptrace.DNSDoneInfo) { mark(&t.dnsDone) },
+		ConnectStart:         func(string, string) { mark(&t.connStart) },
+		ConnectDone:          func(string, string, error) { mark(&t.connDone) },
+		TLSHandshakeStart:    func() { mark(&t.tlsStart) },
+		TLSHandshakeDone:     func(tls.ConnectionState, error) { mark(&t.tlsDone) },
+		GotConn:              func(httptrace.GotConnInfo) { mark(&t.gotConn) },
+		WroteRequest:         func(httptrace.WroteRequestInfo) { mark(&t.wrote) },
+		GotFirstResponseByte: func() { mark(&t.firstByte) },
+	}
+}
+
+// The total time and the time taken by each phase of a request that
+// completed at end
+func (t *timer) timings(end time.Time) (float64, Timings) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	// Connecting includes the TLS handshake
+	connEnd := t.connDone
+	if t.tlsDone.After(connEnd) {
+		connEnd = t.tlsDone
+	}
+
+	return millis(t.start, end), Timings{
+		Blocked: -1,
+		DNS:     optional(t.dnsStart, t.dnsDone),
+		Connect: optional(t.connStart, connEnd),
+		SSL:     optional(t.tlsStart, t.tlsDone),
+		Send:    required(t.gotConn, t.wrote),
+		Wait:    required(t.wrote, t.firstByte),
+		Receive: required(t.firstByte, end),
+	}
+}
+
+func millis(from, to time.Time) float64 {
+	return float64(to.Sub(from)) / float64(time.Millisecond)
+}
+
+// Duration of a phase that may not have happened
+func optional(from, to time.Time) float64 {
+	if from.IsZero() || to.IsZero() {
+		return -1
+	}
+
+	return millis(from, to)
+}
+
+// Duration of a phase that HAR requires, zero if it did not complete
+func required(from, to time.Time) float64 {
+	if from.IsZero() || to.IsZero() {
+		return 0
+	}
+
+	return millis(from, to)
+}
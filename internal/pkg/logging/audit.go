@@ -0,0 +1,157 @@
+package logging
+
+import (
+	"encoding/json"
+	"os"
+	"os/user"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+/*
+ *  Audit events record the changes made to API Manager instances: who ran
+ *  which operation against which instance, and the outcome.  They are logged
+ *  to every log target whatever the log level, and can also be appended to
+ *  an audit file as JSON lines.
+ */
+
+// Field that marks an entry as an audit event, holding the operation
+const auditField = "audit"
+
+// AuditEvent describes an operation that changed an instance
+type AuditEvent struct {
+	Time      time.Time `json:"time"`
+	Operation string    `json:"operation"`
+	Target    string    `json:"target"`
+	Result    string    `json:"result"`
+	Error     string    `json:"error,omitempty"`
+
+	// The Azure identity and the local user that ran the operation
+	Principal string `json:"principal"`
+	User      string `json:"user"`
+	Host      string `json:"host"`
+
+	// The app instance ID of the run, as logged with every entry
+	Instance string `json:"instance"`
+
+	// Operation specific counts, eg. items uploaded
+	Counts interface{} `json:"counts,omitempty"`
+}
+
+// Results of an audited operation
+const (
+	AuditSucceeded   = "succeeded"
+	AuditFailed      = "failed"
+	AuditInterrupted = "interrupted"
+)
+
+type auditLog struct {
+	mu   sync.Mutex
+	file *os.File
+}
+
+var gAudit auditLog
+
+// Audit records an event.  The time, local user, host and instance are
+// filled in if not set.
+func Audit(e AuditEvent) {
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	if e.User == "" {
+		if u, err := user.Current(); err == nil {
+			e.User = u.Username
+		}
+	}
+	if e.Host == "" {
+		e.Host, _ = os.Hostname()
+	}
+	if e.Instance == "" {
+		e.Instance = gInstanceID
+	}
+
+	e.Error = Redact(e.Error)
+
+	counts, _ := json.Marshal(e.Counts)
+	fields := logrus.Fields{
+		auditField:  e.Operation,
+		"target":    e.Target,
+		"result":    e.Result,
+		"principal": e.Principal,
+		"user":      e.User,
+		"counts":    string(counts),
+	}
+	if e.Error != "" {
+		fields["error"] = e.Error
+	}
+
+	entry := Logger().WithFields(fields)
+	entry.Time = e.Time
+	msg := "Audit: " + e.Operation + " " + e.Result
+
+	// The log level does not apply to audit events, but the console is
+	// only written to if the level allows
+	if logrus.IsLevelEnabled(logrus.InfoLevel) {
+		entry.Info(msg)
+	} else {
+		entry.Level = logrus.InfoLevel
+		entry.Message = msg
+		for _, h := range gLogger.hooks {
+			if err := h.Fire(entry); err != nil {
+				logrus.WithError(err).Warnf("Sending audit event")
+			}
+		}
+	}
+
+	gAudit.write(e)
+}
+
+// Append the event to the audit file, if there is one
+func (a *auditLog) write(e AuditEvent) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.file == nil {
+		return
+	}
+
+	line, err := json.Marshal(e)
+	if err == nil {
+		_, err = a.file.Write(append(line, '\n'))
+	}
+	if err == nil {
+		err = a.file.Sync()
+	}
+
+	if err != nil {
+		logrus.WithError(err).Errorf("Writing audit file %s", a.file.Name())
+	}
+}
+
+func (a *auditLog) open(path string) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.file != nil {
+		a.file.Close()
+		a.file = nil
+	}
+
+	if path == "" {
+		return nil
+	}
+
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	a.file = f
+
+	return nil
+}
+
+func (a *auditLog) close() error {
+	return a.open("")
+}
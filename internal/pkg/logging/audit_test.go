@@ -0,0 +1,85 @@
+package logging
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+)
+
+type recordingHook struct {
+	entries []*logrus.Entry
+}
+
+func (h *recordingHook) Levels() []logrus.Level              { return logrus.AllLevels }
+func (h *recordingHook) Fire(e *logrus.Entry) error          { h.entries = append(h.entries, e); return nil }
+func (h *recordingHook) Close() error                        { return nil }
+func (h *recordingHook) fields(n int) map[string]interface{} { return h.entries[n].Data }
+
+func TestAudit(t *testing.T) {
+	dir, err := ioutil.TempDir("", "audit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "audit.log")
+	if err := gAudit.open(path); err != nil {
+		t.Fatal(err)
+	}
+	defer gAudit.close()
+
+	hook := &recordingHook{}
+	gLogger.hooks = []closingHook{hook}
+	defer func() { gLogger.hooks = nil }()
+
+	// Audit events reach the log targets even when info is not logged
+	level := logrus.GetLevel()
+	logrus.SetLevel(logrus.ErrorLevel)
+	defer logrus.SetLevel(level)
+
+	Audit(AuditEvent{
+		Operation: "upload",
+		Target:    "https://myapim.management.azure-api.net",
+		Result:    AuditFailed,
+		Error:     errors.New("PUT https://x?sig=abc failed").Error(),
+		Principal: "someone@example.com",
+		Counts:    map[string]int{"ok": 3},
+	})
+
+	if len(hook.entries) != 1 || hook.fields(0)[auditField] != "upload" || hook.fields(0)["counts"] != `{"ok":3}` {
+		t.Fatalf("Audit event not sent to log targets: %+v", hook.entries)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	var lines []AuditEvent
+	for s := bufio.NewScanner(f); s.Scan(); {
+		var e AuditEvent
+		if err := json.Unmarshal(s.Bytes(), &e); err != nil {
+			t.Fatalf("Bad audit line %q: %s", s.Text(), err)
+		}
+		lines = append(lines, e)
+	}
+
+	if len(lines) != 1 {
+		t.Fatalf("Got %d audit lines, want 1", len(lines))
+	}
+
+	e := lines[0]
+	if e.Operation != "upload" || e.Result != AuditFailed || e.Instance != gInstanceID || e.Time.IsZero() || e.Host == "" {
+		t.Errorf("Bad audit event: %+v", e)
+	}
+	if e.Error != "PUT https://x?sig=REDACTED failed" {
+		t.Errorf("Error recorded as %q", e.Error)
+	}
+}
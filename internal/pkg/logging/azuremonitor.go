@@ -0,0 +1,255 @@
+package logging
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Azure/go-autorest/autorest"
+	"github.com/sirupsen/logrus"
+)
+
+/*
+ *  Sends log entries to an Azure Monitor Log Analytics workspace, either with
+ *  the HTTP Data Collector API (workspace ID and shared key) or the Logs
+ *  Ingestion API (data collection endpoint and rule, with an Azure AD token).
+ *  Entries are sent in batches, and any left are sent by Close.
+ */
+
+const (
+	dataCollectorAPIVersion = "2016-04-01"
+	logsIngestionAPIVersion = "2023-01-01"
+
+	// Entries sent per request
+	monitorBatchSize = 500
+)
+
+// monitorRecord is a log entry as sent to Azure Monitor.  Logs Ingestion
+// streams need these columns; Data Collector tables are created from them.
+type monitorRecord struct {
+	TimeGenerated string                 `json:"TimeGenerated"`
+	Level         string                 `json:"Level"`
+	Message       string                 `json:"Message"`
+	Computer      string                 `json:"Computer"`
+	Fields        map[string]interface{} `json:"Fields"`
+}
+
+// monitorSender posts a batch of records as JSON to a log (table or stream)
+type monitorSender func(log string, body []byte) error
+
+// monitorHook is a logrus hook that sends entries to Azure Monitor, audit
+// events to their own log
+type monitorHook struct {
+	mu sync.Mutex
+	wg sync.WaitGroup
+
+	send     monitorSender
+	token    bool
+	log      string
+	auditLog string
+	hostname string
+
+	pending map[string][]monitorRecord
+}
+
+// Authorizer for targets needing an Azure AD token, set once authentication
+// is configured
+var gAuthorizer func(resource string) (autorest.Authorizer, error)
+
+// SetAuthorizer provides Azure AD access tokens to the log targets that need
+// them.  Entries logged before it is called are held until Close.
+func SetAuthorizer(f func(resource string) (autorest.Authorizer, error)) {
+	gAuthorizer = f
+}
+
+// newMonitorHook returns a hook sending entries with send.  With token, send
+// needs the authorizer set by SetAuthorizer.
+func newMonitorHook(send monitorSender, token bool, log, auditLog string) *monitorHook {
+	hostname, _ := os.Hostname()
+
+	return &monitorHook{
+		send:     send,
+		token:    token,
+		log:      log,
+		auditLog: auditLog,
+		hostname: hostname,
+		pending:  make(map[string][]monitorRecord),
+	}
+}
+
+func (h *monitorHook) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+func (h *monitorHook) Fire(e *logrus.Entry) error {
+	e = redactEntry(e)
+
+	r := monitorRecord{
+		TimeGenerated: e.Time.UTC().Format(time.RFC3339Nano),
+		Level:         e.Level.String(),
+		Message:       e.Message,
+		Computer:      h.hostname,
+		Fields:        make(map[string]interface{}, len(e.Data)),
+	}
+
+	for k, v := range e.Data {
+		r.Fields[k] = v
+	}
+
+	log := h.log
+	if _, ok := e.Data[auditField]; ok {
+		log = h.auditLog
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.pending[log] = append(h.pending[log], r)
+
+	// Send full batches in the background, unless a token cannot be had yet
+	if len(h.pending[log]) >= monitorBatchSize && h.ready() {
+		batch := h.pending[log]
+		delete(h.pending, log)
+
+		h.wg.Add(1)
+		go func() {
+			defer h.wg.Done()
+			h.post(log, batch)
+		}()
+	}
+
+	return nil
+}
+
+// Close sends any entries not yet sent and waits for all batches to complete
+func (h *monitorHook) Close() error {
+	h.mu.Lock()
+	pending := h.pending
+	h.pending = make(map[string][]monitorRecord)
+	h.mu.Unlock()
+
+	for log, batch := range pending {
+		h.post(log, batch)
+	}
+
+	h.wg.Wait()
+
+	return nil
+}
+
+// The Logs Ingestion API needs a token, which is not available until
+// authentication is configured
+func (h *monitorHook) ready() bool {
+	return !h.token || gAuthorizer != nil
+}
+
+// Send a batch, reporting failures on stderr as the log cannot be used
+func (h *monitorHook) post(log string, batch []monitorRecord) {
+	body, err := json.Marshal(batch)
+	if err == nil {
+		err = h.send(log, body)
+	}
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Sending %d log entries to Azure Monitor %s: %s\n", len(batch), log, err)
+	}
+}
+
+// dataCollectorSender posts to the HTTP Data Collector API.  The request is
+// signed with the workspace shared key.
+func dataCollectorSender(client *http.Client, endpoint, workspaceID, sharedKey string) (monitorSender, error) {
+	key, err := base64.StdEncoding.DecodeString(sharedKey)
+	if err != nil {
+		return nil, fmt.Errorf("bad Azure Monitor shared key: %s", err)
+	}
+
+	if endpoint == "" {
+		endpoint = fmt.Sprintf("https://%s.ods.opinsights.azure.com", workspaceID)
+	}
+	reqURL := strings.TrimSuffix(endpoint, "/") + "/api/logs?api-version=" + dataCollectorAPIVersion
+
+	return func(log string, body []byte) error {
+		date := time.Now().UTC().Format(http.TimeFormat)
+
+		req, err := http.NewRequest("POST", reqURL, bytes.NewReader(body))
+		if err != nil {
+			return err
+		}
+
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Log-Type", log)
+		req.Header.Set("x-ms-date", date)
+		req.Header.Set("time-generated-field", "TimeGenerated")
+		req.Header.Set("Authorization", dataCollectorSignature(workspaceID, key, len(body), date))
+
+		return doMonitorRequest(client, req)
+	}, nil
+}
+
+// The SharedKey authorization header for a Data Collector request
+func dataCollectorSignature(workspaceID string, key []byte, length int, date string) string {
+	toSign := fmt.Sprintf("POST\n%d\napplication/json\nx-ms-date:%s\n/api/logs", length, date)
+
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(toSign))
+
+	return fmt.Sprintf("SharedKey %s:%s", workspaceID, base64.StdEncoding.EncodeToString(mac.Sum(nil)))
+}
+
+// logsIngestionSender posts to a stream of a data collection rule with the
+// Logs Ingestion API, authorized with a token for resource
+func logsIngestionSender(client *http.Client, endpoint, ruleID, resource string) monitorSender {
+	return func(stream string, body []byte) error {
+		if gAuthorizer == nil {
+			return fmt.Errorf("no Azure credentials available")
+		}
+
+		authz, err := gAuthorizer(resource)
+		if err != nil {
+			return err
+		}
+
+		reqURL := fmt.Sprintf("%s/dataCollectionRules/%s/streams/%s?api-version=%s",
+			strings.TrimSuffix(endpoint, "/"), url.PathEscape(ruleID), url.PathEscape(stream), logsIngestionAPIVersion)
+
+		req, err := http.NewRequest("POST", reqURL, bytes.NewReader(body))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", "application/json")
+
+		req, err = autorest.Prepare(req, authz.WithAuthorization())
+		if err != nil {
+			return err
+		}
+
+		return doMonitorRequest(client, req)
+	}
+}
+
+func doMonitorRequest(client *http.Client, req *http.Request) error {
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	// Only accept HTTP 2xx codes
+	if resp.StatusCode >= 300 {
+		msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("status %s received: %s", resp.Status, bytes.TrimSpace(msg))
+	}
+
+	return nil
+}
@@ -0,0 +1,90 @@
+package logging
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestDataCollector(t *testing.T) {
+	key := base64.StdEncoding.EncodeToString([]byte("workspace-key"))
+
+	var mu sync.Mutex
+	received := make(map[string][]monitorRecord)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+
+		want := dataCollectorSignature("ws1", []byte("workspace-key"), len(body), r.Header.Get("x-ms-date"))
+		if r.URL.Path != "/api/logs" || r.Header.Get("Authorization") != want {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+
+		var records []monitorRecord
+		if err := json.Unmarshal(body, &records); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		mu.Lock()
+		received[r.Header.Get("Log-Type")] = append(received[r.Header.Get("Log-Type")], records...)
+		mu.Unlock()
+	}))
+	defer srv.Close()
+
+	send, err := dataCollectorSender(srv.Client(), srv.URL, "ws1", key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := newMonitorHook(send, false, "ApimTools", "ApimToolsAudit")
+
+	log := logrus.New()
+	log.AddHook(h)
+	log.Out = &strings.Builder{}
+
+	log.WithField("token", "Bearer abc.def.ghi").Info("first")
+	log.WithField(auditField, "reset").Info("Audit: reset succeeded")
+	for n := 0; n < monitorBatchSize; n++ {
+		log.Debug("not logged at info level")
+		log.Warn("filler")
+	}
+
+	if err := h.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := len(received["ApimTools"]); got != monitorBatchSize+1 {
+		t.Errorf("Got %d entries, want %d", got, monitorBatchSize+1)
+	}
+	if got := received["ApimToolsAudit"]; len(got) != 1 || got[0].Fields[auditField] != "reset" {
+		t.Errorf("Bad audit entries: %+v", got)
+	}
+
+	var first *monitorRecord
+	for n, r := range received["ApimTools"] {
+		if r.Message == "first" {
+			first = &received["ApimTools"][n]
+		}
+	}
+	if first == nil || first.Fields["token"] != "Bearer REDACTED" || first.Level != "info" {
+		t.Errorf("Bad first entry: %+v", first)
+	}
+	if first != nil {
+		if _, err := time.Parse(time.RFC3339Nano, first.TimeGenerated); err != nil {
+			t.Errorf("Bad TimeGenerated: %s", err)
+		}
+	}
+
+	if _, err := dataCollectorSender(srv.Client(), srv.URL, "ws1", "not base64!"); err == nil {
+		t.Errorf("Bad shared key accepted")
+	}
+}
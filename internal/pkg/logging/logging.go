@@ -2,8 +2,11 @@ package logging
 
 import (
 	"fmt"
+	"io"
+	"net/http"
 	"os"
 	"path"
+	"time"
 
 	stdlog "log"
 
@@ -18,7 +21,15 @@ import (
 
 type logger struct {
 	logger  *logrus.Entry
-	logFile *os.File
+	logFile io.Closer
+	hooks   []closingHook
+}
+
+// A log target other than the log file, which must be closed to send any
+// entries it holds
+type closingHook interface {
+	logrus.Hook
+	Close() error
 }
 
 // The one singleton logger
@@ -35,10 +46,21 @@ func init() {
 	viper.SetDefault("logging.location", "stderr")
 	viper.SetDefault("logging.format", "text")
 	viper.SetDefault("logging.level", "info")
+	viper.SetDefault("logging.max-size", 0)
+	viper.SetDefault("logging.max-age", 0)
+	viper.SetDefault("logging.max-backups", 0)
+	viper.SetDefault("logging.compress", false)
+	viper.SetDefault("logging.per-run", false)
+	viper.SetDefault("logging.syslog.network", "udp")
+	viper.SetDefault("logging.syslog.facility", "user")
+	viper.SetDefault("logging.azure-monitor.resource", "https://monitor.azure.com")
 
 	// The app instantiation ID
 	gInstanceID = uuid.New().String()
 
+	// Never write credentials to the log
+	logrus.SetFormatter(&redactingFormatter{&logrus.TextFormatter{}})
+
 	gLogger.logger = logrus.WithFields(logrus.Fields{
 		"pid":      os.Getpid(),
 		"exe":      path.Base(os.Args[0]),
@@ -48,34 +70,51 @@ func init() {
 
 // Configure sets the log level and output location/format
 func Configure(cfg *viper.Viper) error {
+	return configure(cfg, true)
+}
+
+// ConfigureLocal is Configure without the Azure Monitor target, for
+// commands that run without Azure credentials or the secrets it may need
+func ConfigureLocal(cfg *viper.Viper) error {
+	return configure(cfg, false)
+}
+
+func configure(cfg *viper.Viper, azureMonitor bool) error {
 	// Configure system log location
+	var out io.WriteCloser
 	switch loc := cfg.GetString("logging.location"); loc {
 	case "stdout":
-		logrus.SetOutput(os.Stdout)
-		gLogger.logger = logrus.WithFields(logrus.Fields{})
+		out = os.Stdout
 	case "stderr":
-		logrus.SetOutput(os.Stderr)
-		gLogger.logger = logrus.WithFields(logrus.Fields{})
+		out = os.Stderr
 	default:
-		file, err := os.OpenFile(loc, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
-		if err == nil {
-			gLogger.logger.Debugf("Switching system log to %s", loc)
-			logrus.SetOutput(file)
+		file, err := openLogFile(cfg, loc)
+		if err != nil {
+			return err
+		}
 
-			if gLogger.logFile != nil {
-				gLogger.logFile.Close()
-			}
+		gLogger.logger.Debugf("Switching system log to %s", file.path)
+		out = file
+	}
 
-			gLogger.logFile = file
+	logrus.SetOutput(out)
 
-			gLogger.logger = logrus.WithFields(logrus.Fields{
-				"pid":      os.Getpid(),
-				"exe":      path.Base(os.Args[0]),
-				"instance": gInstanceID,
-			})
-		} else {
-			return err
-		}
+	// Close the file being replaced, if any
+	if gLogger.logFile != nil {
+		gLogger.logFile.Close()
+		gLogger.logFile = nil
+	}
+	if out != os.Stdout && out != os.Stderr {
+		gLogger.logFile = out
+	}
+
+	// Additional log targets
+	if err := configureHooks(cfg, azureMonitor); err != nil {
+		return err
+	}
+
+	if err := gAudit.open(cfg.GetString("logging.audit-file")); err != nil {
+		return err
 	}
 
 	// Obey the level setting in the config if not already in debug mode
@@ -91,7 +130,7 @@ func Configure(cfg *viper.Viper) error {
 
 	format := cfg.GetString("logging.format")
 	if format == "json" {
-		logrus.SetFormatter(&logrus.JSONFormatter{})
+		logrus.SetFormatter(&redactingFormatter{&logrus.JSONFormatter{}})
 	}
 
 	// Override the standard system logger
@@ -99,3 +138,129 @@ func Configure(cfg *viper.Viper) error {
 
 	return nil
 }
+
+// Close sends any log entries held by the log targets, and closes the log
+// and audit files.  Entries logged afterwards go to stderr.
+func Close() {
+	closeHooks()
+
+	if err := gAudit.close(); err != nil {
+		fmt.Fprintf(os.Stderr, "Closing audit file: %s\n", err)
+	}
+
+	if gLogger.logFile != nil {
+		logrus.SetOutput(os.Stderr)
+		gLogger.logFile.Close()
+		gLogger.logFile = nil
+	}
+}
+
+// Set up the syslog and, if wanted, Azure Monitor targets from the config,
+// replacing any already configured
+func configureHooks(cfg *viper.Viper, azureMonitor bool) error {
+	closeHooks()
+
+	if addr := cfg.GetString("logging.syslog.address"); addr != "" {
+		h, err := newSyslogHook(cfg.GetString("logging.syslog.network"), addr, cfg.GetString("logging.syslog.facility"))
+		if err != nil {
+			return err
+		}
+
+		addHook(h)
+	}
+
+	if !azureMonitor {
+		return nil
+	}
+
+	h, err := azureMonitorHook(cfg)
+	if err != nil {
+		return err
+	}
+	if h != nil {
+		addHook(h)
+	}
+
+	return nil
+}
+
+// The Azure Monitor target, if configured.  A workspace ID selects the Data
+// Collector API, a data collection rule ID the Logs Ingestion API.
+func azureMonitorHook(cfg *viper.Viper) (*monitorHook, error) {
+	client := &http.Client{Timeout: 30 * time.Second}
+	endpoint := cfg.GetString("logging.azure-monitor.endpoint")
+
+	switch {
+	case cfg.GetString("logging.azure-monitor.workspace-id") != "":
+		send, err := dataCollectorSender(client, endpoint,
+			cfg.GetString("logging.azure-monitor.workspace-id"), cfg.GetString("logging.azure-monitor.shared-key"))
+		if err != nil {
+			return nil, err
+		}
+
+		return newMonitorHook(send, false,
+			stringOr(cfg, "logging.azure-monitor.log", "ApimTools"),
+			stringOr(cfg, "logging.azure-monitor.audit-log", "ApimToolsAudit")), nil
+
+	case cfg.GetString("logging.azure-monitor.rule-id") != "":
+		if endpoint == "" {
+			return nil, fmt.Errorf("logging.azure-monitor.endpoint is needed with rule-id")
+		}
+
+		send := logsIngestionSender(client, endpoint,
+			cfg.GetString("logging.azure-monitor.rule-id"), cfg.GetString("logging.azure-monitor.resource"))
+
+		return newMonitorHook(send, true,
+			stringOr(cfg, "logging.azure-monitor.log", "Custom-ApimTools_CL"),
+			stringOr(cfg, "logging.azure-monitor.audit-log", "Custom-ApimToolsAudit_CL")), nil
+	}
+
+	return nil, nil
+}
+
+func addHook(h closingHook) {
+	logrus.AddHook(h)
+	gLogger.hooks = append(gLogger.hooks, h)
+}
+
+func closeHooks() {
+	logrus.StandardLogger().ReplaceHooks(make(logrus.LevelHooks))
+
+	for _, h := range gLogger.hooks {
+		if err := h.Close(); err != nil {
+			fmt.Fprintf(os.Stderr, "Closing log target: %s\n", err)
+		}
+	}
+	gLogger.hooks = nil
+}
+
+func stringOr(cfg *viper.Viper, key, def string) string {
+	if s := cfg.GetString(key); s != "" {
+		return s
+	}
+
+	return def
+}
+
+// Open a log file with the rotation settings from the config.  With per-run
+// files, the app instance ID is added to the name so each run has its own file.
+func openLogFile(cfg *viper.Viper, loc string) (*rotatingFile, error) {
+	opts := rotateOptions{
+		maxSize:    cfg.GetInt64("logging.max-size") * 1024 * 1024,
+		maxAge:     time.Duration(cfg.GetInt("logging.max-age")) * 24 * time.Hour,
+		maxBackups: cfg.GetInt("logging.max-backups"),
+		compress:   cfg.GetBool("logging.compress"),
+	}
+
+	if opts.maxSize < 0 || opts.maxAge < 0 || opts.maxBackups < 0 {
+		return nil, fmt.Errorf("logging.max-size, max-age and max-backups must not be negative")
+	}
+
+	path := loc
+	if cfg.GetBool("logging.per-run") {
+		prefix, ext := backupPattern(loc)
+		path = prefix + gInstanceID + ext
+	}
+
+	return openRotatingFile(path, loc, opts)
+}
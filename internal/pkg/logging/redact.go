@@ -0,0 +1,77 @@
+package logging
+
+import (
+	"regexp"
+
+	"github.com/sirupsen/logrus"
+)
+
+/*
+ *  Masks credentials in log entries, so that debug logs can be shared
+ */
+
+// Replaces redacted values
+const redactedValue = "REDACTED"
+
+// Credentials that may appear in log messages.  The first group of each
+// pattern is kept and the rest of the match is replaced.
+var redactPatterns = []*regexp.Regexp{
+	// SAS URL and token signatures
+	regexp.MustCompile(`(?i)(\bsig=)[^&;\s"']+`),
+
+	// APIM SAS tokens in Authorization headers and storage connection strings
+	regexp.MustCompile(`(?i)(\bSharedAccessSignature[ =])[^\s"';]+`),
+
+	// APIM SAS tokens on their own, eg. in management API responses
+	regexp.MustCompile(`(\b\w+&\d{12}&)[A-Za-z0-9+/]+=*`),
+	regexp.MustCompile(`(?i)(\buid=\w+&ex=[^&\s"']+&sn=)[^&\s"']+`),
+
+	// Storage account keys
+	regexp.MustCompile(`(?i)(\bAccountKey=)[^;\s"']+`),
+
+	// OAuth access tokens
+	regexp.MustCompile(`(?i)(\bBearer )[\w\-.~+/]+=*`),
+
+	// Secret fields in structs dumped with %+v, JSON and form bodies
+	regexp.MustCompile(`(?i)(\b(?:client_?secret|client_?cert_?password|cert-password|primary_?key|secondary_?key|shared-key|client_assertion|access_token|refresh_token|password)["']?[:=]\s?["']?)[^\s"'&,}]+`),
+}
+
+// Redact masks any credentials in s
+func Redact(s string) string {
+	for _, re := range redactPatterns {
+		s = re.ReplaceAllString(s, "${1}"+redactedValue)
+	}
+
+	return s
+}
+
+// redactingFormatter masks credentials in the message and fields of log
+// entries before passing them to the real formatter
+type redactingFormatter struct {
+	logrus.Formatter
+}
+
+func (f *redactingFormatter) Format(e *logrus.Entry) ([]byte, error) {
+	return f.Formatter.Format(redactEntry(e))
+}
+
+// Return a copy of an entry with the credentials in its message and fields
+// masked, leaving the caller's entry untouched
+func redactEntry(e *logrus.Entry) *logrus.Entry {
+	c := *e
+	c.Message = Redact(e.Message)
+	c.Data = make(logrus.Fields, len(e.Data))
+
+	for k, v := range e.Data {
+		switch val := v.(type) {
+		case string:
+			c.Data[k] = Redact(val)
+		case error:
+			c.Data[k] = Redact(val.Error())
+		default:
+			c.Data[k] = v
+		}
+	}
+
+	return &c
+}
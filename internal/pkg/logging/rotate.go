@@ -0,0 +1,265 @@
+package logging
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+ *  A log file that is rotated once it reaches a maximum size.  Rotated files
+ *  (backups) are renamed with a timestamp and optionally compressed, and old
+ *  backups are removed by age and count.
+ */
+
+// Timestamp added to the name of a rotated file
+const backupTimeFormat = "2006-01-02T15-04-05.000"
+
+// Suffix of a compressed backup
+const compressSuffix = ".gz"
+
+// What a backup adds to the log file name: the rotation timestamp, the ID of
+// a per-run log file or both, so other files named after the log file (eg.
+// an audit log) are never taken for backups
+var backupSuffixRegexp = regexp.MustCompile(`^(` + uuidPattern + `|` + uuidPattern + `-` + timestampPattern + `|` + timestampPattern + `)$`)
+
+const (
+	uuidPattern      = `[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}`
+	timestampPattern = `\d{4}-\d{2}-\d{2}T\d{2}-\d{2}-\d{2}\.\d{3}`
+)
+
+// rotateOptions control when a log file is rotated and how long backups are
+// kept.  Zero values disable the corresponding limit.
+type rotateOptions struct {
+	maxSize    int64
+	maxAge     time.Duration
+	maxBackups int
+	compress   bool
+}
+
+// rotatingFile is an io.WriteCloser that writes to a log file, rotating it
+// as configured
+type rotatingFile struct {
+	mu   sync.Mutex
+	path string
+	opts rotateOptions
+
+	// Backups are named after this file, which differs from path for per-run
+	// log files so the files of earlier runs are pruned too
+	base string
+
+	file *os.File
+	size int64
+}
+
+// openRotatingFile opens the log file at path for appending and removes any
+// backups of base that are past their limits
+func openRotatingFile(path, base string, opts rotateOptions) (*rotatingFile, error) {
+	r := &rotatingFile{path: path, base: base, opts: opts}
+
+	if err := r.open(); err != nil {
+		return nil, err
+	}
+
+	if err := r.prune(); err != nil {
+		r.file.Close()
+		return nil, err
+	}
+
+	return r, nil
+}
+
+func (r *rotatingFile) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.file == nil {
+		return 0, os.ErrClosed
+	}
+
+	// A single entry larger than the limit is still written whole
+	if r.opts.maxSize > 0 && r.size > 0 && r.size+int64(len(p)) > r.opts.maxSize {
+		if err := r.rotate(); err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := r.file.Write(p)
+	r.size += int64(n)
+
+	return n, err
+}
+
+func (r *rotatingFile) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.file == nil {
+		return nil
+	}
+
+	err := r.file.Close()
+	r.file = nil
+
+	return err
+}
+
+func (r *rotatingFile) open() error {
+	f, err := os.OpenFile(r.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+
+	r.file = f
+	r.size = fi.Size()
+
+	return nil
+}
+
+// Move the current file aside and start a new one
+func (r *rotatingFile) rotate() error {
+	if err := r.file.Close(); err != nil {
+		return err
+	}
+	r.file = nil
+
+	prefix, ext := backupPattern(r.path)
+	backup := prefix + time.Now().Format(backupTimeFormat) + ext
+
+	if err := os.Rename(r.path, backup); err != nil {
+		return err
+	}
+
+	if err := r.open(); err != nil {
+		return err
+	}
+
+	if r.opts.compress {
+		if err := compressFile(backup); err != nil {
+			fmt.Fprintf(os.Stderr, "Compressing log file %s: %s\n", backup, err)
+		}
+	}
+
+	return r.prune()
+}
+
+// Remove the backups older than maxAge and all but the newest maxBackups
+func (r *rotatingFile) prune() error {
+	if r.opts.maxAge <= 0 && r.opts.maxBackups <= 0 {
+		return nil
+	}
+
+	backups, err := r.backups()
+	if err != nil {
+		return err
+	}
+
+	cutoff := time.Now().Add(-r.opts.maxAge)
+	for n, fi := range backups {
+		tooOld := r.opts.maxAge > 0 && fi.ModTime().Before(cutoff)
+		tooMany := r.opts.maxBackups > 0 && n >= r.opts.maxBackups
+
+		if tooOld || tooMany {
+			path := filepath.Join(filepath.Dir(r.path), fi.Name())
+			if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// The backups of the log file, newest first
+func (r *rotatingFile) backups() ([]os.FileInfo, error) {
+	files, err := ioutil.ReadDir(filepath.Dir(r.path))
+	if err != nil {
+		return nil, err
+	}
+
+	prefix, ext := backupPattern(r.base)
+	prefix = filepath.Base(prefix)
+
+	var out []os.FileInfo
+	for _, fi := range files {
+		name := strings.TrimSuffix(fi.Name(), compressSuffix)
+		if fi.IsDir() || fi.Name() == filepath.Base(r.path) {
+			continue
+		}
+
+		if !strings.HasPrefix(name, prefix) || !strings.HasSuffix(name, ext) || len(name) < len(prefix)+len(ext) {
+			continue
+		}
+
+		if backupSuffixRegexp.MatchString(name[len(prefix) : len(name)-len(ext)]) {
+			out = append(out, fi)
+		}
+	}
+
+	sort.Slice(out, func(i, j int) bool {
+		return out[i].ModTime().After(out[j].ModTime())
+	})
+
+	return out, nil
+}
+
+// The name of a backup is the log file name with a suffix added before the
+// extension, eg. apim-tools.log becomes apim-tools-<suffix>.log
+func backupPattern(path string) (prefix, ext string) {
+	ext = filepath.Ext(path)
+	return strings.TrimSuffix(path, ext) + "-", ext
+}
+
+// Replace a file with a gzipped copy
+func compressFile(path string) error {
+	in, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	fi, err := in.Stat()
+	if err != nil {
+		return err
+	}
+
+	out, err := os.OpenFile(path+compressSuffix, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, fi.Mode())
+	if err != nil {
+		return err
+	}
+
+	gz := gzip.NewWriter(out)
+	_, err = io.Copy(gz, in)
+	if err == nil {
+		err = gz.Close()
+	}
+	if err2 := out.Close(); err == nil {
+		err = err2
+	}
+
+	if err != nil {
+		os.Remove(path + compressSuffix)
+		return err
+	}
+
+	// Keep the time of the last entry so backups are pruned by age correctly
+	if err := os.Chtimes(path+compressSuffix, fi.ModTime(), fi.ModTime()); err != nil {
+		return err
+	}
+
+	return os.Remove(path)
+}
@@ -0,0 +1,125 @@
+package logging
+
+import (
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+func TestRotatingFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rotate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "apim-tools.log")
+
+	// An old backup and unrelated files, one named after the log file
+	old := filepath.Join(dir, "apim-tools-2020-01-01T00-00-00.000.log")
+	ioutil.WriteFile(old, []byte("old\n"), 0644)
+	os.Chtimes(old, time.Now().Add(-48*time.Hour), time.Now().Add(-48*time.Hour))
+	ioutil.WriteFile(filepath.Join(dir, "other.log"), []byte("other\n"), 0644)
+	audit := filepath.Join(dir, "apim-tools-audit.log")
+	ioutil.WriteFile(audit, []byte("audit\n"), 0644)
+	os.Chtimes(audit, time.Now().Add(-48*time.Hour), time.Now().Add(-48*time.Hour))
+
+	r, err := openRotatingFile(path, path, rotateOptions{maxSize: 10, maxAge: 24 * time.Hour, maxBackups: 2, compress: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	if _, err := os.Stat(old); !os.IsNotExist(err) {
+		t.Errorf("Backup older than max age not removed")
+	}
+	if _, err := os.Stat(audit); err != nil {
+		t.Errorf("File named after the log file taken for a backup: %s", err)
+	}
+
+	// Each line after the first rotates the file
+	for _, line := range []string{"line 1\n", "line 2\n", "line 3\n", "line 4\n"} {
+		if _, err := r.Write([]byte(line)); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	data, _ := ioutil.ReadFile(path)
+	if string(data) != "line 4\n" {
+		t.Errorf("Current file holds %q", data)
+	}
+
+	backups, err := r.backups()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(backups) != 2 {
+		t.Fatalf("Got %d backups, want 2", len(backups))
+	}
+
+	// The newest backup holds the previous line, compressed
+	if !strings.HasSuffix(backups[0].Name(), ".log"+compressSuffix) {
+		t.Fatalf("Backup %s not compressed", backups[0].Name())
+	}
+
+	f, err := os.Open(filepath.Join(dir, backups[0].Name()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := ioutil.ReadAll(gz); string(data) != "line 3\n" {
+		t.Errorf("Newest backup holds %q", data)
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, "other.log")); err != nil {
+		t.Errorf("Unrelated file removed: %s", err)
+	}
+}
+
+func TestPerRunLogFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rotate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// The files of three earlier runs, one rotated and compressed
+	for _, name := range []string{
+		"apim-tools-3f1c2a9e-5b7d-4e8a-9c0f-1a2b3c4d5e6f.log",
+		"apim-tools-7d4e5f6a-1b2c-4d3e-8f9a-0b1c2d3e4f5a.log",
+		"apim-tools-a1b2c3d4-e5f6-4a7b-8c9d-0e1f2a3b4c5d-2020-01-01T00-00-00.000.log.gz",
+	} {
+		ioutil.WriteFile(filepath.Join(dir, name), []byte(name+"\n"), 0644)
+	}
+
+	cfg := viper.New()
+	cfg.Set("logging.per-run", true)
+	cfg.Set("logging.max-backups", 1)
+
+	r, err := openLogFile(cfg, filepath.Join(dir, "apim-tools.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	if filepath.Base(r.path) != "apim-tools-"+gInstanceID+".log" {
+		t.Errorf("Per-run file named %s", r.path)
+	}
+
+	files, _ := ioutil.ReadDir(dir)
+	if len(files) != 2 {
+		t.Errorf("Got %d files, want this run's and one earlier run's", len(files))
+	}
+}
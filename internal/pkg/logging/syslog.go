@@ -0,0 +1,221 @@
+package logging
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+/*
+ *  Sends log entries to a syslog server in RFC5424 format, over UDP, TCP or
+ *  a unix socket.  The entry fields are sent as structured data.
+ */
+
+// Structured data ID for the entry fields.  32473 is the example enterprise
+// number reserved by RFC5612 for documentation and private use.
+const syslogSDID = "fields@32473"
+
+// Longest message sent over UDP, to avoid fragmentation
+const maxUDPMessage = 1024 * 8
+
+// Syslog facility codes by name
+var syslogFacilities = map[string]int{
+	"kern": 0, "user": 1, "mail": 2, "daemon": 3, "auth": 4, "syslog": 5,
+	"lpr": 6, "news": 7, "uucp": 8, "cron": 9, "authpriv": 10, "ftp": 11,
+	"local0": 16, "local1": 17, "local2": 18, "local3": 19,
+	"local4": 20, "local5": 21, "local6": 22, "local7": 23,
+}
+
+// Syslog severities for logrus levels
+var syslogSeverities = map[logrus.Level]int{
+	logrus.PanicLevel: 0, // emergency
+	logrus.FatalLevel: 2, // critical
+	logrus.ErrorLevel: 3,
+	logrus.WarnLevel:  4,
+	logrus.InfoLevel:  6,
+	logrus.DebugLevel: 7,
+	logrus.TraceLevel: 7,
+}
+
+// syslogHook is a logrus hook that sends entries to a syslog server
+type syslogHook struct {
+	mu sync.Mutex
+
+	network  string
+	address  string
+	facility int
+	hostname string
+	appName  string
+
+	conn net.Conn
+}
+
+// newSyslogHook returns a hook sending to address over network, which is
+// udp, tcp, unix (stream) or unixgram.  The connection is made when the first
+// entry is sent.
+func newSyslogHook(network, address, facility string) (*syslogHook, error) {
+	switch network {
+	case "udp", "tcp", "unix", "unixgram":
+	default:
+		return nil, fmt.Errorf("bad syslog network %q, expected udp, tcp, unix or unixgram", network)
+	}
+
+	if address == "" {
+		return nil, fmt.Errorf("no syslog address")
+	}
+
+	code, ok := syslogFacilities[strings.ToLower(facility)]
+	if !ok {
+		return nil, fmt.Errorf("bad syslog facility %q", facility)
+	}
+
+	hostname, err := os.Hostname()
+	if err != nil || hostname == "" {
+		hostname = "-"
+	}
+
+	return &syslogHook{
+		network:  network,
+		address:  address,
+		facility: code,
+		hostname: hostname,
+		appName:  path.Base(os.Args[0]),
+	}, nil
+}
+
+func (h *syslogHook) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+func (h *syslogHook) Fire(e *logrus.Entry) error {
+	msg := h.format(redactEntry(e))
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	// Reconnect once if the server has gone away
+	err := h.send(msg)
+	if err != nil && h.conn != nil {
+		h.conn.Close()
+		h.conn = nil
+		err = h.send(msg)
+	}
+
+	return err
+}
+
+func (h *syslogHook) Close() error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.conn == nil {
+		return nil
+	}
+
+	err := h.conn.Close()
+	h.conn = nil
+
+	return err
+}
+
+func (h *syslogHook) send(msg []byte) error {
+	if h.conn == nil {
+		conn, err := net.DialTimeout(h.network, h.address, 5*time.Second)
+		if err != nil {
+			return err
+		}
+		h.conn = conn
+	}
+
+	// Stream transports need framing, see RFC6587 octet counting
+	if h.network == "tcp" || h.network == "unix" {
+		msg = append([]byte(fmt.Sprintf("%d ", len(msg))), msg...)
+	} else if len(msg) > maxUDPMessage {
+		msg = msg[:maxUDPMessage]
+	}
+
+	h.conn.SetWriteDeadline(time.Now().Add(5 * time.Second))
+	_, err := h.conn.Write(msg)
+
+	return err
+}
+
+// Format an entry as an RFC5424 message:
+// <PRI>1 TIMESTAMP HOSTNAME APP-NAME PROCID MSGID [SD] MSG
+func (h *syslogHook) format(e *logrus.Entry) []byte {
+	var b bytes.Buffer
+
+	msgID := "-"
+	if _, ok := e.Data[auditField]; ok {
+		msgID = "audit"
+	}
+
+	fmt.Fprintf(&b, "<%d>1 %s %s %s %d %s ",
+		h.facility*8+syslogSeverities[e.Level],
+		e.Time.UTC().Format("2006-01-02T15:04:05.000000Z"),
+		headerField(h.hostname, 255), headerField(h.appName, 48), os.Getpid(), headerField(msgID, 32))
+
+	if len(e.Data) == 0 {
+		b.WriteString("-")
+	} else {
+		keys := make([]string, 0, len(e.Data))
+		for k := range e.Data {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+
+		b.WriteString("[" + syslogSDID)
+		for _, k := range keys {
+			fmt.Fprintf(&b, ` %s="%s"`, sdName(k), sdValue(fmt.Sprint(e.Data[k])))
+		}
+		b.WriteString("]")
+	}
+
+	if e.Message != "" {
+		b.WriteString(" " + e.Message)
+	}
+
+	return b.Bytes()
+}
+
+// Header fields are printable ASCII without spaces, "-" if empty
+func headerField(s string, max int) string {
+	s = strings.Map(func(r rune) rune {
+		if r <= ' ' || r > '~' {
+			return -1
+		}
+		return r
+	}, s)
+
+	if len(s) > max {
+		s = s[:max]
+	}
+	if s == "" {
+		return "-"
+	}
+
+	return s
+}
+
+// Structured data parameter names cannot contain = ] " or spaces
+func sdName(s string) string {
+	return headerField(strings.Map(func(r rune) rune {
+		if r == '=' || r == ']' || r == '"' {
+			return '_'
+		}
+		return r
+	}, s), 32)
+}
+
+// Structured data parameter values escape " \ and ]
+func sdValue(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, `]`, `\]`).Replace(s)
+}
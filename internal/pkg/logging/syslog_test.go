@@ -0,0 +1,89 @@
+package logging
+
+import (
+	"bufio"
+	"net"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestSyslogFormat(t *testing.T) {
+	h, err := newSyslogHook("udp", "127.0.0.1:514", "local0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.hostname = "jumphost"
+	h.appName = "apim-tools"
+
+	e := &logrus.Entry{
+		Time:    time.Date(2020, 10, 18, 15, 4, 5, 0, time.UTC),
+		Level:   logrus.WarnLevel,
+		Message: "Uploading https://x/y?sig=abc",
+		Data:    logrus.Fields{"instance": "1234", "quoted": `say "hi"]`},
+	}
+
+	got := string(h.format(redactEntry(e)))
+	want := `^<132>1 2020-10-18T15:04:05.000000Z jumphost apim-tools \d+ - ` +
+		regexp.QuoteMeta(`[fields@32473 instance="1234" quoted="say \"hi\"\]"] Uploading https://x/y?sig=REDACTED`) + `$`
+
+	if !regexp.MustCompile(want).MatchString(got) {
+		t.Errorf("Got  %s\nwant %s", got, want)
+	}
+
+	e.Data = logrus.Fields{auditField: "upload"}
+	if got := string(h.format(e)); !strings.Contains(got, " audit [fields@32473 audit=\"upload\"]") {
+		t.Errorf("Audit event formatted as %s", got)
+	}
+}
+
+func TestSyslogTCP(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	received := make(chan string, 1)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		line, _ := bufio.NewReader(conn).ReadString(']')
+		received <- line
+	}()
+
+	h, err := newSyslogHook("tcp", l.Addr().String(), "user")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.Close()
+
+	log := logrus.New()
+	log.AddHook(h)
+	log.Out = &strings.Builder{}
+	log.WithField("instance", "1234").Info("hello")
+
+	select {
+	case got := <-received:
+		// Octet counted framing, then the message
+		if !regexp.MustCompile(`^\d+ <14>1 `).MatchString(got) {
+			t.Errorf("Received %q", got)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Nothing received")
+	}
+
+	if _, err := newSyslogHook("http", "x", "user"); err == nil {
+		t.Errorf("Bad network accepted")
+	}
+	if _, err := newSyslogHook("udp", "x", "nosuch"); err == nil {
+		t.Errorf("Bad facility accepted")
+	}
+}
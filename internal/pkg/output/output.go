@@ -0,0 +1,232 @@
+package output
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"text/template"
+
+	"gopkg.in/yaml.v2"
+)
+
+/*
+ *  Renders command results in the format requested by the user
+ */
+
+// TextFunc renders a result in the command's own human readable format
+type TextFunc func(w io.Writer) error
+
+// Format describes how to render command results
+type Format struct {
+	kind     string
+	template *template.Template
+}
+
+// Supported format names
+const (
+	Text     = "text"
+	JSON     = "json"
+	YAML     = "yaml"
+	Table    = "table"
+	Template = "template"
+)
+
+// Parse returns the Format described by spec, which is one of text, json,
+// yaml, table or template=<go-template>
+func Parse(spec string) (f Format, err error) {
+	switch {
+	case spec == "" || spec == Text:
+		f.kind = Text
+	case spec == JSON || spec == YAML || spec == Table:
+		f.kind = spec
+	case strings.HasPrefix(spec, Template+"="):
+		f.kind = Template
+		f.template, err = template.New("output").Parse(strings.TrimPrefix(spec, Template+"="))
+		if err != nil {
+			return f, fmt.Errorf("bad output template: %s", err)
+		}
+	default:
+		return f, fmt.Errorf("unknown output format %q, expected one of text, json, yaml, table or template=<go-template>", spec)
+	}
+
+	return f, nil
+}
+
+// Kind returns the name of the format
+func (f Format) Kind() string {
+	return f.kind
+}
+
+// Write renders the result v to w.  The text format is delegated to text,
+// the other formats work from the JSON representation of v so that field
+// names are the same whichever format is chosen.
+func (f Format) Write(w io.Writer, v interface{}, text TextFunc) error {
+	switch f.kind {
+	case JSON:
+		b, err := json.MarshalIndent(v, "", "    ")
+		if err != nil {
+			return err
+		}
+
+		_, err = fmt.Fprintln(w, string(b))
+		return err
+	case YAML:
+		g, err := generic(v)
+		if err != nil {
+			return err
+		}
+
+		b, err := yaml.Marshal(g)
+		if err != nil {
+			return err
+		}
+
+		_, err = w.Write(b)
+		return err
+	case Table:
+		return writeTable(w, v)
+	case Template:
+		g, err := generic(v)
+		if err != nil {
+			return err
+		}
+
+		if err := f.template.Execute(w, g); err != nil {
+			return err
+		}
+
+		_, err = fmt.Fprintln(w)
+		return err
+	default:
+		return text(w)
+	}
+}
+
+// Convert v to its JSON representation as maps, slices and scalars
+func generic(v interface{}) (g interface{}, err error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+
+	err = json.Unmarshal(b, &g)
+	return g, err
+}
+
+// Write a struct as a two column field/value table, or a slice of structs
+// with one row per element and a column per field
+func writeTable(w io.Writer, v interface{}) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+
+	rv := reflect.Indirect(reflect.ValueOf(v))
+	switch rv.Kind() {
+	case reflect.Slice, reflect.Array:
+		elemType := rv.Type().Elem()
+		for elemType.Kind() == reflect.Ptr {
+			elemType = elemType.Elem()
+		}
+
+		if elemType.Kind() != reflect.Struct {
+			for i := 0; i < rv.Len(); i++ {
+				fmt.Fprintln(tw, cellValue(rv.Index(i)))
+			}
+			break
+		}
+
+		fields := tableFields(elemType)
+
+		headers := make([]string, 0, len(fields))
+		for _, f := range fields {
+			headers = append(headers, strings.ToUpper(f.name))
+		}
+		fmt.Fprintln(tw, strings.Join(headers, "\t"))
+
+		for i := 0; i < rv.Len(); i++ {
+			elem := reflect.Indirect(rv.Index(i))
+
+			cells := make([]string, 0, len(fields))
+			for _, f := range fields {
+				cells = append(cells, cellValue(elem.Field(f.index)))
+			}
+			fmt.Fprintln(tw, strings.Join(cells, "\t"))
+		}
+	case reflect.Struct:
+		fmt.Fprintln(tw, "FIELD\tVALUE")
+		for _, f := range tableFields(rv.Type()) {
+			fmt.Fprintf(tw, "%s\t%s\n", f.name, cellValue(rv.Field(f.index)))
+		}
+	default:
+		fmt.Fprintln(tw, cellValue(rv))
+	}
+
+	return tw.Flush()
+}
+
+type tableField struct {
+	name  string
+	index int
+}
+
+// Return the exported fields of a struct type, named by their JSON tag
+func tableFields(t reflect.Type) []tableField {
+	fields := make([]tableField, 0, t.NumField())
+
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		if sf.PkgPath != "" {
+			continue
+		}
+
+		name := sf.Name
+		if tag := strings.Split(sf.Tag.Get("json"), ",")[0]; tag == "-" {
+			continue
+		} else if tag != "" {
+			name = tag
+		}
+
+		fields = append(fields, tableField{name, i})
+	}
+
+	return fields
+}
+
+// Render a value for a table cell
+func cellValue(v reflect.Value) string {
+	if !v.IsValid() {
+		return ""
+	}
+
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			return ""
+		}
+	case reflect.Slice, reflect.Array:
+		if _, ok := v.Interface().(fmt.Stringer); ok {
+			break
+		}
+
+		items := make([]string, 0, v.Len())
+		for i := 0; i < v.Len(); i++ {
+			items = append(items, cellValue(v.Index(i)))
+		}
+		return strings.Join(items, ",")
+	case reflect.Map:
+		if _, ok := v.Interface().(fmt.Stringer); ok {
+			break
+		}
+
+		items := make([]string, 0, v.Len())
+		for _, k := range v.MapKeys() {
+			items = append(items, fmt.Sprintf("%v=%v", k.Interface(), v.MapIndex(k).Interface()))
+		}
+		sort.Strings(items)
+		return strings.Join(items, ",")
+	}
+
+	return fmt.Sprint(v.Interface())
+}
@@ -0,0 +1,97 @@
+package output
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+type testCounts struct {
+	OK     int `json:"ok"`
+	Errors int `json:"errors"`
+}
+
+type testResult struct {
+	Name     string            `json:"name"`
+	Deployed bool              `json:"is_deployed"`
+	Tags     map[string]string `json:"tags"`
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		spec      string
+		kind      string
+		expectErr bool
+	}{
+		{"", Text, false},
+		{"text", Text, false},
+		{"json", JSON, false},
+		{"yaml", YAML, false},
+		{"table", Table, false},
+		{"template={{.name}}", Template, false},
+		{"template={{.name", "", true},
+		{"xml", "", true},
+	}
+
+	for _, tt := range tests {
+		f, err := Parse(tt.spec)
+		if tt.expectErr {
+			if err == nil {
+				t.Errorf("Expected error parsing %q", tt.spec)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("Parsing %q: %s", tt.spec, err)
+		} else if f.Kind() != tt.kind {
+			t.Errorf("Parsing %q: got %s, wanted %s", tt.spec, f.Kind(), tt.kind)
+		}
+	}
+}
+
+func TestWrite(t *testing.T) {
+	result := testResult{
+		Name:     "myapim",
+		Deployed: true,
+		Tags:     map[string]string{"env": "prod", "cost": "42"},
+	}
+
+	list := []testCounts{{1, 2}, {30, 0}}
+
+	text := func(w io.Writer) error {
+		_, err := io.WriteString(w, "text output\n")
+		return err
+	}
+
+	tests := []struct {
+		spec string
+		v    interface{}
+		want string
+	}{
+		{"text", result, "text output\n"},
+		{"json", testCounts{1, 2}, "{\n    \"ok\": 1,\n    \"errors\": 2\n}\n"},
+		{"yaml", result, "is_deployed: true\nname: myapim\ntags:\n  cost: \"42\"\n  env: prod\n"},
+		{"table", result, "FIELD        VALUE\nname         myapim\nis_deployed  true\ntags         cost=42,env=prod\n"},
+		{"table", list, "OK  ERRORS\n1   2\n30  0\n"},
+		{"template={{.name}} {{.tags.env}}", result, "myapim prod\n"},
+		{"template={{range .}}{{.ok}};{{end}}", list, "1;30;\n"},
+	}
+
+	for _, tt := range tests {
+		f, err := Parse(tt.spec)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		buf := new(bytes.Buffer)
+		if err := f.Write(buf, tt.v, text); err != nil {
+			t.Errorf("Writing %s: %s", tt.spec, err)
+			continue
+		}
+
+		if buf.String() != tt.want {
+			t.Errorf("Writing %s: got %q, wanted %q", tt.spec, buf.String(), tt.want)
+		}
+	}
+}
@@ -0,0 +1,308 @@
+package progress
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/jake-scott/apim-tools/internal/pkg/logging"
+)
+
+/*
+ *  Reports the progress of long running transfers.  On a terminal a bar is
+ *  redrawn on stderr with the item and byte counts, throughput and ETA;
+ *  otherwise the same details are logged periodically.
+ */
+
+// Modes of reporting progress
+const (
+	ModeAuto = "auto" // a bar on a terminal, otherwise log lines
+	ModeBar  = "bar"
+	ModeLog  = "log"
+	ModeNone = "none"
+)
+
+const (
+	barWidth    = 24
+	barInterval = 200 * time.Millisecond
+	logInterval = 10 * time.Second
+)
+
+var gMode = ModeAuto
+
+// Where bars are drawn
+var gOut io.Writer = os.Stderr
+
+// Configure sets how progress is reported, one of the Mode constants
+func Configure(mode string) error {
+	switch mode {
+	case ModeAuto, ModeBar, ModeLog, ModeNone:
+		gMode = mode
+		return nil
+	}
+
+	return fmt.Errorf("bad progress mode %q, expected auto, bar, log or none", mode)
+}
+
+// The mode in effect, resolving auto
+func mode() string {
+	if gMode != ModeAuto {
+		return gMode
+	}
+
+	// Only draw bars for an interactive user, and not into a log
+	if isTerminal(os.Stdout) && isTerminal(os.Stderr) {
+		return ModeBar
+	}
+
+	return ModeLog
+}
+
+func isTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
+
+// Bar tracks the items and bytes transferred by an operation
+type Bar struct {
+	mu sync.Mutex
+
+	label      string
+	unit       string
+	totalItems int
+	totalBytes int64
+
+	items    int
+	done     int64 // bytes of completed items
+	inflight int64 // bytes of the current item
+	start    time.Time
+
+	mode    string
+	stop    chan struct{}
+	stopped sync.WaitGroup
+
+	// The log output replaced while a bar is drawn
+	logOut io.Writer
+}
+
+// New starts reporting the progress of transferring items of unit (eg.
+// "blobs") totalling bytes, which is 0 if not known.  Finish must be called
+// when the transfer ends.
+func New(label, unit string, items int, bytes int64) *Bar {
+	b := &Bar{
+		label:      label,
+		unit:       unit,
+		totalItems: items,
+		totalBytes: bytes,
+		start:      time.Now(),
+		mode:       mode(),
+		stop:       make(chan struct{}),
+	}
+
+	interval := logInterval
+	switch b.mode {
+	case ModeNone:
+		return b
+	case ModeBar:
+		interval = barInterval
+
+		// Log entries are written above the bar
+		if out := logrus.StandardLogger().Out; out == os.Stderr {
+			b.logOut = out
+			logrus.SetOutput(&logWriter{b})
+		}
+		b.draw()
+	}
+
+	b.stopped.Add(1)
+	go b.run(interval)
+
+	return b
+}
+
+// Add records n bytes of the current item transferred
+func (b *Bar) Add(n int64) {
+	if b == nil {
+		return
+	}
+
+	b.mu.Lock()
+	b.inflight += n
+	b.mu.Unlock()
+}
+
+// Done records an item completed or skipped, whose size is bytes
+func (b *Bar) Done(bytes int64) {
+	if b == nil {
+		return
+	}
+
+	b.mu.Lock()
+	b.items++
+	b.done += bytes
+	b.inflight = 0
+	b.mu.Unlock()
+}
+
+// Finish stops reporting progress, leaving the final state of a bar on
+// the terminal
+func (b *Bar) Finish() {
+	if b == nil || b.mode == ModeNone {
+		return
+	}
+
+	close(b.stop)
+	b.stopped.Wait()
+
+	if b.mode == ModeBar {
+		b.mu.Lock()
+		fmt.Fprintf(gOut, "\r\033[K%s\n", b.line())
+		b.mu.Unlock()
+
+		if b.logOut != nil {
+			logrus.SetOutput(b.logOut)
+		}
+	}
+}
+
+func (b *Bar) run(interval time.Duration) {
+	defer b.stopped.Done()
+
+	t := time.NewTicker(interval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-b.stop:
+			return
+		case <-t.C:
+			if b.mode == ModeBar {
+				b.draw()
+			} else {
+				b.mu.Lock()
+				line := b.summary()
+				b.mu.Unlock()
+				logging.Logger().Info(line)
+			}
+		}
+	}
+}
+
+func (b *Bar) draw() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	fmt.Fprintf(gOut, "\r\033[K%s", b.line())
+}
+
+// The bar as drawn on a terminal, eg.
+// Media blobs [=========>              ] 12/40 blobs  3.2 MiB/10.1 MiB  1.1 MiB/s  ETA 0:06
+func (b *Bar) line() string {
+	frac := b.fraction()
+	filled := int(frac * barWidth)
+
+	bar := strings.Repeat("=", filled)
+	if filled < barWidth {
+		bar += ">" + strings.Repeat(" ", barWidth-filled-1)
+	}
+
+	return fmt.Sprintf("%s [%s] %s", b.label, bar, b.counts("  "))
+}
+
+// The progress as logged, eg.
+// Media blobs: 12/40 blobs, 3.2 MiB/10.1 MiB, 1.1 MiB/s, ETA 0:06
+func (b *Bar) summary() string {
+	return fmt.Sprintf("%s: %s", b.label, b.counts(", "))
+}
+
+func (b *Bar) counts(sep string) string {
+	parts := []string{fmt.Sprintf("%d/%d %s", b.items, b.totalItems, b.unit)}
+
+	bytes := b.done + b.inflight
+	elapsed := time.Since(b.start)
+	if b.totalBytes > 0 {
+		parts = append(parts, fmt.Sprintf("%s/%s", formatBytes(bytes), formatBytes(b.totalBytes)))
+		if elapsed > 0 {
+			parts = append(parts, formatBytes(int64(math.Round(float64(bytes)/elapsed.Seconds())))+"/s")
+		}
+	}
+
+	if eta, ok := b.eta(elapsed); ok {
+		parts = append(parts, "ETA "+formatDuration(eta))
+	}
+
+	return strings.Join(parts, sep)
+}
+
+// How far through the transfer is, by bytes if known otherwise by items
+func (b *Bar) fraction() float64 {
+	var f float64
+	switch {
+	case b.totalBytes > 0:
+		f = float64(b.done+b.inflight) / float64(b.totalBytes)
+	case b.totalItems > 0:
+		f = float64(b.items) / float64(b.totalItems)
+	}
+
+	if f > 1 {
+		return 1
+	}
+	return f
+}
+
+// The time left at the rate so far
+func (b *Bar) eta(elapsed time.Duration) (time.Duration, bool) {
+	frac := b.fraction()
+	if frac <= 0 || elapsed < time.Second {
+		return 0, false
+	}
+
+	return time.Duration(float64(elapsed) * (1 - frac) / frac), true
+}
+
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+func formatDuration(d time.Duration) string {
+	d = d.Round(time.Second)
+	h, m, s := int(d.Hours()), int(d.Minutes())%60, int(d.Seconds())%60
+
+	if h > 0 {
+		return fmt.Sprintf("%d:%02d:%02d", h, m, s)
+	}
+	return fmt.Sprintf("%d:%02d", m, s)
+}
+
+// Writes log entries above the bar, then redraws it
+type logWriter struct {
+	b *Bar
+}
+
+func (w *logWriter) Write(p []byte) (int, error) {
+	w.b.mu.Lock()
+	defer w.b.mu.Unlock()
+
+	fmt.Fprint(gOut, "\r\033[K")
+	n, err := w.b.logOut.Write(p)
+	fmt.Fprint(gOut, w.b.line())
+
+	return n, err
+}
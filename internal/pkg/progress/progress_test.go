@@ -0,0 +1,78 @@
+package progress
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBar(t *testing.T) {
+	var out bytes.Buffer
+	gOut = &out
+	defer func() { gOut = os.Stderr }()
+
+	if err := Configure("sometimes"); err == nil {
+		t.Errorf("Bad mode accepted")
+	}
+	if err := Configure(ModeBar); err != nil {
+		t.Fatal(err)
+	}
+	defer Configure(ModeAuto)
+
+	b := New("Media blobs", "blobs", 4, 4096)
+	b.Done(1024)
+	b.Add(512)
+
+	// Pretend the first 1.5KiB took 3 seconds
+	b.mu.Lock()
+	b.start = time.Now().Add(-3 * time.Second)
+	line := b.line()
+	summary := b.summary()
+	b.mu.Unlock()
+
+	want := "Media blobs [=========>              ] 1/4 blobs  1.5 KiB/4.0 KiB  512 B/s  ETA 0:05"
+	if line != want {
+		t.Errorf("Got  %q\nwant %q", line, want)
+	}
+	if want := "Media blobs: 1/4 blobs, 1.5 KiB/4.0 KiB, 512 B/s, ETA 0:05"; summary != want {
+		t.Errorf("Got  %q\nwant %q", summary, want)
+	}
+
+	for n := 0; n < 3; n++ {
+		b.Done(1024)
+	}
+	b.Finish()
+
+	final := out.String()[strings.LastIndex(out.String(), "\r"):]
+	if !strings.Contains(final, "[========================] 4/4 blobs  4.0 KiB/4.0 KiB") || !strings.HasSuffix(final, "\n") {
+		t.Errorf("Final bar drawn as %q", final)
+	}
+}
+
+func TestBarItemsOnly(t *testing.T) {
+	b := &Bar{label: "Content items", unit: "items", totalItems: 10, items: 5, start: time.Now().Add(-10 * time.Second)}
+
+	if got, want := b.summary(), "Content items: 5/10 items, ETA 0:10"; got != want {
+		t.Errorf("Got %q, want %q", got, want)
+	}
+
+	// Nothing is reported without a bar, eg. before the first item
+	var nilBar *Bar
+	nilBar.Add(1)
+	nilBar.Done(1)
+	nilBar.Finish()
+}
+
+func TestFormat(t *testing.T) {
+	for n, want := range map[int64]string{0: "0 B", 1023: "1023 B", 1536: "1.5 KiB", 5 << 30: "5.0 GiB"} {
+		if got := formatBytes(n); got != want {
+			t.Errorf("formatBytes(%d) = %q, want %q", n, got, want)
+		}
+	}
+
+	if got := formatDuration(3*time.Hour + 25*time.Second); got != "3:00:25" {
+		t.Errorf("formatDuration = %q", got)
+	}
+}
@@ -0,0 +1,83 @@
+package secrets
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/Azure/go-autorest/autorest"
+)
+
+// Key Vault data plane API version
+const keyVaultAPIVersion = "7.1"
+
+// AuthorizerFunc returns an authorizer for requests to resource
+type AuthorizerFunc func(resource string) (autorest.Authorizer, error)
+
+// KeyVault returns a fetcher for secrets held in Azure Key Vault.  Vaults
+// named without a domain are looked up under dnsSuffix, and tokens for
+// resource are obtained from authorizer the first time a secret is fetched.
+func KeyVault(dnsSuffix, resource string, authorizer AuthorizerFunc) KeyVaultFetcher {
+	return keyVaultFetcher(http.DefaultClient, "https", dnsSuffix, resource, authorizer)
+}
+
+func keyVaultFetcher(client *http.Client, scheme, dnsSuffix, resource string, authorizer AuthorizerFunc) KeyVaultFetcher {
+	var authz autorest.Authorizer
+
+	return func(vault, name, version string) (string, error) {
+		if authz == nil {
+			var err error
+			authz, err = authorizer(resource)
+			if err != nil {
+				return "", fmt.Errorf("authenticating to Key Vault: %s", err)
+			}
+		}
+
+		host := vault
+		if !strings.Contains(host, ".") && !strings.Contains(host, ":") {
+			host = vault + "." + dnsSuffix
+		}
+
+		u := url.URL{
+			Scheme:   scheme,
+			Host:     host,
+			Path:     "/secrets/" + name,
+			RawQuery: "api-version=" + keyVaultAPIVersion,
+		}
+		if version != "" {
+			u.Path += "/" + version
+		}
+
+		req, err := http.NewRequest("GET", u.String(), nil)
+		if err != nil {
+			return "", err
+		}
+
+		req, err = autorest.Prepare(req, authz.WithAuthorization())
+		if err != nil {
+			return "", err
+		}
+
+		resp, err := client.Do(req)
+		if err != nil {
+			return "", err
+		}
+		defer resp.Body.Close()
+
+		// Only accept HTTP 2xx codes
+		if resp.StatusCode >= 300 {
+			return "", fmt.Errorf("fetching secret %s from vault %s: status %s received", name, vault, resp.Status)
+		}
+
+		secret := struct {
+			Value string `json:"value"`
+		}{}
+		if err := json.NewDecoder(resp.Body).Decode(&secret); err != nil {
+			return "", fmt.Errorf("decoding secret %s from vault %s: %s", name, vault, err)
+		}
+
+		return secret.Value, nil
+	}
+}
@@ -0,0 +1,178 @@
+package secrets
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+/*
+ *  Resolves secret references in the configuration, so that secrets need
+ *  not be stored in the config file or passed on the command line:
+ *
+ *    env://VAR                          environment variable VAR
+ *    file:///path                       contents of a file
+ *    keyring://service/account          OS keyring entry
+ *    keyvault://vault/secret[/version]  Azure Key Vault secret
+ */
+
+// KeyVaultFetcher fetches a secret from an Azure Key Vault
+type KeyVaultFetcher func(vault, name, version string) (string, error)
+
+// Resolver resolves secret references
+type Resolver struct {
+	// Used for keyvault:// references
+	KeyVault KeyVaultFetcher
+}
+
+// Reference schemes
+var schemes = []string{"env://", "file://", "keyring://", "keyvault://"}
+
+// IsReference reports whether value is a secret reference rather than a
+// literal secret
+func IsReference(value string) bool {
+	for _, s := range schemes {
+		if strings.HasPrefix(value, s) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Resolve returns the secret that value refers to, or value itself if it is
+// not a reference
+func (r *Resolver) Resolve(value string) (string, error) {
+	if !IsReference(value) {
+		return value, nil
+	}
+
+	u, err := url.Parse(value)
+	if err != nil {
+		return "", fmt.Errorf("bad secret reference: %s", err)
+	}
+
+	switch u.Scheme {
+	case "env":
+		return resolveEnv(u.Host)
+	case "file":
+		return resolveFile(u.Path)
+	case "keyring":
+		return resolveKeyring(u.Host, strings.TrimPrefix(u.Path, "/"))
+	case "keyvault":
+		return r.resolveKeyVault(u.Host, strings.TrimPrefix(u.Path, "/"))
+	}
+
+	return "", fmt.Errorf("unsupported secret reference %s://", u.Scheme)
+}
+
+// ResolveConfig replaces any references in the values of keys with the
+// secrets they refer to
+func (r *Resolver) ResolveConfig(v *viper.Viper, keys []string) error {
+	for _, key := range keys {
+		value := v.GetString(key)
+		if !IsReference(value) {
+			continue
+		}
+
+		secret, err := r.Resolve(value)
+		if err != nil {
+			return fmt.Errorf("resolving %s: %s", key, err)
+		}
+
+		v.Set(key, secret)
+	}
+
+	return nil
+}
+
+func resolveEnv(name string) (string, error) {
+	if name == "" {
+		return "", fmt.Errorf("env:// reference has no variable name")
+	}
+
+	value, ok := os.LookupEnv(name)
+	if !ok {
+		return "", fmt.Errorf("environment variable %s is not set", name)
+	}
+
+	return value, nil
+}
+
+func resolveFile(path string) (string, error) {
+	if path == "" {
+		return "", fmt.Errorf("file:// reference has no path")
+	}
+
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+
+	// Editors usually leave a trailing newline
+	return strings.TrimRight(string(b), "\r\n"), nil
+}
+
+// Command used to look up keyring entries, replaced in tests
+var keyringCommand = func(service, account string) (*exec.Cmd, error) {
+	switch runtime.GOOS {
+	case "linux", "freebsd", "openbsd":
+		// libsecret, as used by GNOME Keyring and KWallet
+		return exec.Command("secret-tool", "lookup", "service", service, "account", account), nil
+	case "darwin":
+		return exec.Command("security", "find-generic-password", "-s", service, "-a", account, "-w"), nil
+	}
+
+	return nil, fmt.Errorf("the OS keyring is not supported on %s", runtime.GOOS)
+}
+
+func resolveKeyring(service, account string) (string, error) {
+	if service == "" || account == "" {
+		return "", fmt.Errorf("keyring:// reference must be of the form keyring://service/account")
+	}
+
+	cmd, err := keyringCommand(service, account)
+	if err != nil {
+		return "", err
+	}
+
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("looking up keyring entry %s/%s: %s %s", service, account, err, strings.TrimSpace(stderr.String()))
+	}
+
+	secret := strings.TrimRight(string(out), "\r\n")
+	if secret == "" {
+		return "", fmt.Errorf("keyring entry %s/%s not found", service, account)
+	}
+
+	return secret, nil
+}
+
+func (r *Resolver) resolveKeyVault(vault, path string) (string, error) {
+	parts := strings.Split(path, "/")
+	if vault == "" || parts[0] == "" || len(parts) > 2 {
+		return "", fmt.Errorf("keyvault:// reference must be of the form keyvault://vault/secret[/version]")
+	}
+
+	if r.KeyVault == nil {
+		return "", fmt.Errorf("key vault references are not supported here")
+	}
+
+	var version string
+	if len(parts) == 2 {
+		version = parts[1]
+	}
+
+	return r.KeyVault(vault, parts[0], version)
+}
@@ -0,0 +1,149 @@
+package secrets
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/Azure/go-autorest/autorest"
+	"github.com/spf13/viper"
+)
+
+func TestResolve(t *testing.T) {
+	dir, err := ioutil.TempDir("", "apim-tools-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "secret")
+	if err := ioutil.WriteFile(path, []byte("from-file\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	os.Setenv("APIM_TOOLS_TEST_SECRET", "from-env")
+	defer os.Unsetenv("APIM_TOOLS_TEST_SECRET")
+
+	defer func(orig func(string, string) (*exec.Cmd, error)) { keyringCommand = orig }(keyringCommand)
+	keyringCommand = func(service, account string) (*exec.Cmd, error) {
+		if service != "apim-tools" || account != "client-secret" {
+			return exec.Command("false"), nil
+		}
+		return exec.Command("echo", "from-keyring"), nil
+	}
+
+	r := &Resolver{
+		KeyVault: func(vault, name, version string) (string, error) {
+			return "from-" + vault + "-" + name + "-" + version, nil
+		},
+	}
+
+	tests := []struct {
+		value     string
+		want      string
+		expectErr bool
+	}{
+		{"plain-secret", "plain-secret", false},
+		{"env://APIM_TOOLS_TEST_SECRET", "from-env", false},
+		{"env://APIM_TOOLS_TEST_UNSET", "", true},
+		{"file://" + path, "from-file", false},
+		{"file://" + path + ".missing", "", true},
+		{"keyring://apim-tools/client-secret", "from-keyring", false},
+		{"keyring://apim-tools/other", "", true},
+		{"keyring://apim-tools", "", true},
+		{"keyvault://myvault/sp-secret", "from-myvault-sp-secret-", false},
+		{"keyvault://myvault/sp-secret/0123abcd", "from-myvault-sp-secret-0123abcd", false},
+		{"keyvault://myvault", "", true},
+		{"keyvault://myvault/a/b/c", "", true},
+	}
+
+	for _, tt := range tests {
+		got, err := r.Resolve(tt.value)
+		if tt.expectErr {
+			if err == nil {
+				t.Errorf("Expected error resolving %q", tt.value)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("Resolving %q: %s", tt.value, err)
+		} else if got != tt.want {
+			t.Errorf("Resolving %q: got %q, wanted %q", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestResolveConfig(t *testing.T) {
+	os.Setenv("APIM_TOOLS_TEST_SECRET", "from-env")
+	defer os.Unsetenv("APIM_TOOLS_TEST_SECRET")
+
+	v := viper.New()
+	v.Set("auth.client-secret", "env://APIM_TOOLS_TEST_SECRET")
+	v.Set("auth.cert-password", "literal")
+
+	r := &Resolver{}
+	if err := r.ResolveConfig(v, []string{"auth.client-secret", "auth.cert-password", "auth.unset"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := v.GetString("auth.client-secret"); got != "from-env" {
+		t.Errorf("Got client-secret %q", got)
+	}
+	if got := v.GetString("auth.cert-password"); got != "literal" {
+		t.Errorf("Got cert-password %q", got)
+	}
+
+	// Key vault references need a fetcher
+	v.Set("auth.client-secret", "keyvault://myvault/sp-secret")
+	if err := r.ResolveConfig(v, []string{"auth.client-secret"}); err == nil {
+		t.Errorf("Expected error resolving a key vault reference without a fetcher")
+	}
+}
+
+func TestKeyVault(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("api-version") != keyVaultAPIVersion {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		switch r.URL.Path {
+		case "/secrets/sp-secret":
+			_, _ = w.Write([]byte(`{"value": "latest", "id": "x"}`))
+		case "/secrets/sp-secret/0123abcd":
+			_, _ = w.Write([]byte(`{"value": "versioned", "id": "x"}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	u, _ := url.Parse(srv.URL)
+
+	authorized := 0
+	fetch := keyVaultFetcher(srv.Client(), "http", "vault.azure.net", "https://vault.azure.net",
+		func(resource string) (autorest.Authorizer, error) {
+			authorized++
+			return autorest.NullAuthorizer{}, nil
+		})
+
+	if got, err := fetch(u.Host, "sp-secret", ""); err != nil || got != "latest" {
+		t.Errorf("Got %q, %v", got, err)
+	}
+	if got, err := fetch(u.Host, "sp-secret", "0123abcd"); err != nil || got != "versioned" {
+		t.Errorf("Got %q, %v", got, err)
+	}
+	if _, err := fetch(u.Host, "missing", ""); err == nil {
+		t.Errorf("Expected error fetching a missing secret")
+	}
+
+	if authorized != 1 {
+		t.Errorf("Authorizer requested %d times, wanted 1", authorized)
+	}
+}
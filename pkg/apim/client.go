@@ -0,0 +1,364 @@
+// Package apim manages the developer portal of an Azure API Manager
+// instance: downloading its content to an archive, uploading an archive to
+// it, and resetting, publishing and querying the portal.
+//
+// A Client is configured explicitly, so the operations can be embedded in
+// other programs as well as run by the apim-tools commands.
+package apim
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-storage-blob-go/azblob"
+	"github.com/Azure/go-autorest/autorest"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// DefaultAPIVersion is the management API version used unless another
+	// is configured
+	DefaultAPIVersion = "2019-12-01"
+
+	// DefaultResourceManagerURL is the Resource Manager endpoint of the
+	// public Azure cloud
+	DefaultResourceManagerURL = "https://management.azure.com"
+
+	// DefaultTokenValidity is how long SAS tokens are valid for by default
+	DefaultTokenValidity = 30 * time.Minute
+)
+
+// Config identifies an instance and how to reach it
+type Config struct {
+	// Resource ID of the instance, eg.
+	// /subscriptions/<sub>/resourceGroups/<rg>/providers/Microsoft.ApiManagement/service/<name>
+	InstanceID string
+
+	// Resource Manager endpoint, DefaultResourceManagerURL if empty
+	ResourceManagerURL string
+
+	// Authorizes Resource Manager requests, eg. with a bearer token from
+	// github.com/Azure/go-autorest/autorest/azure/auth
+	Authorizer autorest.Authorizer
+
+	// Management API version, DefaultAPIVersion if empty
+	APIVersion string
+
+	// Client for all requests, http.DefaultClient if nil
+	HTTPClient *http.Client
+
+	// Storage of a self-hosted portal, used in place of the managed portal's
+	SelfHosted *SelfHosted
+
+	// Reports the progress of transfers, if set
+	Progress NewProgressFunc
+
+	// Receives the log entries of the client, which are discarded if nil
+	Logger logrus.FieldLogger
+}
+
+// SelfHosted describes a self-hosted developer portal, whose media and
+// static website live in a storage account of its own
+type SelfHosted struct {
+	// Blob container holding the portal media
+	Media *azblob.ContainerURL
+
+	// Static website container that the portal is published to, needed
+	// only to publish
+	Website *azblob.ContainerURL
+
+	// URL of the portal, else the managed portal URL is used
+	PortalURL string
+}
+
+// Endpoints are the URLs of an instance's portal, management API and media
+type Endpoints struct {
+	InstanceURL    string
+	PortalURL      string
+	ManagementURL  string
+	BlobStorageURL string
+}
+
+// Client performs developer portal operations on an instance
+type Client struct {
+	rm   *ResourceManagerClient
+	apim *apimClient
+	http *http.Client
+
+	endpoints Endpoints
+	progress  NewProgressFunc
+	log       logrus.FieldLogger
+
+	// Blob container holding the portal media
+	mediaContainer *azblob.ContainerURL
+
+	// Self-hosted portal static website container (self-hosted mode only)
+	websiteContainer *azblob.ContainerURL
+	selfHosted       bool
+
+	// Whether the self-hosted portal URL was given.  Without it the portal
+	// URL is that of the managed portal, which must not be queried.
+	selfHostedURL bool
+}
+
+// New looks up the instance's portal and management API, and obtains the
+// credentials needed to manage the portal content and media.  The SAS token
+// for the management API is renewed as needed for as long as the client is
+// used, with requests made under ctx, so ctx must outlive the client.
+func New(ctx context.Context, cfg Config) (*Client, error) {
+	if cfg.InstanceID == "" {
+		return nil, fmt.Errorf("no instance ID given")
+	}
+	if cfg.Authorizer == nil {
+		return nil, fmt.Errorf("no authorizer given")
+	}
+
+	armURL := cfg.ResourceManagerURL
+	if armURL == "" {
+		armURL = DefaultResourceManagerURL
+	}
+
+	apiVersion := cfg.APIVersion
+	if apiVersion == "" {
+		apiVersion = DefaultAPIVersion
+	}
+
+	httpClient := cfg.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+
+	log := cfg.Logger
+	if log == nil {
+		log = nullLogger
+	}
+
+	c := &Client{
+		rm:       NewResourceManagerClient(cfg.Authorizer, apiVersion, httpClient).WithLogger(log),
+		http:     httpClient,
+		progress: cfg.Progress,
+		log:      log,
+	}
+	c.endpoints.InstanceURL = strings.TrimSuffix(armURL, "/") + strings.TrimSuffix(cfg.InstanceID, "/")
+
+	// Grab the dev portal and management URLs
+	c.log.Infof("Querying instance")
+	var err error
+	c.endpoints.PortalURL, c.endpoints.ManagementURL, err = getInstanceURLs(ctx, c.rm, c.endpoints.InstanceURL)
+	if err != nil {
+		return nil, err
+	}
+	c.log.Debugf("Dev portal URL: %s, Management API URL: %s", c.endpoints.PortalURL, c.endpoints.ManagementURL)
+
+	// APIM client that decorates the request with API version and an
+	// Administrator SAS token, renewing the token before it expires
+	tokens, err := newSasTokenSource(func() (string, time.Time, error) {
+		opts := DefaultTokenOptions()
+		token, err := SasToken(ctx, c.rm, c.endpoints.InstanceURL, opts)
+		return token, opts.Expiry, err
+	}, log)
+	if err != nil {
+		return nil, err
+	}
+
+	c.apim = newApimClient(tokens, apiVersion, httpClient, log)
+
+	// Self-hosted portals keep their media in a storage account of their own
+	if cfg.SelfHosted != nil {
+		return c, c.configureSelfHosted(cfg.SelfHosted)
+	}
+
+	// Get the BLOB storage URL
+	c.endpoints.BlobStorageURL, err = getBlobStorageURL(ctx, c.apim, c.endpoints.ManagementURL)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mediaContainer, err = ContainerURLFromSasURL(c.endpoints.BlobStorageURL, cfg.HTTPClient)
+	if err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// Use the self-hosted portal storage and URL in place of the managed portal's
+func (c *Client) configureSelfHosted(s *SelfHosted) error {
+	if s.Media == nil {
+		return fmt.Errorf("self-hosted portal has no media container")
+	}
+
+	c.selfHosted = true
+	c.mediaContainer = s.Media
+	c.websiteContainer = s.Website
+
+	// Don't expose the credential in the storage URL
+	u := s.Media.URL()
+	u.RawQuery = ""
+	c.endpoints.BlobStorageURL = u.String()
+
+	if s.PortalURL != "" {
+		c.endpoints.PortalURL = strings.TrimSuffix(s.PortalURL, "/")
+		c.selfHostedURL = true
+	}
+
+	c.log.Infof("Using self-hosted developer portal %s", c.endpoints.PortalURL)
+
+	return nil
+}
+
+// Endpoints returns the URLs of the instance.  The blob storage URL of a
+// managed portal includes its SAS.
+func (c *Client) Endpoints() Endpoints {
+	return c.endpoints
+}
+
+// SelfHosted reports whether the client acts on a self-hosted portal
+func (c *Client) SelfHosted() bool {
+	return c.selfHosted
+}
+
+// MediaContainer returns the blob container holding the portal media
+func (c *Client) MediaContainer() *azblob.ContainerURL {
+	return c.mediaContainer
+}
+
+// Get the dev portal and management API URLs for the instance
+func getInstanceURLs(ctx context.Context, cli *ResourceManagerClient, instanceURL string) (string, string, error) {
+	// Fetch APIM instance details
+	resp, err := cli.Get(ctx, instanceURL)
+	if err != nil {
+		return "", "", err
+	}
+
+	defer resp.Body.Close()
+
+	// Only accept HTTP 2xx codes
+	if resp.StatusCode >= 300 {
+		return "", "", statusError(resp)
+	}
+
+	// Grab the body
+	respBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", "", err
+	}
+
+	apim := InstanceDetails{}
+	if err := json.Unmarshal(respBody, &apim); err != nil {
+		return "", "", err
+	}
+	cli.log.Debugf("APIM: %+v", apim)
+
+	dpURL := apim.Properties.PortalURL
+	mgmtURL := apim.Properties.MgmtURL
+
+	// Use override in hostname config if there is one
+	for _, entry := range apim.Properties.HostnameConfigurations {
+		switch entry.Type {
+		case "DeveloperPortal":
+			dpURL = "https://" + entry.Hostname
+		case "Management":
+			mgmtURL = "https://" + entry.Hostname
+		}
+	}
+
+	return dpURL, mgmtURL, nil
+}
+
+// TokenOptions are the options of a Shared Access token request
+type TokenOptions struct {
+	// APIM user ID, 1 is Administrator
+	UserID string
+
+	// primary or secondary
+	KeyType string
+
+	// When the token expires
+	Expiry time.Time
+}
+
+// DefaultTokenOptions returns the options for an Administrator token using
+// the primary key, valid for DefaultTokenValidity
+func DefaultTokenOptions() TokenOptions {
+	return TokenOptions{
+		UserID:  "1",
+		KeyType: "primary",
+		Expiry:  time.Now().Add(DefaultTokenValidity),
+	}
+}
+
+// SasToken gets a Shared Access token for use with the management API of
+// the instance at instanceURL (the Resource Manager endpoint followed by
+// the instance ID)
+func SasToken(ctx context.Context, cli *ResourceManagerClient, instanceURL string, opts TokenOptions) (string, error) {
+	tr := apimTokenRequest{
+		Propties: apimTokenRequestProperties{
+			KeyType: opts.KeyType,
+			Expiry:  opts.Expiry.UTC().Format(time.RFC3339Nano),
+		},
+	}
+
+	sasReqURL := fmt.Sprintf("%s/users/%s/token", instanceURL, url.PathEscape(opts.UserID))
+	resp, err := cli.Post(ctx, sasReqURL, tr)
+	if err != nil {
+		return "", err
+	}
+
+	defer resp.Body.Close()
+
+	// Only accept HTTP 2xx codes
+	if resp.StatusCode >= 300 {
+		return "", statusError(resp)
+	}
+
+	// Grab the body
+	respBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+
+	tokenResp := apimTokenRequestResponse{}
+	if err := json.Unmarshal(respBody, &tokenResp); err != nil {
+		return "", err
+	}
+
+	cli.log.Debugf("APIM SAS token for user %s obtained, expires %s", opts.UserID, opts.Expiry.Format(time.RFC3339))
+	return tokenResp.Value, nil
+}
+
+// Get the BLOB storage URL for the instance
+func getBlobStorageURL(ctx context.Context, cli *apimClient, mgmtURL string) (string, error) {
+	reqURL := fmt.Sprintf("%s/portalSettings/mediaContent/listSecrets", apimMgmtURL(mgmtURL))
+	resp, err := cli.Post(ctx, reqURL, nil)
+	if err != nil {
+		return "", err
+	}
+
+	defer resp.Body.Close()
+
+	// Only accept HTTP 2xx codes
+	if resp.StatusCode >= 300 {
+		return "", statusError(resp)
+	}
+
+	// Grab the body
+	respBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+
+	secretsResp := apimListSecretsResponse{}
+	if err := json.Unmarshal(respBody, &secretsResp); err != nil {
+		return "", err
+	}
+
+	cli.log.Debugf("Blob store SAS URL: %s", secretsResp.URL)
+	return secretsResp.URL, nil
+}
@@ -0,0 +1,154 @@
+package apim
+
+import (
+	"bytes"
+	"compress/flate"
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/Azure/go-autorest/autorest"
+	"github.com/sirupsen/logrus"
+
+	"github.com/jake-scott/apim-tools/internal/pkg/apimfake"
+	"github.com/jake-scott/apim-tools/pkg/devportal"
+)
+
+// Start a fake instance and connect to it
+func fakeClient(t *testing.T) (*apimfake.Server, *Client) {
+	f := apimfake.NewServer()
+	t.Cleanup(f.Close)
+
+	c, err := New(context.Background(), Config{
+		InstanceID:         apimfake.InstanceID,
+		ResourceManagerURL: f.ResourceManagerURL(),
+		Authorizer:         autorest.NullAuthorizer{},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return f, c
+}
+
+func TestClientDownloadUpload(t *testing.T) {
+	ctx := context.Background()
+
+	src, c := fakeClient(t)
+	if err := src.PutContentItem("/contentTypes/page/contentItems/home", map[string]interface{}{
+		"properties": map[string]interface{}{"title": "Home", "permalink": "/"},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	src.PutBlob("logo.png", []byte("logo"))
+
+	var buf bytes.Buffer
+	res, err := c.Download(ctx, &buf, devportal.WriterOptions{Compression: flate.BestSpeed})
+	if err != nil {
+		t.Fatalf("Downloading: %s", err)
+	}
+	if res.ContentItems != 1 || res.Blobs.OK != 1 || res.CodeVersion == "" {
+		t.Errorf("Got download result %+v", res)
+	}
+
+	ar, err := devportal.NewArchiveReaderAt(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ar.Close()
+
+	// The target portal is older, so the upload is refused unless allowed
+	dst, c := fakeClient(t)
+	dst.SetCodeVersion("20200101000000")
+	dst.PutBlob("old.png", []byte("old"))
+
+	if _, err := c.Upload(ctx, &ar, UploadOptions{}); !errors.Is(err, ErrVersionMismatch) {
+		t.Fatalf("Expected a version mismatch, got %v", err)
+	}
+
+	up, err := c.Upload(ctx, &ar, UploadOptions{AllowVersionMismatch: true})
+	if err != nil {
+		t.Fatalf("Uploading: %s", err)
+	}
+	if up.ContentItems.OK != 1 || up.Blobs.OK != 1 || up.DeletedBlobs.OK != 1 {
+		t.Errorf("Got upload result %+v", up)
+	}
+
+	if got, want := dst.ContentItems(), src.ContentItems(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Got content items %v, wanted %v", got, want)
+	}
+	if got, want := dst.Blobs(), src.Blobs(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Got blobs %v, wanted %v", got, want)
+	}
+}
+
+func TestClientStatusReset(t *testing.T) {
+	ctx := context.Background()
+
+	f, c := fakeClient(t)
+	f.SetCodeVersion("20210101000000")
+	f.PutBlob("logo.png", []byte("logo"))
+	if err := f.PutContentItem("/contentTypes/page/contentItems/home", map[string]interface{}{}); err != nil {
+		t.Fatal(err)
+	}
+
+	status, err := c.Status(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !status.Deployed || status.CodeVersion != "20210101000000" {
+		t.Errorf("Got status %+v", status)
+	}
+
+	res, err := c.Reset(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.DeletedContentItems.OK != 1 || res.DeletedBlobs.OK != 1 {
+		t.Errorf("Got reset result %+v", res)
+	}
+	if len(f.ContentItems()) != 0 || len(f.Blobs()) != 0 {
+		t.Errorf("Portal not empty after reset")
+	}
+}
+
+// The client logs to the logger it is given, leaving the standard logger alone
+func TestClientLogger(t *testing.T) {
+	f := apimfake.NewServer()
+	defer f.Close()
+
+	var buf bytes.Buffer
+	log := logrus.New()
+	log.Out = &buf
+	log.Level = logrus.DebugLevel
+
+	var std bytes.Buffer
+	out := logrus.StandardLogger().Out
+	logrus.SetOutput(&std)
+	defer logrus.SetOutput(out)
+
+	c, err := New(context.Background(), Config{
+		InstanceID:         apimfake.InstanceID,
+		ResourceManagerURL: f.ResourceManagerURL(),
+		Authorizer:         autorest.NullAuthorizer{},
+		Logger:             log,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := c.Download(context.Background(), &bytes.Buffer{}, devportal.WriterOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, want := range []string{"Querying instance", "[AZ MgmtAPI] GET", "[APIM MgmtApi] POST", "Processing content items", "to ZIP"} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("Log does not contain %q", want)
+		}
+	}
+	if std.Len() != 0 {
+		t.Errorf("Logged to the standard logger: %s", std.String())
+	}
+}
@@ -0,0 +1,237 @@
+package apim
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"reflect"
+	"strings"
+)
+
+// ContentItems returns all of the portal content items, each with its ID
+// (eg. /contentTypes/page/contentItems/home) in the id field
+func (c *Client) ContentItems(ctx context.Context) ([]map[string]interface{}, error) {
+	// Get content types used by the portal
+	contentTypes, err := getContentTypes(ctx, c.apim, c.endpoints.ManagementURL)
+	if err != nil {
+		return nil, err
+	}
+
+	// Get content items for each content type
+	var contentItems []map[string]interface{}
+	for _, ct := range contentTypes {
+		subItems, err := getContentItemsAsMap(ctx, c.apim, c.endpoints.ManagementURL, ct)
+		if err != nil {
+			return nil, err
+		}
+
+		contentItems = append(contentItems, subItems...)
+	}
+
+	return contentItems, nil
+}
+
+// Get a list of supported content types from the portal
+func getContentTypes(ctx context.Context, cli *apimClient, mgmtURL string) ([]string, error) {
+	reqURL := fmt.Sprintf("%s/contentTypes", apimMgmtURL(mgmtURL))
+	resp, err := cli.Get(ctx, reqURL)
+	if err != nil {
+		return nil, err
+	}
+
+	defer resp.Body.Close()
+
+	// Only accept HTTP 2xx codes
+	if resp.StatusCode >= 300 {
+		return nil, statusError(resp)
+	}
+
+	// Grab the body
+	respBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	ctResp := apimPortalContentTypesResponse{}
+	if err := json.Unmarshal(respBody, &ctResp); err != nil {
+		return nil, err
+	}
+
+	// Extract the IDs minus the /contentTypes/ prefix
+	types := make([]string, 0, 10)
+	for _, ct := range ctResp.Value {
+		s := strings.TrimPrefix(ct.ID, "/contentTypes/")
+		types = append(types, s)
+	}
+
+	cli.log.Debugf("Content types: %s", types)
+	return types, nil
+}
+
+// Get a list of content items for a given content type
+func getContentItems(ctx context.Context, cli *apimClient, mgmtURL string, contentType string) ([]interface{}, error) {
+	reqURL := fmt.Sprintf("%s/contentTypes/%s/contentItems", apimMgmtURL(mgmtURL), contentType)
+	resp, err := cli.Get(ctx, reqURL)
+	if err != nil {
+		return nil, err
+	}
+
+	defer resp.Body.Close()
+
+	// Only accept HTTP 2xx codes
+	if resp.StatusCode >= 300 {
+		return nil, statusError(resp)
+	}
+
+	// Grab the body
+	respBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	ciResp := apimPortalContentItemsResponse{}
+	if err := json.Unmarshal(respBody, &ciResp); err != nil {
+		return nil, err
+	}
+
+	cli.log.Debugf("%d %s items found", len(ciResp.Value), contentType)
+
+	return ciResp.Value, nil
+}
+
+// Get a list of content items for a given content type
+func getContentItemsAsMap(ctx context.Context, cli *apimClient, mgmtURL string, contentType string) ([]map[string]interface{}, error) {
+	reqURL := fmt.Sprintf("%s/contentTypes/%s/contentItems", apimMgmtURL(mgmtURL), contentType)
+	resp, err := cli.Get(ctx, reqURL)
+	if err != nil {
+		return nil, err
+	}
+
+	defer resp.Body.Close()
+
+	// Only accept HTTP 2xx codes
+	if resp.StatusCode >= 300 {
+		return nil, statusError(resp)
+	}
+
+	// Grab the body
+	respBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	ciResp := apimPortalContentItemsResponseMap{}
+	if err := json.Unmarshal(respBody, &ciResp); err != nil {
+		return nil, err
+	}
+
+	cli.log.Debugf("%d %s items found", len(ciResp.Value), contentType)
+
+	return ciResp.Value, nil
+}
+
+//nolint:interfacer
+func uploadContentItem(ctx context.Context, cli *apimClient, mgmtURL string, id string, item interface{}) error {
+	reqURL := apimMgmtURL(mgmtURL) + id
+
+	var requestBody []byte
+	requestBody, err := json.Marshal(item)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "PUT", reqURL, bytes.NewReader(requestBody))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := cli.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	// Only accept HTTP 2xx codes
+	if resp.StatusCode >= 300 {
+		return statusError(resp)
+	}
+
+	cli.log.Debugf("Uploaded item %s", id)
+	return nil
+}
+
+// Delete the content items with the given IDs, stopping early if ctx is done
+func deleteContentItems(ctx context.Context, cli *apimClient, mgmtURL string, ids []string) (counts ItemCounts, err error) {
+	for n, id := range ids {
+		if ctx.Err() != nil {
+			cli.log.Warnf("Stopped after deleting %d content items, %d errors, %d not deleted", counts.OK, counts.Errors, len(ids)-n)
+			return counts, ctx.Err()
+		}
+
+		reqURL := apimMgmtURL(mgmtURL) + id
+		req, err := http.NewRequestWithContext(ctx, "DELETE", reqURL, nil)
+		if err != nil {
+			return counts, err
+		}
+
+		resp, err := cli.Do(req)
+		if err != nil {
+			counts.Errors++
+			cli.log.Errorf("Deleting %s: %s", id, err)
+			continue
+		}
+		resp.Body.Close()
+
+		// Only accept HTTP 2xx codes
+		if resp.StatusCode >= 300 {
+			counts.Errors++
+			cli.log.Errorf("Deleting %s: %s", id, resp.Status)
+			continue
+		}
+
+		counts.OK++
+	}
+
+	return counts, nil
+}
+
+// Return slice a with all items in b removed
+//
+func sliceSubtract(a, b []interface{}) (out []interface{}) {
+	bm := make(map[interface{}]bool)
+	out = make([]interface{}, 0, len(a))
+
+	for _, v := range b {
+		bm[v] = true
+	}
+
+	for _, v := range a {
+		_, ok := bm[v]
+
+		// If 'a' value is not in 'b' we'll keep it
+		if !ok {
+			out = append(out, v)
+		}
+	}
+
+	return
+}
+
+func toInterfaceSlice(slice interface{}) (out []interface{}) {
+	s := reflect.ValueOf(slice)
+	if s.Kind() != reflect.Slice {
+		panic("InterfaceSlice() given a non-slice type")
+	}
+
+	out = make([]interface{}, s.Len())
+
+	for i := 0; i < s.Len(); i++ {
+		out[i] = s.Index(i).Interface()
+	}
+
+	return
+}
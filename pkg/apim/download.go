@@ -0,0 +1,211 @@
+package apim
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"time"
+
+	"github.com/Azure/azure-storage-blob-go/azblob"
+
+	"github.com/jake-scott/apim-tools/pkg/devportal"
+	"github.com/jake-scott/apim-tools/version"
+)
+
+// DownloadResult summarises a download
+type DownloadResult struct {
+	// Code version of the portal, if it is deployed
+	CodeVersion  string     `json:"code_version"`
+	ContentItems int        `json:"content_items"`
+	Blobs        ItemCounts `json:"blobs"`
+}
+
+// Download writes the portal content items and media to w as a Zip archive,
+// compressed and commented as set in opts.  The archive writer logs to the
+// client's logger unless opts has its own.  If ctx is cancelled the download
+// stops, leaving a readable archive of the items written so far, and
+// ctx.Err() is returned along with the result.
+func (c *Client) Download(ctx context.Context, w io.Writer, opts devportal.WriterOptions) (result DownloadResult, err error) {
+	if opts.Logger == nil {
+		opts.Logger = c.log
+	}
+
+	aw, err := devportal.NewArchiveWriterTo(w, opts)
+	if err != nil {
+		return result, err
+	}
+
+	result, err = c.DownloadArchive(ctx, aw)
+	if cerr := aw.Close(); err == nil {
+		err = cerr
+	}
+
+	return result, err
+}
+
+// DownloadArchive writes the portal content items and media to aw, as
+// Download does.  Media that aw records as written by an earlier run of a
+// resumed download is skipped.  The caller must close aw.
+func (c *Client) DownloadArchive(ctx context.Context, aw *devportal.ArchiveWriter) (result DownloadResult, err error) {
+	// Record the source portal version so upload can check compatibility
+	manifest := c.buildManifest(ctx)
+	if err := aw.AddManifest(manifest); err != nil {
+		return result, err
+	}
+	result.CodeVersion = manifest.CodeVersion
+
+	// run the download
+	result.ContentItems, err = c.downloadContentItems(ctx, aw)
+	if err == nil {
+		result.Blobs, err = c.downloadBlobs(ctx, aw)
+	}
+
+	return result, err
+}
+
+// Build the archive manifest from the portal status.  An undeployed portal
+// has no status, nor does a self-hosted portal, in which case the code
+// versions are left empty
+func (c *Client) buildManifest(ctx context.Context) devportal.Manifest {
+	m := devportal.Manifest{
+		ToolVersion: version.Version,
+		Created:     time.Now().UTC(),
+	}
+
+	// Upload does not check the version of a self-hosted portal either
+	if c.selfHosted {
+		c.log.Infof("Self-hosted developer portal, archive will not record portal version")
+		return m
+	}
+
+	isDeployed, err := c.isDeployed(ctx)
+	if err != nil {
+		c.log.WithError(err).Warnf("Cannot determine portal status, archive will not record portal version")
+		return m
+	}
+
+	if !isDeployed {
+		c.log.Warnf("Developer portal not deployed, archive will not record portal version")
+		return m
+	}
+
+	status, err := c.portalStatus(ctx)
+	if err != nil {
+		c.log.WithError(err).Warnf("Cannot query portal status, archive will not record portal version")
+		return m
+	}
+
+	m.CodeVersion = status.CodeVersion
+	m.Version = status.Version
+
+	return m
+}
+
+func (c *Client) downloadBlobs(ctx context.Context, aw *devportal.ArchiveWriter) (counts ItemCounts, err error) {
+	c.log.Infof("Downloading media...")
+
+	containerURL := c.mediaContainer
+
+	// List the blobs first so progress can be shown against the total
+	var blobs []azblob.BlobItem
+	var totalSize int64
+
+	for marker := (azblob.Marker{}); marker.NotDone(); {
+		listBlobs, err := containerURL.ListBlobsFlatSegment(ctx, marker, azblob.ListBlobsSegmentOptions{})
+		if err != nil {
+			return counts, err
+		}
+
+		marker = listBlobs.NextMarker
+
+		for _, blobInfo := range listBlobs.Segment.BlobItems {
+			c.log.Debugf("Found blob: %s", blobInfo.Name)
+
+			blobs = append(blobs, blobInfo)
+			totalSize += blobSize(blobInfo)
+		}
+	}
+
+	bar := c.newProgress("Media blobs", "blobs", len(blobs), totalSize)
+	defer bar.Finish()
+	aw.WithProgress(bar.Add)
+
+	for n, blobInfo := range blobs {
+		if ctx.Err() != nil {
+			c.log.Warnf("  -> Stopped after %d blobs, %d errors, %d blobs not downloaded", counts.OK, counts.Errors, len(blobs)-n)
+			return counts, ctx.Err()
+		}
+
+		if aw.HasBlob(blobInfo.Name) {
+			c.log.Debugf("Blob %s already downloaded", blobInfo.Name)
+			counts.Skipped++
+			bar.Done(blobSize(blobInfo))
+			continue
+		}
+
+		blobURL := containerURL.NewBlobURL(blobInfo.Name)
+
+		if err := aw.AddBlob(ctx, blobURL); err != nil {
+			c.log.WithError(err).Errorf("Writing BLOB %s", blobInfo.Name)
+			counts.Errors++
+		} else {
+			counts.OK++
+		}
+		bar.Done(blobSize(blobInfo))
+	}
+
+	if counts.Skipped > 0 {
+		c.log.Infof("  -> Total %d blobs, %d errors, %d already downloaded", counts.OK, counts.Errors, counts.Skipped)
+	} else {
+		c.log.Infof("  -> Total %d blobs, %d errors", counts.OK, counts.Errors)
+	}
+
+	return counts, nil
+}
+
+// The size of a blob from a container listing
+func blobSize(b azblob.BlobItem) int64 {
+	if b.Properties.ContentLength == nil {
+		return 0
+	}
+
+	return *b.Properties.ContentLength
+}
+
+func (c *Client) downloadContentItems(ctx context.Context, aw *devportal.ArchiveWriter) (int, error) {
+	c.log.Infof("Processing content items...")
+
+	mgmtURL := c.endpoints.ManagementURL
+
+	// Get content types used by the portal
+	contentTypes, err := getContentTypes(ctx, c.apim, mgmtURL)
+	if err != nil {
+		return 0, err
+	}
+
+	// Get content items for each content type
+	var contentItems = make([]interface{}, 0, 200)
+	for _, ct := range contentTypes {
+		subItems, err := getContentItems(ctx, c.apim, mgmtURL, ct)
+		if err != nil {
+			return 0, err
+		}
+
+		contentItems = append(contentItems, subItems...)
+		c.log.Infof("  -> %d %s items", len(subItems), ct)
+	}
+
+	// Write data.json
+	data, err := json.Marshal(contentItems)
+	if err != nil {
+		return 0, err
+	}
+
+	if err := aw.AddContentItems(data); err != nil {
+		return 0, err
+	}
+
+	c.log.Infof("  -> Total %d items", len(contentItems))
+
+	return len(contentItems), nil
+}
@@ -0,0 +1,269 @@
+package apim
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"github.com/Azure/azure-pipeline-go/pipeline"
+	"github.com/Azure/azure-storage-blob-go/azblob"
+	"github.com/Azure/go-autorest/autorest"
+	"github.com/sirupsen/logrus"
+)
+
+// The management API is addressed with placeholder IDs, as the instance is
+// identified by the host name
+func apimMgmtURL(mgmtHost string) string {
+	return mgmtHost + "/subscriptions/00000/resourceGroups/00000/providers/Microsoft.ApiManagement/service/00000"
+}
+
+// StatusError is returned when a request is answered with a status other
+// than 2xx
+type StatusError struct {
+	StatusCode int
+	Status     string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("status %s received", e.Status)
+}
+
+func statusError(resp *http.Response) error {
+	return &StatusError{StatusCode: resp.StatusCode, Status: resp.Status}
+}
+
+// ResourceManagerClient makes Azure Resource Manager requests, decorated
+// with an API version and an access token
+type ResourceManagerClient struct {
+	client     *http.Client
+	authz      autorest.Authorizer
+	apiVersion string
+	log        logrus.FieldLogger
+}
+
+// NewResourceManagerClient returns a client that authorizes requests with
+// authz and adds apiVersion to requests that don't carry their own.  Requests
+// are sent with client, or http.DefaultClient if nil.  Nothing is logged
+// unless a logger is set with WithLogger.
+func NewResourceManagerClient(authz autorest.Authorizer, apiVersion string, client *http.Client) *ResourceManagerClient {
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	return &ResourceManagerClient{
+		client:     client,
+		authz:      authz,
+		apiVersion: apiVersion,
+		log:        nullLogger,
+	}
+}
+
+// WithLogger sets the logger that requests are logged to, and returns the
+// client
+func (c *ResourceManagerClient) WithLogger(log logrus.FieldLogger) *ResourceManagerClient {
+	c.log = log
+	return c
+}
+
+// Do sends a request
+func (c *ResourceManagerClient) Do(req *http.Request) (*http.Response, error) {
+	/* Tack the API Version number to the query string */
+	vals, err := url.ParseQuery(req.URL.RawQuery)
+	if err != nil {
+		return nil, err
+	}
+
+	/* Requests may carry their own version, eg. from a nextLink */
+	if vals.Get("api-version") == "" {
+		vals.Set("api-version", c.apiVersion)
+	}
+	req.URL.RawQuery = vals.Encode()
+
+	/* Decorate the request with he authorizer */
+	r, err := autorest.Prepare(req, c.authz.WithAuthorization())
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := c.client.Do(r)
+	if err == nil {
+		c.log.Debugf("[AZ MgmtAPI] %s to %s: %s", req.Method, req.URL, resp.Status)
+	} else {
+		c.log.WithError(err).Errorf("[AZ MgmtAPI] %s to %s", req.Method, req.URL)
+	}
+
+	return resp, err
+}
+
+// Get sends a GET request
+func (c *ResourceManagerClient) Get(ctx context.Context, url string) (resp *http.Response, err error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	return c.Do(req)
+}
+
+// Post sends a POST request with body encoded as JSON
+func (c *ResourceManagerClient) Post(ctx context.Context, url string, body interface{}) (resp *http.Response, err error) {
+	req, err := newJSONRequest(ctx, "POST", url, body)
+	if err != nil {
+		return nil, err
+	}
+	return c.Do(req)
+}
+
+// Makes management API requests, decorated with an API version and an
+// Administrator SAS token
+type apimClient struct {
+	client     *http.Client
+	tokens     *sasTokenSource
+	apiVersion string
+	log        logrus.FieldLogger
+}
+
+func newApimClient(tokens *sasTokenSource, apiVersion string, client *http.Client, log logrus.FieldLogger) *apimClient {
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	return &apimClient{
+		client:     client,
+		tokens:     tokens,
+		apiVersion: apiVersion,
+		log:        log,
+	}
+}
+
+func (c *apimClient) Do(req *http.Request) (*http.Response, error) {
+	/* Tack the API Version number to the query string */
+	vals, err := url.ParseQuery(req.URL.RawQuery)
+	if err != nil {
+		return nil, err
+	}
+
+	/* Requests may carry their own version, eg. from a nextLink */
+	if vals.Get("api-version") == "" {
+		vals.Set("api-version", c.apiVersion)
+	}
+	req.URL.RawQuery = vals.Encode()
+
+	/* Decorate the request with he SAS token */
+	token, err := c.tokens.Token()
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("authorization", "SharedAccessSignature "+token)
+
+	resp, err := c.client.Do(req)
+	if err == nil {
+		c.log.Debugf("[APIM MgmtApi] %s to %s: %s", req.Method, req.URL, resp.Status)
+	} else {
+		c.log.WithError(err).Errorf("[APIM MgmtApi] %s to %s", req.Method, req.URL)
+		return resp, err
+	}
+
+	// Retry once with a new token if the token was rejected and the request
+	// can be replayed
+	if resp.StatusCode != http.StatusUnauthorized || !c.tokens.CanRefresh() ||
+		(req.Body != nil && req.GetBody == nil) {
+		return resp, nil
+	}
+
+	resp.Body.Close()
+
+	token, err = c.tokens.Refresh(token)
+	if err != nil {
+		return nil, err
+	}
+
+	retry := req.Clone(req.Context())
+	if req.GetBody != nil {
+		retry.Body, err = req.GetBody()
+		if err != nil {
+			return nil, err
+		}
+	}
+	retry.Header.Set("authorization", "SharedAccessSignature "+token)
+
+	resp, err = c.client.Do(retry)
+	if err == nil {
+		c.log.Debugf("[APIM MgmtApi] %s to %s (retry): %s", req.Method, req.URL, resp.Status)
+	} else {
+		c.log.WithError(err).Errorf("[APIM MgmtApi] %s to %s (retry)", req.Method, req.URL)
+	}
+
+	return resp, err
+}
+
+func (c *apimClient) Get(ctx context.Context, url string) (resp *http.Response, err error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	return c.Do(req)
+}
+
+func (c *apimClient) Post(ctx context.Context, url string, body interface{}) (resp *http.Response, err error) {
+	req, err := newJSONRequest(ctx, "POST", url, body)
+	if err != nil {
+		return nil, err
+	}
+	return c.Do(req)
+}
+
+// A request with body, if not nil, encoded as JSON
+func newJSONRequest(ctx context.Context, method, url string, body interface{}) (*http.Request, error) {
+	var requestBody []byte
+	if body != nil {
+		var err error
+		requestBody, err = json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, url, bytes.NewReader(requestBody))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	return req, nil
+}
+
+// PipelineOptions returns options for blob storage pipelines that send
+// requests with client.  With a nil client the azblob default sender is
+// used.
+func PipelineOptions(client *http.Client) azblob.PipelineOptions {
+	if client == nil {
+		return azblob.PipelineOptions{}
+	}
+
+	sender := pipeline.FactoryFunc(func(next pipeline.Policy, po *pipeline.PolicyOptions) pipeline.PolicyFunc {
+		return func(ctx context.Context, request pipeline.Request) (pipeline.Response, error) {
+			r, err := client.Do(request.WithContext(ctx))
+			if err != nil {
+				err = pipeline.NewError(err, "HTTP request failed")
+			}
+			return pipeline.NewHTTPResponse(r), err
+		}
+	})
+
+	return azblob.PipelineOptions{HTTPSender: sender}
+}
+
+// ContainerURLFromSasURL returns a container URL from a container SAS URL,
+// sending requests with client as PipelineOptions does
+func ContainerURLFromSasURL(sasURL string, client *http.Client) (*azblob.ContainerURL, error) {
+	u, err := url.Parse(sasURL)
+	if err != nil {
+		return nil, err
+	}
+
+	c := azblob.NewContainerURL(*u, azblob.NewPipeline(azblob.NewAnonymousCredential(), PipelineOptions(client)))
+	return &c, nil
+}
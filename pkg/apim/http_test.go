@@ -0,0 +1,110 @@
+package apim
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// A token fetcher that vends token-1, token-2, .. each valid for validity
+func countingFetcher(validity time.Duration) (tokenFetcher, *int) {
+	n := 0
+	return func() (string, time.Time, error) {
+		n++
+		return fmt.Sprintf("token-%d", n), time.Now().Add(validity), nil
+	}, &n
+}
+
+func TestApimClientRenewsExpiringToken(t *testing.T) {
+	var seen []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = append(seen, r.Header.Get("Authorization"))
+	}))
+	defer srv.Close()
+
+	// Tokens that are already inside the renewal margin are renewed before use
+	fetch, n := countingFetcher(time.Minute)
+	tokens, err := newSasTokenSource(fetch, nullLogger)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cli := newApimClient(tokens, DefaultAPIVersion, nil, nullLogger)
+	for i := 0; i < 2; i++ {
+		resp, err := cli.Get(context.Background(), srv.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+	}
+
+	want := []string{"SharedAccessSignature token-2", "SharedAccessSignature token-3"}
+	if fmt.Sprint(seen) != fmt.Sprint(want) {
+		t.Errorf("Got %v, wanted %v", seen, want)
+	}
+	if *n != 3 {
+		t.Errorf("Expected 3 token fetches, got %d", *n)
+	}
+}
+
+func TestApimClientRetriesUnauthorized(t *testing.T) {
+	var bodies []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := ioutil.ReadAll(r.Body)
+		bodies = append(bodies, string(b))
+
+		if r.Header.Get("Authorization") != "SharedAccessSignature token-2" {
+			w.WriteHeader(http.StatusUnauthorized)
+		}
+	}))
+	defer srv.Close()
+
+	fetch, n := countingFetcher(time.Hour)
+	tokens, err := newSasTokenSource(fetch, nullLogger)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cli := newApimClient(tokens, DefaultAPIVersion, nil, nullLogger)
+	resp, err := cli.Post(context.Background(), srv.URL, map[string]string{"foo": "bar"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("Expected status 200 after retry, got %s", resp.Status)
+	}
+	if *n != 2 {
+		t.Errorf("Expected 2 token fetches, got %d", *n)
+	}
+
+	want := []string{`{"foo":"bar"}`, `{"foo":"bar"}`}
+	if fmt.Sprint(bodies) != fmt.Sprint(want) {
+		t.Errorf("Got bodies %v, wanted %v", bodies, want)
+	}
+}
+
+func TestApimClientStaticTokenNotRetried(t *testing.T) {
+	var calls int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer srv.Close()
+
+	cli := newApimClient(staticSasTokenSource("token"), DefaultAPIVersion, nil, nullLogger)
+	resp, err := cli.Get(context.Background(), srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusUnauthorized || calls != 1 {
+		t.Errorf("Expected a single 401, got %s after %d calls", resp.Status, calls)
+	}
+}
@@ -0,0 +1,53 @@
+package apim
+
+import "fmt"
+
+// ItemCounts are the counts of items successfully processed and failed by
+// an operation
+type ItemCounts struct {
+	OK     int `json:"ok"`
+	Errors int `json:"errors"`
+
+	// Items completed by an earlier run of a resumed operation
+	Skipped int `json:"skipped,omitempty"`
+}
+
+func (c ItemCounts) String() string {
+	if c.Skipped > 0 {
+		return fmt.Sprintf("%d, %d errors, %d already done", c.OK, c.Errors, c.Skipped)
+	}
+
+	return fmt.Sprintf("%d, %d errors", c.OK, c.Errors)
+}
+
+// Progress is told of the items and bytes transferred by an operation
+type Progress interface {
+	// Add records n bytes of the current item transferred
+	Add(n int64)
+
+	// Done records an item completed or skipped, whose size is bytes
+	Done(bytes int64)
+
+	// Finish is called when the transfer ends
+	Finish()
+}
+
+// NewProgressFunc starts reporting the progress of transferring items of
+// unit (eg. "blobs") totalling bytes, which is 0 if not known
+type NewProgressFunc func(label, unit string, items int, bytes int64) Progress
+
+// Reports nothing, when no NewProgressFunc is configured
+type noProgress struct{}
+
+func (noProgress) Add(int64)  {}
+func (noProgress) Done(int64) {}
+func (noProgress) Finish()    {}
+
+// Start reporting the progress of a transfer
+func (c *Client) newProgress(label, unit string, items int, bytes int64) Progress {
+	if c.progress == nil {
+		return noProgress{}
+	}
+
+	return c.progress(label, unit, items, bytes)
+}
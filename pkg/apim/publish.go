@@ -0,0 +1,252 @@
+package apim
+
+import (
+	"context"
+	"fmt"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/Azure/azure-storage-blob-go/azblob"
+)
+
+// How long a publish is waited for
+const publishTimeout = 5 * time.Minute
+
+// PublishOptions control a publish
+type PublishOptions struct {
+	// Wait for a managed portal publish to complete
+	Wait bool
+
+	// Output of a self-hosted portal's publish pipeline (usually
+	// dist/website), uploaded to the static website container
+	WebsiteDir string
+
+	// Leave the files in a self-hosted portal's static website container
+	// that are not in WebsiteDir, rather than deleting them
+	NoDelete bool
+}
+
+// PublishResult summarises a publish
+type PublishResult struct {
+	// triggered, or published once complete
+	Status      string `json:"status"`
+	PublishDate string `json:"portal_version,omitempty"`
+
+	// Self-hosted portal files
+	Files        *ItemCounts `json:"files,omitempty"`
+	DeletedFiles *ItemCounts `json:"deleted_files,omitempty"`
+}
+
+// Publish publishes the portal.  A managed portal publish is triggered and
+// optionally waited for, for up to 5 minutes.  A self-hosted portal is
+// published by uploading the website directory to the static website
+// container, deleting the files in the container that are not in the
+// directory.  Nothing is deleted if any file fails to upload or ctx is
+// cancelled, as the files not uploaded would look extra.
+func (c *Client) Publish(ctx context.Context, opts PublishOptions) (PublishResult, error) {
+	if c.selfHosted {
+		return c.publishSelfHosted(ctx, opts)
+	}
+
+	return c.publishManaged(ctx, opts.Wait)
+}
+
+// Publish the managed developer portal and optionally wait for the publish
+// to complete
+func (c *Client) publishManaged(ctx context.Context, wait bool) (result PublishResult, err error) {
+	// Get the current publish date
+	status1, err := c.portalStatus(ctx)
+	if err != nil {
+		return result, err
+	}
+	c.log.Debugf("Initial portal status: %+v", status1)
+
+	// If the last publish is not at least a minute ago, wait until it is at least
+	// a minute old.  This is necessary because the publish date only has a
+	// per-minute resolution
+	//
+	waitUntil := time.Date(status1.PortalVersion.Year(), status1.PortalVersion.Month(),
+		status1.PortalVersion.Day(), status1.PortalVersion.Hour(),
+		status1.PortalVersion.Minute(), 0, 0, time.UTC).Add(time.Minute)
+	if waitUntil.After(time.Now()) {
+		waitFor := time.Until(waitUntil)
+		c.log.Infof("Waiting for %s before publishing portal", waitFor.Truncate(time.Second))
+		if err := sleepContext(ctx, waitFor); err != nil {
+			return result, err
+		}
+	}
+
+	// Trigger the publish
+	reqURL := fmt.Sprintf("%s/publish", c.endpoints.PortalURL)
+	req, err := http.NewRequestWithContext(ctx, "POST", reqURL, nil)
+	if err != nil {
+		return result, err
+	}
+
+	resp, err := c.apim.Do(req)
+	if err != nil {
+		return result, err
+	}
+	resp.Body.Close()
+
+	// Only accept HTTP 2xx codes
+	if resp.StatusCode >= 300 {
+		return result, fmt.Errorf("publishing portal, got %s", resp.Status)
+	}
+
+	if !wait {
+		c.log.Infof("Developer portal publish triggered")
+		result.Status = "triggered"
+		return result, nil
+	}
+
+	c.log.Infoln("Waiting (max 5 mins) for publish to complete")
+
+	// 5 minute max wait for the portal to be deployed and published
+	ctx, cancel := context.WithTimeout(ctx, publishTimeout)
+	defer cancel()
+
+	// Loop waiting for initial deployment
+	for {
+		isDeployed, err := c.isDeployed(ctx)
+		if err != nil {
+			return result, err
+		}
+
+		if isDeployed {
+			c.log.Debugln("Devportal is deployed")
+			break
+		}
+
+		c.log.Debugln("Devportal not yet deployed..")
+		if err := sleepContext(ctx, 5*time.Second); err != nil {
+			return result, err
+		}
+	}
+
+	// Wait for the publish date to change
+	for {
+		status2, err := c.portalStatus(ctx)
+		if err != nil {
+			return result, err
+		}
+
+		if status1.PortalVersion != status2.PortalVersion {
+			c.log.Debugln("Devportal is published")
+			result.PublishDate = status2.PortalVersion.Format(time.RFC3339)
+			break
+		}
+
+		c.log.Debugln("Devportal not yet published..")
+		if err := sleepContext(ctx, 5*time.Second); err != nil {
+			return result, err
+		}
+	}
+
+	c.log.Infoln("Developer portal published")
+	result.Status = "published"
+	return result, nil
+}
+
+// Publish a self-hosted portal by uploading the output of the portal's
+// publish pipeline to the static website container
+func (c *Client) publishSelfHosted(ctx context.Context, opts PublishOptions) (result PublishResult, err error) {
+	dir := opts.WebsiteDir
+	if dir == "" {
+		return result, fmt.Errorf("a website directory is required to publish a self-hosted portal")
+	}
+
+	if c.websiteContainer == nil {
+		return result, fmt.Errorf("self-hosted portal has no static website container to publish to")
+	}
+
+	c.log.Infof("Uploading %s to static website container", dir)
+
+	var fileList = make([]string, 0, 100)
+	var files ItemCounts
+
+	err = filepath.Walk(dir, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		// Stop walking, leaving the container's extra files in place
+		if ctx.Err() != nil {
+			c.log.Warnf("  -> Stopped after %d files, %d errors", files.OK, files.Errors)
+			return ctx.Err()
+		}
+
+		if fi.IsDir() {
+			return nil
+		}
+
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		name := filepath.ToSlash(rel)
+
+		if err := c.uploadWebsiteFile(ctx, c.websiteContainer, name, path); err != nil {
+			c.log.WithError(err).Errorf("Uploading %s", name)
+			files.Errors++
+		} else {
+			fileList = append(fileList, name)
+			files.OK++
+		}
+
+		return nil
+	})
+	if err != nil {
+		return result, err
+	}
+	result.Files = &files
+
+	c.log.Infof("  -> Total %d files, %d errors", files.OK, files.Errors)
+
+	// Delete extra files unless told not to.  A file that failed to upload
+	// is not in fileList, and deleting it would take it off the site.
+	switch {
+	case ctx.Err() != nil:
+		c.log.Warnln("Publish interrupted, not deleting extra files")
+		return result, ctx.Err()
+	case files.Errors > 0:
+		return result, fmt.Errorf("%d website files not uploaded, not deleting extra files", files.Errors)
+	case opts.NoDelete:
+		c.log.Infoln("Not deleting extra files")
+	default:
+		deleted, err := c.deleteExtraBlobs(ctx, c.websiteContainer, fileList)
+		if err != nil {
+			return result, err
+		}
+		result.DeletedFiles = &deleted
+	}
+
+	c.log.Infoln("Self-hosted developer portal published")
+	result.Status = "published"
+	return result, nil
+}
+
+// Upload a file to the static website container with a content type
+// derived from its extension, so the browser renders it correctly
+func (c *Client) uploadWebsiteFile(ctx context.Context, url *azblob.ContainerURL, name, path string) error {
+	c.log.Debugf("Uploading website file %s", name)
+
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	contentType := mime.TypeByExtension(filepath.Ext(name))
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+
+	blobURL := url.NewBlockBlobURL(name)
+	_, err = blobURL.Upload(ctx, f, azblob.BlobHTTPHeaders{ContentType: contentType}, azblob.Metadata{}, azblob.BlobAccessConditions{})
+
+	return err
+}
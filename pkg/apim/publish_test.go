@@ -0,0 +1,117 @@
+package apim
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/Azure/go-autorest/autorest"
+
+	"github.com/jake-scott/apim-tools/internal/pkg/apimfake"
+)
+
+// Refuses uploads of the named blob, as a proxy might
+type failUploadTransport struct {
+	name string
+}
+
+func (t failUploadTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	if r.Method == "PUT" && path.Base(r.URL.Path) == t.name {
+		return &http.Response{
+			StatusCode: http.StatusForbidden,
+			Status:     "403 Forbidden",
+			Header:     make(http.Header),
+			Body:       ioutil.NopCloser(strings.NewReader("")),
+			Request:    r,
+		}, nil
+	}
+
+	return http.DefaultTransport.RoundTrip(r)
+}
+
+// A self-hosted portal whose media and website share the fake's container
+func selfHostedClient(t *testing.T, f *apimfake.Server, website *http.Client) *Client {
+	sasURL := f.ContainerURL() + "?sig=test"
+
+	media, err := ContainerURLFromSasURL(sasURL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	site, err := ContainerURLFromSasURL(sasURL, website)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := New(context.Background(), Config{
+		InstanceID:         apimfake.InstanceID,
+		ResourceManagerURL: f.ResourceManagerURL(),
+		Authorizer:         autorest.NullAuthorizer{},
+		SelfHosted:         &SelfHosted{Media: media, Website: site},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return c
+}
+
+func TestPublishSelfHosted(t *testing.T) {
+	ctx := context.Background()
+
+	dir, err := ioutil.TempDir("", "apimtest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for name, data := range map[string]string{"index.html": "new", "app.js": "app"} {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(data), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	f := apimfake.NewServer()
+	defer f.Close()
+	f.PutBlob("index.html", []byte("live"))
+	f.PutBlob("stale.js", []byte("stale"))
+
+	// A file that fails to upload keeps its live copy, and nothing is deleted
+	c := selfHostedClient(t, f, &http.Client{Transport: failUploadTransport{"index.html"}})
+	res, err := c.Publish(ctx, PublishOptions{WebsiteDir: dir})
+	if err == nil {
+		t.Fatalf("Expected an error when a file fails to upload")
+	}
+	if res.Files == nil || res.Files.Errors != 1 || res.DeletedFiles != nil {
+		t.Errorf("Got publish result %+v", res)
+	}
+
+	blobs := f.Blobs()
+	if string(blobs["index.html"]) != "live" || string(blobs["app.js"]) != "app" || blobs["stale.js"] == nil {
+		t.Errorf("Got website files %v after a failed upload", blobs)
+	}
+
+	// Extra files are kept if asked
+	c = selfHostedClient(t, f, nil)
+	if _, err := c.Publish(ctx, PublishOptions{WebsiteDir: dir, NoDelete: true}); err != nil {
+		t.Fatal(err)
+	}
+	if blobs := f.Blobs(); string(blobs["index.html"]) != "new" || blobs["stale.js"] == nil {
+		t.Errorf("Got website files %v with NoDelete", blobs)
+	}
+
+	res, err = c.Publish(ctx, PublishOptions{WebsiteDir: dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.DeletedFiles == nil || res.DeletedFiles.OK != 1 {
+		t.Errorf("Got publish result %+v", res)
+	}
+	if _, ok := f.Blobs()["stale.js"]; ok {
+		t.Errorf("Extra website file not deleted")
+	}
+}
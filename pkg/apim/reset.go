@@ -0,0 +1,85 @@
+package apim
+
+import (
+	"context"
+
+	"github.com/Azure/azure-storage-blob-go/azblob"
+)
+
+// ResetResult summarises a reset
+type ResetResult struct {
+	DeletedContentItems ItemCounts `json:"deleted_content_items"`
+	DeletedBlobs        ItemCounts `json:"deleted_blobs"`
+}
+
+// Reset deletes all of the portal content items and media, which cannot be
+// undone.  If ctx is cancelled the reset stops and ctx.Err() is returned
+// along with the result.
+func (c *Client) Reset(ctx context.Context) (result ResetResult, err error) {
+	result.DeletedContentItems, err = c.deleteAllContentItems(ctx)
+	if err == nil {
+		result.DeletedBlobs, err = c.resetBlobs(ctx, c.mediaContainer)
+	}
+
+	return result, err
+}
+
+func (c *Client) deleteAllContentItems(ctx context.Context) (counts ItemCounts, err error) {
+	c.log.Info("Deleting portal content items")
+
+	contentItems, err := c.ContentItems(ctx)
+	if err != nil {
+		return counts, err
+	}
+
+	ids := make([]string, 0, len(contentItems))
+	for _, item := range contentItems {
+		ids = append(ids, item["id"].(string))
+	}
+
+	// Delete the content items
+	counts, err = deleteContentItems(ctx, c.apim, c.endpoints.ManagementURL, ids)
+	if err != nil {
+		return counts, err
+	}
+
+	c.log.Infof("Deleted %d content items, %d errors", counts.OK, counts.Errors)
+
+	return counts, nil
+}
+
+func (c *Client) resetBlobs(ctx context.Context, containerURL *azblob.ContainerURL) (counts ItemCounts, err error) {
+	c.log.Infof("Deleting blobs")
+
+	for marker := (azblob.Marker{}); marker.NotDone(); {
+		listBlobs, err := containerURL.ListBlobsFlatSegment(ctx, marker, azblob.ListBlobsSegmentOptions{})
+		if err != nil {
+			return counts, err
+		}
+
+		marker = listBlobs.NextMarker
+
+		for _, blobInfo := range listBlobs.Segment.BlobItems {
+			if ctx.Err() != nil {
+				c.log.Warnf("Stopped after deleting %d blobs, %d errors", counts.OK, counts.Errors)
+				return counts, ctx.Err()
+			}
+
+			c.log.Debugf("Deleting blob: %s", blobInfo.Name)
+
+			blobURL := containerURL.NewBlobURL(blobInfo.Name)
+
+			_, err = blobURL.Delete(ctx, azblob.DeleteSnapshotsOptionNone, azblob.BlobAccessConditions{})
+			if err != nil {
+				c.log.WithError(err).Errorf("Deleting BLOB %s", blobInfo.Name)
+				counts.Errors++
+			} else {
+				counts.OK++
+			}
+		}
+	}
+
+	c.log.Infof("Deleted %d blobs, %d errors", counts.OK, counts.Errors)
+
+	return counts, nil
+}
@@ -0,0 +1,230 @@
+package apim
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// PortalStatus is the deployment and publish state of the portal
+type PortalStatus struct {
+	Deployed bool
+
+	// When the portal was last published, zero if never
+	PublishDate time.Time
+
+	// Portal code version, eg. 20200925173036
+	CodeVersion string
+
+	// Portal version, eg. 0.14.1072.0
+	Version string
+}
+
+// Dev portal status
+type portalStatusQueryResult struct {
+	PortalStatus  int    `json:"Status"`
+	PortalVersion string `json:"PortalVersion"`
+	CodeVersion   string `json:"CodeVersion"`
+	Version       string `json:"Version"`
+}
+
+// Normalised version of the portal status
+type portalStatusQueryNormalised struct {
+	PortalStatus  int
+	PortalVersion time.Time
+	CodeVersion   string
+	Version       string
+}
+
+// Status reports whether the portal is deployed and, unless the portal is
+// self-hosted and so has no status endpoint, its versions and when it was
+// last published.  The status of a self-hosted portal can only be reported
+// if its URL is known.
+func (c *Client) Status(ctx context.Context) (status PortalStatus, err error) {
+	if c.selfHosted && !c.selfHostedURL {
+		return status, fmt.Errorf("the URL of the self-hosted portal is needed to report its status")
+	}
+
+	status.Deployed, err = c.isDeployed(ctx)
+	if err != nil {
+		return status, err
+	}
+
+	// Self-hosted portals don't have a status endpoint
+	if c.selfHosted {
+		return status, nil
+	}
+
+	s, err := c.portalStatus(ctx)
+	if err != nil {
+		return status, err
+	}
+
+	status.PublishDate = s.PortalVersion
+	status.CodeVersion = s.CodeVersion
+	status.Version = s.Version
+
+	return status, nil
+}
+
+// Tests whether the developer portal is deployed or not
+func (c *Client) isDeployed(ctx context.Context) (bool, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", c.endpoints.PortalURL, nil)
+	if err != nil {
+		return false, err
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == 200:
+		return true, nil
+	case resp.StatusCode == 404:
+		return false, nil
+	}
+
+	return false, fmt.Errorf("unknown dev portal status %d (%s)", resp.StatusCode, resp.Status)
+}
+
+func (c *Client) portalStatus(ctx context.Context) (status portalStatusQueryNormalised, err error) {
+	reqURL := fmt.Sprintf("%s/internal-status-0123456789abcdef", c.endpoints.PortalURL)
+	req, err := http.NewRequestWithContext(ctx, "GET", reqURL, nil)
+	if err != nil {
+		return
+	}
+
+	/*
+	 * The dev portal has a bug and often returns a debug HTML response and not
+	   the JSON response its meant to, so retry a few times
+	*/
+	var numRetries int = 3
+	var respBody []byte
+
+	for numRetries > 0 {
+		resp, err := c.http.Do(req)
+		if err != nil {
+			return status, err
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != 200 {
+			err = fmt.Errorf("portal status: got %s", resp.Status)
+			return status, err
+		}
+
+		ct := resp.Header.Get("content-type")
+		if strings.HasPrefix(ct, "application/json") {
+			// Grab the body
+			respBody, err = ioutil.ReadAll(resp.Body)
+			if err != nil {
+				return status, err
+			}
+
+			break
+		}
+
+		c.log.Warnf("Dev portal returned '%s' response, ignoring", ct)
+		if err := sleepContext(ctx, time.Second*5); err != nil {
+			return status, err
+		}
+		numRetries--
+	}
+
+	if numRetries == 0 {
+		err = fmt.Errorf("too many bad responses received, giving up")
+		return
+	}
+
+	s := portalStatusQueryResult{}
+	if err = json.Unmarshal(respBody, &s); err != nil {
+		return
+	}
+
+	// Normalise the response
+	publishDate, err := parsePublishDate(s.PortalVersion)
+	if err != nil {
+		return
+	}
+
+	status.PortalStatus = s.PortalStatus
+	status.CodeVersion = s.CodeVersion
+	status.Version = s.Version
+	status.PortalVersion = publishDate
+
+	c.log.Debugf("Portal status: %+v", status)
+
+	return status, err
+}
+
+func parsePublishDate(s string) (t time.Time, err error) {
+	if len(s) < 12 {
+		return
+	}
+
+	yy, err := strconv.Atoi(s[0:4])
+	if err != nil {
+		return
+	}
+	mM, err := strconv.Atoi(s[4:6])
+	if err != nil {
+		return
+	}
+	dd, err := strconv.Atoi(s[6:8])
+	if err != nil {
+		return
+	}
+	hh, err := strconv.Atoi(s[8:10])
+	if err != nil {
+		return
+	}
+	mm, err := strconv.Atoi(s[10:12])
+	if err != nil {
+		return
+	}
+
+	return time.Date(yy, time.Month(mM), dd, hh, mm, 0, 0, time.UTC), nil
+}
+
+// Compare two portal code versions (timestamps of the form 20200925173036),
+// returning -1, 0 or 1 if a is older than, the same as or newer than b
+func compareCodeVersions(a, b string) (int, error) {
+	for _, v := range []string{a, b} {
+		if v == "" {
+			return 0, fmt.Errorf("empty code version")
+		}
+
+		for _, c := range v {
+			if c < '0' || c > '9' {
+				return 0, fmt.Errorf("bad code version: %s", v)
+			}
+		}
+	}
+
+	if len(a) != len(b) {
+		return 0, fmt.Errorf("code versions have different formats")
+	}
+
+	return strings.Compare(a, b), nil
+}
+
+// Pause for d, returning early if ctx is done
+func sleepContext(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,70 @@
+package apim
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestCompareCodeVersions(t *testing.T) {
+	tests := []struct {
+		a, b      string
+		want      int
+		expectErr bool
+	}{
+		{"20200925173036", "20200925173036", 0, false},
+		{"20200925173036", "20201014120000", -1, false},
+		{"20201014120000", "20200925173036", 1, false},
+		{"", "20200925173036", 0, true},
+		{"20200925173036", "", 0, true},
+		{"2020092517", "20200925173036", 0, true},
+		{"0.14.1072.0", "20200925173036", 0, true},
+	}
+
+	for _, tt := range tests {
+		got, err := compareCodeVersions(tt.a, tt.b)
+		if tt.expectErr {
+			if err == nil {
+				t.Errorf("Expected error comparing %q and %q", tt.a, tt.b)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("Comparing %q and %q: %s", tt.a, tt.b, err)
+		} else if got != tt.want {
+			t.Errorf("Comparing %q and %q: got %d, wanted %d", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func TestSleepContext(t *testing.T) {
+	if err := sleepContext(context.Background(), time.Millisecond); err != nil {
+		t.Errorf("Uninterrupted sleep returned %s", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	start := time.Now()
+	if err := sleepContext(ctx, time.Minute); err != context.Canceled {
+		t.Errorf("Expected context.Canceled, got %v", err)
+	}
+	if time.Since(start) > time.Second {
+		t.Errorf("Sleep was not interrupted")
+	}
+}
+
+// A self-hosted portal has no status endpoint, and without its URL only the
+// managed portal could be queried
+func TestSelfHostedStatus(t *testing.T) {
+	c := &Client{selfHosted: true, log: nullLogger}
+
+	if _, err := c.Status(context.Background()); err == nil {
+		t.Errorf("Expected an error querying a self-hosted portal without its URL")
+	}
+
+	if m := c.buildManifest(context.Background()); m.CodeVersion != "" || m.Version != "" {
+		t.Errorf("Manifest of a self-hosted portal records versions %+v", m)
+	}
+}
@@ -0,0 +1,109 @@
+package apim
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Renew the token this long before it expires
+const tokenRenewalMargin = 5 * time.Minute
+
+// tokenFetcher returns a new SAS token and its expiry time
+type tokenFetcher func() (string, time.Time, error)
+
+// sasTokenSource vends APIM SAS tokens, fetching a new one shortly before
+// the current token expires or when the management API rejects it
+type sasTokenSource struct {
+	mu     sync.Mutex
+	token  string
+	expiry time.Time
+	fetch  tokenFetcher
+	log    logrus.FieldLogger
+}
+
+// newSasTokenSource returns a token source that uses fetch to obtain tokens,
+// logging renewals to log.  The first token is fetched immediately.
+func newSasTokenSource(fetch tokenFetcher, log logrus.FieldLogger) (*sasTokenSource, error) {
+	s := &sasTokenSource{fetch: fetch, log: log}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.refreshLocked(); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// staticSasTokenSource returns a token source that always returns token
+func staticSasTokenSource(token string) *sasTokenSource {
+	return &sasTokenSource{token: token}
+}
+
+// Token returns a token that is valid for at least tokenRenewalMargin,
+// fetching a new one if necessary
+func (s *sasTokenSource) Token() (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.fetch != nil && time.Until(s.expiry) < tokenRenewalMargin {
+		s.log.Debugf("[APIM MgmtApi] SAS token expires at %s, renewing", s.expiry.Format(time.RFC3339))
+
+		if err := s.refreshLocked(); err != nil {
+			return "", err
+		}
+	}
+
+	return s.token, nil
+}
+
+// Refresh fetches a new token to replace one that was rejected.  If the
+// token has already been replaced since stale was handed out, the
+// replacement is returned without fetching another.
+func (s *sasTokenSource) Refresh(stale string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.fetch == nil {
+		return "", fmt.Errorf("SAS token cannot be renewed")
+	}
+
+	if s.token == stale {
+		s.log.Debugf("[APIM MgmtApi] SAS token rejected, renewing")
+
+		if err := s.refreshLocked(); err != nil {
+			return "", err
+		}
+	}
+
+	return s.token, nil
+}
+
+// CanRefresh reports whether the source is able to fetch new tokens
+func (s *sasTokenSource) CanRefresh() bool {
+	return s.fetch != nil
+}
+
+// Expiry returns the expiry time of the current token
+func (s *sasTokenSource) Expiry() time.Time {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.expiry
+}
+
+func (s *sasTokenSource) refreshLocked() error {
+	token, expiry, err := s.fetch()
+	if err != nil {
+		return fmt.Errorf("renewing SAS token: %s", err)
+	}
+
+	s.token = token
+	s.expiry = expiry
+
+	return nil
+}
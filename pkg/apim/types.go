@@ -1,10 +1,21 @@
-package cmd
+package apim
 
 // APIM REST request/response structs
 
-// A few details we need from the 'get instance' response
-type apimDetails struct {
+// InstanceDetails are the details of an API Manager instance returned by
+// Resource Manager
+type InstanceDetails struct {
+	ID       string            `json:"id"`
+	Name     string            `json:"name"`
+	Location string            `json:"location"`
+	Tags     map[string]string `json:"tags"`
+	Sku      struct {
+		Name     string `json:"name"`
+		Capacity int    `json:"capacity"`
+	} `json:"sku"`
 	Properties struct {
+		ProvisioningState      string `json:"provisioningState"`
+		GatewayURL             string `json:"gatewayUrl"`
 		MgmtURL                string `json:"managementApiURL"`
 		PortalURL              string `json:"developerPortalURL"`
 		HostnameConfigurations []struct {
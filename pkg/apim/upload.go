@@ -0,0 +1,366 @@
+package apim
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/Azure/azure-storage-blob-go/azblob"
+
+	"github.com/jake-scott/apim-tools/pkg/devportal"
+)
+
+// ErrVersionMismatch is returned by Upload when the archive was taken from
+// a portal with a newer code version than the target portal, or the
+// versions cannot be compared
+var ErrVersionMismatch = errors.New("portal code version mismatch")
+
+// Describes a version mismatch, matching ErrVersionMismatch
+type versionMismatchError struct {
+	msg string
+}
+
+func (e *versionMismatchError) Error() string {
+	return e.msg
+}
+
+func (e *versionMismatchError) Is(target error) bool {
+	return target == ErrVersionMismatch
+}
+
+// UploadOptions control an upload
+type UploadOptions struct {
+	// Leave content items and media that are not in the archive on the
+	// portal, rather than deleting them
+	NoDelete bool
+
+	// Upload even if the archive was taken from a portal with a newer code
+	// version than the target
+	AllowVersionMismatch bool
+
+	// Records the items uploaded, and those recorded by an earlier run are
+	// skipped, if set
+	Journal *devportal.Journal
+}
+
+// UploadResult summarises an upload
+type UploadResult struct {
+	ContentItems        ItemCounts `json:"content_items"`
+	Blobs               ItemCounts `json:"blobs"`
+	DeletedContentItems ItemCounts `json:"deleted_content_items"`
+	DeletedBlobs        ItemCounts `json:"deleted_blobs"`
+}
+
+// Upload replaces the portal content items and media with those in the
+// archive.  Unless the portal is self-hosted, the upload is refused with
+// ErrVersionMismatch if the target portal may not be able to render the
+// archive content.
+//
+// If ctx is cancelled the upload stops and ctx.Err() is returned along with
+// the result.  Nothing is deleted from an interrupted upload, as the content
+// not yet uploaded would look extra.
+func (c *Client) Upload(ctx context.Context, ar *devportal.ArchiveReader, opts UploadOptions) (result UploadResult, err error) {
+	// Make sure the target portal can render the archive content.  Self-hosted
+	// portals run whatever code version was deployed, so cannot be checked
+	if c.selfHosted {
+		c.log.Infof("Self-hosted developer portal, skipping version check")
+	} else if err := c.checkVersion(ctx, ar, opts.AllowVersionMismatch); err != nil {
+		return result, err
+	}
+
+	// Keep a list of what we uploaded
+	var blobList = make([]string, 0, 100)
+	var contentItemList = make([]string, 0, 100)
+
+	// Blobs are uploaded after the content items, so the bar is started with
+	// the first blob
+	var bar Progress
+	nBlobs, blobsSize := ar.BlobTotals()
+
+	// Setup the callbacks
+	r := ar.WithBlobHandler(func(name string, f devportal.ZipReadSeeker) error {
+		if bar == nil {
+			bar = c.newProgress("Media blobs", "blobs", nBlobs, blobsSize)
+		}
+		defer bar.Done(f.Size())
+
+		if journalDone(opts.Journal, devportal.JournalBlob, name) {
+			c.log.Debugf("Media blob %s already uploaded", name)
+			blobList = append(blobList, name)
+			result.Blobs.Skipped++
+			return nil
+		}
+
+		err := c.uploadBlob(ctx, c.mediaContainer, name, f, &blobList)
+		if err == nil {
+			result.Blobs.OK++
+			c.recordJournal(opts.Journal, devportal.JournalBlob, name)
+		} else {
+			result.Blobs.Errors++
+		}
+		return err
+	}).WithIndexHandler(func(f devportal.ZipReadSeeker) (err error) {
+		result.ContentItems, err = c.uploadContentItems(ctx, f, &contentItemList, opts.Journal)
+		return err
+	}).WithProgress(func(n int64) {
+		if bar != nil {
+			bar.Add(n)
+		}
+	})
+
+	// Upload the content
+	err = r.Process(ctx)
+	if bar != nil {
+		bar.Finish()
+	}
+	if err != nil {
+		return result, err
+	}
+
+	// Delete extra content unless told not to
+	switch {
+	case ctx.Err() != nil:
+		c.log.Warnln("Upload interrupted, not deleting extra content")
+		return result, ctx.Err()
+	case opts.NoDelete:
+		c.log.Infoln("Not deleting extra content")
+		return result, nil
+	}
+
+	var err2 error
+	result.DeletedBlobs, err = c.deleteExtraBlobs(ctx, c.mediaContainer, blobList)
+	result.DeletedContentItems, err2 = c.deleteExtraContentItems(ctx, contentItemList)
+
+	switch {
+	case err == nil && err2 != nil:
+		err = err2
+	case err != nil && err2 != nil:
+		err = fmt.Errorf("deleting: %s AND %s", err, err2)
+	}
+
+	return result, err
+}
+
+// Compare the code version recorded in the archive manifest with that of
+// the target portal, refusing to continue if the target is older or the
+// versions cannot be compared, unless allowMismatch is set
+func (c *Client) checkVersion(ctx context.Context, ar *devportal.ArchiveReader, allowMismatch bool) error {
+	manifest, err := ar.Manifest()
+	if err != nil {
+		return err
+	}
+
+	if manifest == nil || manifest.CodeVersion == "" {
+		c.log.Warnf("Archive does not record the source portal version, skipping version check")
+		return nil
+	}
+
+	// An undeployed portal will be deployed with the current code version
+	isDeployed, err := c.isDeployed(ctx)
+	if err != nil {
+		return err
+	}
+	if !isDeployed {
+		c.log.Infof("Developer portal not yet deployed, skipping version check")
+		return nil
+	}
+
+	status, err := c.portalStatus(ctx)
+	if err != nil {
+		return err
+	}
+
+	c.log.Debugf("Archive code version: %s, target code version: %s", manifest.CodeVersion, status.CodeVersion)
+
+	var mismatch error
+	cmp, err := compareCodeVersions(manifest.CodeVersion, status.CodeVersion)
+	switch {
+	case err != nil:
+		mismatch = &versionMismatchError{fmt.Sprintf("cannot compare archive code version %s with target %s: %s",
+			manifest.CodeVersion, status.CodeVersion, err)}
+	case cmp > 0:
+		mismatch = &versionMismatchError{fmt.Sprintf("archive code version %s is newer than target portal code version %s",
+			manifest.CodeVersion, status.CodeVersion)}
+	default:
+		return nil
+	}
+
+	if allowMismatch {
+		c.log.Warnf("%s, continuing anyway", mismatch)
+		return nil
+	}
+
+	return mismatch
+}
+
+// Delete the content items on the portal that are not in list
+func (c *Client) deleteExtraContentItems(ctx context.Context, list []string) (counts ItemCounts, err error) {
+	items, err := c.ContentItems(ctx)
+	if err != nil {
+		return counts, err
+	}
+
+	allContentIds := make([]string, 0, len(items))
+	for _, item := range items {
+		allContentIds = append(allContentIds, item["id"].(string))
+	}
+
+	c.log.Debugf("Found %d content items on portal", len(allContentIds))
+
+	// Find content items on the portal that were not in the Zip archive
+	extraItems := sliceSubtract(toInterfaceSlice(allContentIds), toInterfaceSlice(list))
+
+	ids := make([]string, 0, len(extraItems))
+	for _, id := range extraItems {
+		ids = append(ids, id.(string))
+	}
+
+	// Delete the extras
+	counts, err = deleteContentItems(ctx, c.apim, c.endpoints.ManagementURL, ids)
+	if err != nil {
+		return counts, err
+	}
+
+	c.log.Infof("Deleted %d extra content items, %d errors", counts.OK, counts.Errors)
+
+	return counts, nil
+}
+
+// Delete the blobs in the container that are not in blobList
+func (c *Client) deleteExtraBlobs(ctx context.Context, url *azblob.ContainerURL, blobList []string) (counts ItemCounts, err error) {
+	// Get a list of blobs in the container
+	var allBlobs = make([]string, 0, 100)
+
+	for marker := (azblob.Marker{}); marker.NotDone(); {
+		listBlobs, err := url.ListBlobsFlatSegment(ctx, marker, azblob.ListBlobsSegmentOptions{})
+		if err != nil {
+			return counts, err
+		}
+
+		marker = listBlobs.NextMarker
+
+		for _, blobInfo := range listBlobs.Segment.BlobItems {
+			allBlobs = append(allBlobs, blobInfo.Name)
+		}
+	}
+
+	c.log.Debugf("Found %d blobs in container", len(allBlobs))
+
+	// Find blobs in the container that were not in the Zip archive
+	extraBlobs := sliceSubtract(toInterfaceSlice(allBlobs), toInterfaceSlice(blobList))
+
+	// Delete the extras
+	for _, blobNameI := range extraBlobs {
+		if ctx.Err() != nil {
+			c.log.Warnf("Stopped after deleting %d extra media blobs, %d errors", counts.OK, counts.Errors)
+			return counts, ctx.Err()
+		}
+
+		blobName := blobNameI.(string)
+		c.log.Debugf("Deleting blob: %s", blobName)
+		blobURL := url.NewBlobURL(blobName)
+
+		_, err := blobURL.Delete(ctx, azblob.DeleteSnapshotsOptionNone, azblob.BlobAccessConditions{})
+		if err != nil {
+			c.log.WithError(err).Errorf("Deleting BLOB %s", blobName)
+			counts.Errors++
+		} else {
+			counts.OK++
+		}
+	}
+
+	c.log.Infof("Deleted %d extra media blobs, %d errors", counts.OK, counts.Errors)
+
+	return counts, nil
+}
+
+// Upload the content items in the index, skipping those the journal records
+// as already uploaded
+func (c *Client) uploadContentItems(ctx context.Context, f devportal.ZipReadSeeker, list *[]string, j *devportal.Journal) (counts ItemCounts, err error) {
+	// Get the index contents
+	data, err := ioutil.ReadAll(&f)
+	if err != nil {
+		return counts, err
+	}
+
+	var items []map[string]interface{}
+	if err := json.Unmarshal(data, &items); err != nil {
+		return counts, err
+	}
+
+	c.log.Infof("Processing %d content items", len(items))
+
+	bar := c.newProgress("Content items", "items", len(items), 0)
+	defer bar.Finish()
+
+	// Grab the ID from each item and upload the item
+	for n, item := range items {
+		if ctx.Err() != nil {
+			c.log.Warnf("  -> Stopped after %d items, %d errors, %d items not uploaded", counts.OK, counts.Errors, len(items)-n)
+			return counts, nil
+		}
+
+		bar.Done(0)
+
+		key := item["id"].(string)
+		delete(item, "id")
+
+		if journalDone(j, devportal.JournalContentItem, key) {
+			c.log.Debugf("Content item %s already uploaded", key)
+			*list = append(*list, key)
+			counts.Skipped++
+			continue
+		}
+
+		err := uploadContentItem(ctx, c.apim, c.endpoints.ManagementURL, key, item)
+		if err != nil {
+			c.log.Errorf("Uploading content item %s: %s", key, err)
+			counts.Errors++
+		} else {
+			*list = append(*list, key)
+			counts.OK++
+			c.recordJournal(j, devportal.JournalContentItem, key)
+		}
+	}
+
+	if counts.Skipped > 0 {
+		c.log.Infof("  -> Total %d items, %d errors, %d already uploaded", counts.OK, counts.Errors, counts.Skipped)
+	} else {
+		c.log.Infof("  -> Total %d items, %d errors", counts.OK, counts.Errors)
+	}
+
+	return counts, nil
+}
+
+func (c *Client) uploadBlob(ctx context.Context, url *azblob.ContainerURL, name string, f devportal.ZipReadSeeker, list *[]string) error {
+	c.log.Debugf("Uploading media blob %s", name)
+	blobURL := url.NewBlockBlobURL(name)
+	_, err := blobURL.Upload(ctx, &f, azblob.BlobHTTPHeaders{ContentType: "text/plain"}, azblob.Metadata{}, azblob.BlobAccessConditions{})
+
+	if err != nil {
+		return err
+	}
+
+	*list = append(*list, name)
+
+	return nil
+}
+
+// Report whether the journal, if any, records the item as done
+func journalDone(j *devportal.Journal, kind, name string) bool {
+	return j != nil && j.Done(kind, name)
+}
+
+// Note an item as done in the journal, if any.  The operation carries on if
+// the journal cannot be written, it just cannot be resumed.
+func (c *Client) recordJournal(j *devportal.Journal, kind, name string) {
+	if j == nil {
+		return
+	}
+
+	if err := j.Record(devportal.JournalEntry{Kind: kind, Name: name}); err != nil {
+		c.log.WithError(err).Warnf("Recording %s %s in journal", kind, name)
+	}
+}
@@ -0,0 +1,316 @@
+package devportal
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+
+	"github.com/sirupsen/logrus"
+)
+
+// IndexHandler defines a function prototype that handles an archive 'index'
+// (data.json) as it is read from the archive
+type IndexHandler func(f ZipReadSeeker) error
+
+// BlobHandler defines a function prototype that handles blobs as they are
+// read from the archive
+type BlobHandler func(name string, f ZipReadSeeker) error
+
+// ProgressFunc defines a function prototype that is told the number of bytes
+// of Blob content read from or written to an archive, as they are transferred
+type ProgressFunc func(n int64)
+
+// ArchiveReader processes a Zip archive, dispatching handling of the index
+// and blobs to supplied callbacks
+type ArchiveReader struct {
+	reader       *zip.Reader
+	closer       io.Closer
+	indexHandler IndexHandler
+	blobHandler  BlobHandler
+	progress     ProgressFunc
+	log          logrus.FieldLogger
+}
+
+// NewArchiveReader returns an ArchiveReader configured to process the
+// supplied archive filename
+func NewArchiveReader(filename string) (a ArchiveReader, err error) {
+	rc, err := zip.OpenReader(filename)
+	if err != nil {
+		return a, err
+	}
+
+	a.reader = &rc.Reader
+	a.closer = rc
+	a.log = nullLogger
+
+	return a, nil
+}
+
+// NewArchiveReaderAt returns an ArchiveReader configured to process the
+// archive of size bytes read from r, eg. a bytes.Reader holding an archive
+// read from stdin.  Close does not close r.
+func NewArchiveReaderAt(r io.ReaderAt, size int64) (a ArchiveReader, err error) {
+	a.reader, err = zip.NewReader(r, size)
+	if err != nil {
+		return a, err
+	}
+
+	a.log = nullLogger
+
+	return a, nil
+}
+
+// WithIndexHandler returns a new ArchiveReader configured with a callback
+// handler that Process() will dispatch to for the data.json index
+func (a ArchiveReader) WithIndexHandler(h IndexHandler) ArchiveReader {
+	a.indexHandler = h
+	return a
+}
+
+// WithBlobHandler returns a new ArchiveReader configured with a callback
+// handler that Process() will dispatch to when it encounters a Blob
+func (a ArchiveReader) WithBlobHandler(h BlobHandler) ArchiveReader {
+	a.blobHandler = h
+	return a
+}
+
+// WithLogger returns a new ArchiveReader configured to log to log.  Nothing
+// is logged by default.
+func (a ArchiveReader) WithLogger(log logrus.FieldLogger) ArchiveReader {
+	a.log = log
+	return a
+}
+
+// WithProgress returns a new ArchiveReader configured with a callback that
+// is told the bytes read as the blob handler reads each Blob
+func (a ArchiveReader) WithProgress(p ProgressFunc) ArchiveReader {
+	a.progress = p
+	return a
+}
+
+// BlobTotals returns the number of Blobs in the archive and their total
+// uncompressed size
+func (a *ArchiveReader) BlobTotals() (count int, size int64) {
+	for _, f := range a.reader.File {
+		if f.Name != IndexName && f.Name != ManifestName {
+			count++
+			size += int64(f.UncompressedSize64)
+		}
+	}
+
+	return count, size
+}
+
+// Close the underlying Zip file reader.  Further operations on the
+// ArchiveReader are invalid
+func (a *ArchiveReader) Close() error {
+	if a.closer == nil {
+		return nil
+	}
+
+	return a.closer.Close()
+}
+
+// Manifest returns the manifest describing the portal the archive was
+// taken from, or nil if the archive has no manifest (archives created by
+// older versions of the tool)
+func (a *ArchiveReader) Manifest() (*Manifest, error) {
+	for _, f := range a.reader.File {
+		if f.Name != ManifestName {
+			continue
+		}
+
+		rc, err := f.Open()
+		if err != nil {
+			return nil, err
+		}
+		defer rc.Close()
+
+		data, err := ioutil.ReadAll(rc)
+		if err != nil {
+			return nil, err
+		}
+
+		m := &Manifest{}
+		if err := json.Unmarshal(data, m); err != nil {
+			return nil, err
+		}
+
+		return m, nil
+	}
+
+	return nil, nil
+}
+
+// Process the archive, dispatching to callbacks to handle the index
+// and blobs.  Once ctx is done no further files are dispatched and the
+// context's error is returned.
+func (a *ArchiveReader) Process(ctx context.Context) error {
+	var cOK, cErr, cSkipped int // blob counts
+	var remaining int           // files not processed when interrupted
+
+	for n, f := range a.reader.File {
+		if ctx.Err() != nil {
+			remaining = len(a.reader.File) - n
+			break
+		}
+
+		// rc can be used to read the content
+		rc, err := f.Open()
+		if err != nil {
+			return err
+		}
+
+		zrs := ZipReadSeeker{
+			ReadCloser: rc,
+			f:          f,
+			log:        a.log,
+		}
+		if f.Name != IndexName {
+			zrs.progress = a.progress
+		}
+
+		defer zrs.Close()
+
+		err = nil
+		switch f.Name {
+		case IndexName:
+			if a.indexHandler != nil {
+				err = a.indexHandler(zrs)
+			}
+		case ManifestName:
+			// Read separately by Manifest()
+		default:
+			if a.blobHandler == nil {
+				cSkipped++
+			} else {
+				err = a.blobHandler(f.Name, zrs)
+
+				if err == nil {
+					cOK++
+				} else {
+					cErr++
+				}
+			}
+		}
+
+		if err != nil {
+			a.log.WithError(err).Errorf("Handling file %s", f.Name)
+		}
+	}
+
+	a.log.Infof("Processed %d media blobs, %d skipped, %d errors", cOK, cSkipped, cErr)
+
+	if remaining > 0 {
+		a.log.Warnf("Stopped with %d files in the archive not processed", remaining)
+		return ctx.Err()
+	}
+
+	return nil
+}
+
+// ZipReadSeeker is a wrapper around an io.ReadCloser, providing additional
+// functionality to emulate Seek(), thus also implementing Seeker() and
+// making ZipReadSeeker a ReaderSeeker
+//
+type ZipReadSeeker struct {
+	io.ReadCloser
+
+	// The underlying ZIP file
+	f *zip.File
+
+	// Current offset
+	offset uint64
+
+	// Told the bytes read, if set
+	progress ProgressFunc
+
+	log logrus.FieldLogger
+}
+
+func (z *ZipReadSeeker) Read(b []byte) (n int, err error) {
+	// Decompressors may return the last bytes along with io.EOF
+	n, err = z.ReadCloser.Read(b)
+	if n > 0 {
+		z.offset += uint64(n)
+
+		if z.progress != nil {
+			z.progress(int64(n))
+		}
+	}
+
+	z.tracef("ZIP READ: %d bytes, new offset %d", n, z.offset)
+
+	return
+}
+
+// Seek to offset within the Zip file.  Implements io.Seeker, but will close and
+// re-open the file if the new offset is before the current offset
+func (z *ZipReadSeeker) Seek(offset int64, whence int) (absOffset int64, err error) {
+	// Calculate the desired absolute offset
+	switch whence {
+	case io.SeekStart:
+		absOffset = offset
+	case io.SeekCurrent:
+		absOffset = int64(z.offset) + offset
+	case io.SeekEnd:
+		absOffset = int64(z.f.FileHeader.UncompressedSize64) + offset
+	default:
+		return 0, errors.New("devportal.ZipReadSeeker.Seek: invalid whence")
+	}
+
+	z.tracef("ZIP SEEK: current: %d, offset: %d, whence: %d, new: %d", z.offset, offset, whence, absOffset)
+
+	// cannot seek before BOF
+	if absOffset < 0 {
+		return 0, errors.New("devportal.ZipReadSeeker.Seek: negative position")
+	}
+
+	// Don't do anything if the position wouldn't change
+	if uint64(absOffset) == z.offset {
+		z.tracef("ZIP SEEK: noop")
+		return int64(z.offset), nil
+	}
+
+	// Re-open the file
+	z.Close()
+	z.ReadCloser, err = z.f.Open()
+	if err != nil {
+		return 0, err
+	}
+
+	// The bytes read so far will be read again
+	if z.progress != nil {
+		z.progress(-int64(z.offset))
+	}
+	z.offset = 0
+
+	// Read a bunch of bytes, but only up to the end of the file
+	nToRead := absOffset
+	if nToRead > int64(z.f.FileHeader.UncompressedSize64) {
+		nToRead = int64(z.f.FileHeader.UncompressedSize64)
+	}
+
+	_, err = io.CopyN(ioutil.Discard, z, nToRead)
+	if err != nil {
+		return 0, err
+	}
+	z.tracef("NEW ZIP OFFSET: %d, %+v", z.offset, err)
+	return absOffset, nil
+}
+
+// Size returns the uncompressed size of the file
+func (z *ZipReadSeeker) Size() int64 {
+	return int64(z.f.UncompressedSize64)
+}
+
+// Log at trace level, which loggers other than a logrus Logger or Entry may
+// not have
+func (z *ZipReadSeeker) tracef(format string, args ...interface{}) {
+	if l, ok := z.log.(interface{ Tracef(string, ...interface{}) }); ok {
+		l.Tracef(format, args...)
+	}
+}
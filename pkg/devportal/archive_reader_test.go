@@ -3,9 +3,13 @@ package devportal
 import (
 	"archive/zip"
 	"bytes"
+	"context"
 	"io"
+	"io/ioutil"
 	"os"
+	"path/filepath"
 	"testing"
+	"time"
 )
 
 const testFile = "/dev/urandom"
@@ -148,6 +152,7 @@ func TestZipReadSeeker(t *testing.T) {
 		zrs := ZipReadSeeker{
 			ReadCloser: rc,
 			f:          zf,
+			log:        nullLogger,
 		}
 
 		testBuf := new(bytes.Buffer)
@@ -195,3 +200,175 @@ func TestZipReadSeeker(t *testing.T) {
 		}
 	}
 }
+
+func TestManifest(t *testing.T) {
+	dir, err := ioutil.TempDir("", "apimtest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	fn := filepath.Join(dir, "manifest.zip")
+
+	want := Manifest{
+		CodeVersion: "20200925173036",
+		Version:     "0.14.1072.0",
+		ToolVersion: "dev",
+		Created:     time.Date(2020, 10, 6, 21, 11, 0, 0, time.UTC),
+	}
+
+	aw, err := NewArchiveWriter(fn, WriterOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := aw.AddManifest(want); err != nil {
+		t.Fatal(err)
+	}
+	if err := aw.AddContentItems([]byte("[]")); err != nil {
+		t.Fatal(err)
+	}
+	if err := aw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	ar, err := NewArchiveReader(fn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ar.Close()
+
+	got, err := ar.Manifest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got == nil {
+		t.Fatal("Expected a manifest, got nil")
+	}
+	if *got != want {
+		t.Errorf("Got %+v, wanted %+v", *got, want)
+	}
+
+	// The manifest must not be handed to the blob handler
+	var blobs []string
+	ar = ar.WithBlobHandler(func(name string, f ZipReadSeeker) error {
+		blobs = append(blobs, name)
+		return nil
+	})
+	if err := ar.Process(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if len(blobs) != 0 {
+		t.Errorf("Expected no blobs, got %v", blobs)
+	}
+}
+
+func TestProcessCancelled(t *testing.T) {
+	dir, err := ioutil.TempDir("", "apimtest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	fn := filepath.Join(dir, "cancel.zip")
+
+	aw, err := NewArchiveWriter(fn, WriterOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := aw.AddContentItems([]byte("[]")); err != nil {
+		t.Fatal(err)
+	}
+	if err := aw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// A second Close is harmless
+	if err := aw.Close(); err != nil {
+		t.Errorf("Second Close failed: %s", err)
+	}
+
+	ar, err := NewArchiveReader(fn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ar.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	var indexed bool
+	ar = ar.WithIndexHandler(func(f ZipReadSeeker) error {
+		indexed = true
+		return nil
+	})
+	if err := ar.Process(ctx); err != context.Canceled {
+		t.Errorf("Expected context.Canceled, got %v", err)
+	}
+	if indexed {
+		t.Errorf("Index handled after cancellation")
+	}
+}
+
+func TestProcessProgress(t *testing.T) {
+	dir, err := ioutil.TempDir("", "apimtest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	fn := filepath.Join(dir, "progress.zip")
+
+	aw, err := NewArchiveWriter(fn, WriterOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := aw.AddContentItems([]byte("[]")); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"blob1", "blob2"} {
+		if err := aw.writeFile(JournalBlob, name, time.Now(), bytes.NewReader(make([]byte, 5000))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := aw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	ar, err := NewArchiveReader(fn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ar.Close()
+
+	if count, size := ar.BlobTotals(); count != 2 || size != 10000 {
+		t.Errorf("BlobTotals = %d, %d, want 2, 10000", count, size)
+	}
+
+	var read int64
+	ar = ar.WithIndexHandler(func(f ZipReadSeeker) error {
+		_, err := ioutil.ReadAll(&f)
+		return err
+	}).WithBlobHandler(func(name string, f ZipReadSeeker) error {
+		// A retried upload reads the Blob again
+		if _, err := ioutil.ReadAll(&f); err != nil {
+			return err
+		}
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		_, err := ioutil.ReadAll(&f)
+		return err
+	}).WithProgress(func(n int64) {
+		read += n
+	})
+
+	if err := ar.Process(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	// Bytes read again after a seek are only counted once, and the index
+	// is not counted
+	if read != 10000 {
+		t.Errorf("Progress reported %d bytes, want 10000", read)
+	}
+}
@@ -0,0 +1,268 @@
+package devportal
+
+import (
+	"archive/zip"
+	"encoding/binary"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"os"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+/*
+ *  Resumes an interrupted download.  The archive may not have been closed,
+ *  eg. if the process was killed, in which case it has no central directory
+ *  and the Blobs are recovered by walking the local file headers, using the
+ *  sizes and checksums recorded in the journal.
+ */
+
+// Suffix of an archive moved aside while a resumed download rebuilds it
+const resumeSuffix = ".resume"
+
+// Zip format constants, see https://pkware.cachefly.net/webdocs/casestudies/APPNOTE.TXT
+const (
+	localHeaderSig    = 0x04034b50
+	localHeaderLen    = 30
+	dataDescriptorSig = 0x08074b50
+	extTimeExtraID    = 0x5455
+	uint32max         = (1 << 32) - 1
+)
+
+// A Blob carried over from an earlier download
+type recoveredBlob struct {
+	name     string
+	modified time.Time
+	open     func() (io.ReadCloser, error)
+}
+
+// ResumeArchiveWriter returns an ArchiveWriter that records the Blobs it
+// writes in j, so that the download can be resumed.  If j holds Blobs from an
+// earlier download, the archive is rebuilt with the Blobs that can be
+// recovered from the existing file and the rest are dropped from the journal.
+// Otherwise it behaves like NewArchiveWriter.
+//
+// The rebuilt archive replaces the existing file whatever the overwrite
+// policy in opts.  Blobs can only be recovered from an archive that was not
+// closed if it was written uncompressed.
+func ResumeArchiveWriter(filename string, j *Journal, opts WriterOptions) (*ArchiveWriter, error) {
+	if !j.Resumed() {
+		a, err := NewArchiveWriter(filename, opts)
+		if err != nil {
+			return nil, err
+		}
+
+		a.journal = j
+		return a, nil
+	}
+
+	// Move the partial archive aside and rebuild it, unless an earlier resume
+	// was itself interrupted while doing so
+	aside := filename + resumeSuffix
+	if _, err := os.Stat(aside); os.IsNotExist(err) {
+		if err := os.Rename(filename, aside); err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+	}
+
+	var blobs []recoveredBlob
+
+	src, err := os.Open(aside)
+	switch {
+	case err == nil:
+		defer src.Close()
+
+		blobs, err = recoverBlobs(src, j, opts.logger())
+		if err != nil {
+			return nil, err
+		}
+	case !os.IsNotExist(err):
+		return nil, err
+	}
+
+	opts.Overwrite = OverwriteAlways
+	a, err := NewArchiveWriter(filename, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	recovered := make(map[string]bool)
+	for _, b := range blobs {
+		rc, err := b.open()
+		if err != nil {
+			a.Close()
+			return nil, err
+		}
+
+		err = a.writeFile(JournalBlob, b.name, b.modified, rc)
+		rc.Close()
+		if err != nil {
+			a.Close()
+			return nil, err
+		}
+
+		recovered[b.name] = true
+	}
+
+	// Only the recovered Blobs are done; the other files are written again
+	journalled := len(j.Entries(JournalBlob))
+	if err := a.writer.Flush(); err != nil {
+		a.Close()
+		return nil, err
+	}
+
+	if err := j.Retain(func(e JournalEntry) bool { return e.Kind == JournalBlob && recovered[e.Name] }); err != nil {
+		a.Close()
+		return nil, err
+	}
+
+	if src != nil {
+		src.Close()
+		if err := os.Remove(aside); err != nil {
+			a.log.WithError(err).Warnf("Removing %s", aside)
+		}
+	}
+
+	a.log.Infof("Resuming download, %d of %d media blobs recovered from %s",
+		len(recovered), journalled, filename)
+
+	a.journal = j
+	return a, nil
+}
+
+// Return the Blobs recorded in the journal that are intact in the archive
+func recoverBlobs(src *os.File, j *Journal, log logrus.FieldLogger) ([]recoveredBlob, error) {
+	fi, err := src.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	// An archive that was closed can be read normally
+	if zr, err := zip.NewReader(src, fi.Size()); err == nil {
+		files := make(map[string]*zip.File)
+		for _, f := range zr.File {
+			files[f.Name] = f
+		}
+
+		var out []recoveredBlob
+		for _, e := range j.Entries(JournalBlob) {
+			f, ok := files[e.Name]
+			if ok && f.CRC32 == e.CRC32 && f.UncompressedSize64 == e.Size {
+				out = append(out, recoveredBlob{e.Name, f.Modified, f.Open})
+			}
+		}
+
+		return out, nil
+	}
+
+	log.Warnf("Archive was not closed, recovering media blobs")
+
+	return scanBlobs(src, j), nil
+}
+
+// Walk the local file headers of an archive that was not closed, returning
+// the Blobs recorded in the journal up to the first file that is incomplete
+// or unknown
+func scanBlobs(src *os.File, j *Journal) []recoveredBlob {
+	entries := make(map[string]JournalEntry)
+	for _, kind := range []string{JournalBlob, journalFile} {
+		for _, e := range j.Entries(kind) {
+			entries[e.Name] = e
+		}
+	}
+
+	var out []recoveredBlob
+	var offset int64
+
+	for {
+		var hdr [localHeaderLen]byte
+		if _, err := src.ReadAt(hdr[:], offset); err != nil || le32(hdr[0:]) != localHeaderSig {
+			break
+		}
+
+		flags := le16(hdr[6:])
+		method := le16(hdr[8:])
+		nameLen := int64(le16(hdr[26:]))
+		extraLen := int64(le16(hdr[28:]))
+
+		buf := make([]byte, nameLen+extraLen)
+		if _, err := src.ReadAt(buf, offset+localHeaderLen); err != nil {
+			break
+		}
+		name := string(buf[:nameLen])
+
+		e, ok := entries[name]
+		if !ok || method != zip.Store {
+			break
+		}
+
+		// Check the contents against the journal
+		dataOffset := offset + localHeaderLen + nameLen + extraLen
+		size := int64(e.Size)
+
+		crc := crc32.NewIEEE()
+		n, err := io.Copy(crc, io.NewSectionReader(src, dataOffset, size))
+		if err != nil || n != size || crc.Sum32() != e.CRC32 {
+			break
+		}
+
+		if e.Kind == JournalBlob {
+			out = append(out, recoveredBlob{
+				name:     name,
+				modified: headerModTime(hdr[:], buf[nameLen:]),
+				open: func() (io.ReadCloser, error) {
+					return ioutil.NopCloser(io.NewSectionReader(src, dataOffset, size)), nil
+				},
+			})
+		}
+
+		offset = dataOffset + size
+
+		// Skip the data descriptor.  The last file written has none.
+		if flags&0x8 != 0 {
+			var sig [4]byte
+			if _, err := src.ReadAt(sig[:], offset); err != nil || le32(sig[:]) != dataDescriptorSig {
+				break
+			}
+
+			if e.Size >= uint32max {
+				offset += 24
+			} else {
+				offset += 16
+			}
+		}
+	}
+
+	return out
+}
+
+// The modification time from a local file header, preferring the extended
+// timestamp in the extra field to the MS-DOS time
+func headerModTime(hdr, extra []byte) time.Time {
+	for len(extra) >= 4 {
+		id, size := le16(extra), int(le16(extra[2:]))
+		if len(extra) < 4+size {
+			break
+		}
+
+		if id == extTimeExtraID && size >= 5 && extra[4]&1 != 0 {
+			return time.Unix(int64(le32(extra[5:])), 0)
+		}
+
+		extra = extra[4+size:]
+	}
+
+	t, d := le16(hdr[10:]), le16(hdr[12:])
+	return time.Date(1980+int(d>>9), time.Month((d>>5)&0xf), int(d&0x1f),
+		int(t>>11), int((t>>5)&0x3f), int(t&0x1f)*2, 0, time.UTC)
+}
+
+func le16(b []byte) uint16 {
+	return binary.LittleEndian.Uint16(b)
+}
+
+func le32(b []byte) uint32 {
+	return binary.LittleEndian.Uint32(b)
+}
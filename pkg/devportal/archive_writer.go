@@ -0,0 +1,286 @@
+package devportal
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/flate"
+	"context"
+	"encoding/json"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"time"
+
+	"github.com/Azure/azure-storage-blob-go/azblob"
+	"github.com/sirupsen/logrus"
+)
+
+// OverwritePolicy decides whether an existing archive file is replaced
+type OverwritePolicy int
+
+const (
+	// OverwriteNever refuses to replace an existing file
+	OverwriteNever OverwritePolicy = iota
+
+	// OverwriteAlways truncates an existing file
+	OverwriteAlways
+)
+
+// WriterOptions configure an ArchiveWriter.  The zero value writes an
+// uncompressed archive without a comment, and refuses to replace an existing
+// archive file.
+type WriterOptions struct {
+	// Whether an existing archive file is replaced.  Not used when writing
+	// to an io.Writer or io.WriterAt.
+	Overwrite OverwritePolicy
+
+	// Compression level of the files in the archive, from flate.BestSpeed
+	// to flate.BestCompression, or flate.DefaultCompression.  With
+	// flate.NoCompression (0) the files are stored uncompressed.
+	Compression int
+
+	// Comment recorded in the archive
+	Comment string
+
+	// Receives the log entries of the writer, which are discarded if nil
+	Logger logrus.FieldLogger
+}
+
+func (o WriterOptions) logger() logrus.FieldLogger {
+	if o.Logger == nil {
+		return nullLogger
+	}
+	return o.Logger
+}
+
+// ArchiveWriter writes a Zip archive file by processing Blobs from
+// an Azure Storage account, and an index (content items)
+type ArchiveWriter struct {
+	writer     *zip.Writer
+	fileHandle *os.File
+	closed     bool
+
+	// Compression method of the files written
+	method uint16
+
+	// Records the Blobs written, if the download can be resumed
+	journal *Journal
+
+	// Told the bytes of Blob content written, if set
+	progress ProgressFunc
+
+	log logrus.FieldLogger
+}
+
+// NewArchiveWriter returns a new ArchiveWriter ready to write
+// Blobs and an index to the supplied file.  If the file exists and
+// opts does not allow it to be overwritten, the error satisfies
+// os.IsExist.
+//
+// Caller MUST run Close() on the ArchiveWriter or data will be lost
+//
+func NewArchiveWriter(filename string, opts WriterOptions) (*ArchiveWriter, error) {
+	openFlags := os.O_RDWR | os.O_CREATE
+	switch opts.Overwrite {
+	case OverwriteNever:
+		openFlags |= os.O_EXCL
+	case OverwriteAlways:
+		openFlags |= os.O_TRUNC
+	default:
+		return nil, fmt.Errorf("bad overwrite policy %d", opts.Overwrite)
+	}
+
+	fh, err := os.OpenFile(filename, openFlags, 0666)
+	if err != nil {
+		return nil, err
+	}
+
+	a, err := newArchiveWriter(fh, opts)
+	if err != nil {
+		fh.Close()
+		return nil, err
+	}
+
+	a.fileHandle = fh
+	return a, nil
+}
+
+// NewArchiveWriterTo returns a new ArchiveWriter ready to write Blobs and
+// an index to w, eg. stdout.  Close finishes the archive but does not close
+// w.
+func NewArchiveWriterTo(w io.Writer, opts WriterOptions) (*ArchiveWriter, error) {
+	return newArchiveWriter(w, opts)
+}
+
+// NewArchiveWriterAt returns a new ArchiveWriter ready to write Blobs and
+// an index to w from offset 0.  Close finishes the archive but does not
+// close w.
+func NewArchiveWriterAt(w io.WriterAt, opts WriterOptions) (*ArchiveWriter, error) {
+	return newArchiveWriter(&offsetWriter{w: w}, opts)
+}
+
+func newArchiveWriter(w io.Writer, opts WriterOptions) (*ArchiveWriter, error) {
+	a := &ArchiveWriter{writer: zip.NewWriter(w), log: opts.logger()}
+
+	if err := a.writer.SetComment(opts.Comment); err != nil {
+		return nil, err
+	}
+
+	switch level := opts.Compression; {
+	case level == flate.NoCompression:
+		a.method = zip.Store
+	case level < flate.HuffmanOnly || level > flate.BestCompression:
+		return nil, fmt.Errorf("bad compression level %d", level)
+	default:
+		a.method = zip.Deflate
+		a.writer.RegisterCompressor(zip.Deflate, func(out io.Writer) (io.WriteCloser, error) {
+			return flate.NewWriter(out, level)
+		})
+	}
+
+	return a, nil
+}
+
+// AddBlob copies the Blob from the supplied Azure storage account URL
+// to the underlying archive.  Nothing is written if ctx is already done,
+// but a copy that has started is completed so that the archive never holds
+// part of a Blob.
+func (a *ArchiveWriter) AddBlob(ctx context.Context, url azblob.BlobURL) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	// Initiate the Blob download, retrieve some metadata
+	dlResponse, err := url.Download(context.Background(), 0, 0, azblob.BlobAccessConditions{}, false)
+	if err != nil {
+		return err
+	}
+
+	// Copy the Blob contents to the ZIP
+	parts := azblob.NewBlobURLParts(url.URL())
+	reader := dlResponse.Body(azblob.RetryReaderOptions{})
+	defer reader.Close()
+
+	a.log.Debugf("Downloading %s, %d bytes", parts.BlobName, dlResponse.ContentLength())
+
+	var r io.Reader = reader
+	if a.progress != nil {
+		r = &progressReader{reader, a.progress}
+	}
+
+	return a.writeFile(JournalBlob, parts.BlobName, dlResponse.LastModified(), r)
+}
+
+// WithProgress configures a callback that AddBlob tells the bytes of each
+// Blob written
+func (a *ArchiveWriter) WithProgress(p ProgressFunc) *ArchiveWriter {
+	a.progress = p
+	return a
+}
+
+// HasBlob reports whether the Blob was written by an earlier run of a
+// resumed download
+func (a *ArchiveWriter) HasBlob(name string) bool {
+	return a.journal != nil && a.journal.Done(JournalBlob, name)
+}
+
+// Write a file to the archive and record it in the journal
+func (a *ArchiveWriter) writeFile(kind, name string, modified time.Time, r io.Reader) error {
+	// Zip header for this file
+	header := zip.FileHeader{
+		Name:     name,
+		Modified: modified,
+		Method:   a.method,
+	}
+
+	// Write the ZIP header and get a handle to write the contents
+	writer, err := a.writer.CreateHeader(&header)
+	if err != nil {
+		return err
+	}
+
+	crc := crc32.NewIEEE()
+	n, err := io.Copy(io.MultiWriter(writer, crc), r)
+	if err != nil {
+		return err
+	}
+
+	a.log.Debugf("Wrote %s to ZIP, %d bytes", name, n)
+
+	if a.journal == nil {
+		return nil
+	}
+
+	// The file must be on disk before it is recorded as done
+	if err := a.writer.Flush(); err != nil {
+		return err
+	}
+
+	return a.journal.Record(JournalEntry{Kind: kind, Name: name, Size: uint64(n), CRC32: crc.Sum32()})
+}
+
+// AddContentItems writes the content items (index) JSON to the archive
+// as data.json
+func (a *ArchiveWriter) AddContentItems(data []byte) error {
+	return a.writeFile(journalFile, IndexName, time.Now(), bytes.NewReader(data))
+}
+
+// AddManifest writes the manifest describing the source portal to the
+// archive as manifest.json
+func (a *ArchiveWriter) AddManifest(m Manifest) error {
+	data, err := json.MarshalIndent(m, "", "    ")
+	if err != nil {
+		return err
+	}
+
+	return a.writeFile(journalFile, ManifestName, time.Now(), bytes.NewReader(data))
+}
+
+// Close closes the Zip archive, and MUST be called to prevent data loss.
+// Calling Close again has no effect, so it may be deferred as well as
+// called explicitly.
+func (a *ArchiveWriter) Close() error {
+	if a.closed {
+		return nil
+	}
+	a.closed = true
+
+	// Close the file even if the Zip directory can't be written
+	err := a.writer.Close()
+	if a.fileHandle == nil {
+		return err
+	}
+
+	if ferr := a.fileHandle.Close(); err == nil {
+		err = ferr
+	}
+
+	return err
+}
+
+// Reports the bytes read to a ProgressFunc
+type progressReader struct {
+	r        io.Reader
+	progress ProgressFunc
+}
+
+func (p *progressReader) Read(b []byte) (int, error) {
+	n, err := p.r.Read(b)
+	p.progress(int64(n))
+
+	return n, err
+}
+
+// Writes sequentially to an io.WriterAt
+type offsetWriter struct {
+	w      io.WriterAt
+	offset int64
+}
+
+func (o *offsetWriter) Write(b []byte) (int, error) {
+	n, err := o.w.WriteAt(b, o.offset)
+	o.offset += int64(n)
+
+	return n, err
+}
@@ -0,0 +1,164 @@
+package devportal
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/flate"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestArchiveWriterOptions(t *testing.T) {
+	tests := []struct {
+		name   string
+		opts   WriterOptions
+		method uint16
+	}{
+		{"stored", WriterOptions{}, zip.Store},
+		{"default compression", WriterOptions{Compression: flate.DefaultCompression}, zip.Deflate},
+		{"best compression", WriterOptions{Compression: flate.BestCompression, Comment: "portal backup"}, zip.Deflate},
+	}
+
+	blob := bytes.Repeat([]byte("media "), 2000)
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+
+			aw, err := NewArchiveWriterTo(&buf, tt.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := aw.AddContentItems([]byte("[]")); err != nil {
+				t.Fatal(err)
+			}
+			if err := aw.writeFile(JournalBlob, "blob1", time.Now(), bytes.NewReader(blob)); err != nil {
+				t.Fatal(err)
+			}
+			if err := aw.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if zr.Comment != tt.opts.Comment {
+				t.Errorf("Got comment %q, wanted %q", zr.Comment, tt.opts.Comment)
+			}
+			for _, f := range zr.File {
+				if f.Method != tt.method {
+					t.Errorf("%s: got method %d, wanted %d", f.Name, f.Method, tt.method)
+				}
+			}
+
+			// Read the archive back from memory
+			ar, err := NewArchiveReaderAt(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer ar.Close()
+
+			var got []byte
+			ar = ar.WithBlobHandler(func(name string, f ZipReadSeeker) (err error) {
+				got, err = ioutil.ReadAll(&f)
+				return err
+			})
+			if err := ar.Process(context.Background()); err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, blob) {
+				t.Errorf("Blob read back differs from that written")
+			}
+		})
+	}
+
+	if _, err := NewArchiveWriterTo(ioutil.Discard, WriterOptions{Compression: 10}); err == nil {
+		t.Errorf("Expected an error with compression level 10")
+	}
+}
+
+func TestArchiveWriterOverwrite(t *testing.T) {
+	dir, err := ioutil.TempDir("", "apimtest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	fn := filepath.Join(dir, "exists.zip")
+	if err := ioutil.WriteFile(fn, []byte("old"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := NewArchiveWriter(fn, WriterOptions{}); !os.IsExist(err) {
+		t.Fatalf("Expected an exists error, got %v", err)
+	}
+
+	aw, err := NewArchiveWriter(fn, WriterOptions{Overwrite: OverwriteAlways})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := aw.AddContentItems([]byte("[]")); err != nil {
+		t.Fatal(err)
+	}
+	if err := aw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	ar, err := NewArchiveReader(fn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ar.Close()
+}
+
+func TestArchiveWriterAt(t *testing.T) {
+	dir, err := ioutil.TempDir("", "apimtest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	fh, err := os.Create(filepath.Join(dir, "at.zip"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fh.Close()
+
+	aw, err := NewArchiveWriterAt(fh, WriterOptions{Compression: flate.BestSpeed})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := aw.AddContentItems([]byte("[]")); err != nil {
+		t.Fatal(err)
+	}
+	if err := aw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	fi, err := fh.Stat()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ar, err := NewArchiveReaderAt(fh, fi.Size())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var indexed bool
+	ar = ar.WithIndexHandler(func(f ZipReadSeeker) error {
+		indexed = true
+		return nil
+	})
+	if err := ar.Process(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if !indexed {
+		t.Errorf("Index not found in archive")
+	}
+}
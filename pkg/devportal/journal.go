@@ -0,0 +1,253 @@
+package devportal
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+)
+
+// Kinds of journal entry
+const (
+	// A Blob written to or uploaded from an archive
+	JournalBlob = "blob"
+
+	// A content item uploaded from an archive
+	JournalContentItem = "content-item"
+
+	// Another file written to an archive, recorded so that the Blobs after it
+	// can be recovered
+	journalFile = "file"
+
+	// The instance the journal applies to, always the first entry
+	journalTarget = "target"
+)
+
+// JournalEntry records one completed item
+type JournalEntry struct {
+	Kind string `json:"kind"`
+	Name string `json:"name"`
+
+	// Size and checksum of Blobs written to an archive, used to recover an
+	// archive that was not closed
+	Size  uint64 `json:"size,omitempty"`
+	CRC32 uint32 `json:"crc32,omitempty"`
+}
+
+// Journal records the items completed by a download or upload, one JSON
+// entry per line, so that an interrupted run can be resumed without
+// repeating them
+type Journal struct {
+	path    string
+	target  string
+	file    *os.File
+	entries []JournalEntry
+	done    map[string]bool
+}
+
+// JournalName returns the name of the journal for an operation (download or
+// upload) on an archive, which is kept next to the archive
+func JournalName(archive, op string) string {
+	return fmt.Sprintf("%s.%s.journal", archive, op)
+}
+
+// OpenJournal opens the journal at path for the target instance.  With
+// resume, the entries of an earlier run are loaded, otherwise the journal is
+// started afresh.  Resuming a journal written for a different target is an
+// error.
+func OpenJournal(path, target string, resume bool) (*Journal, error) {
+	j := &Journal{
+		path:   path,
+		target: target,
+		done:   make(map[string]bool),
+	}
+
+	flags := os.O_WRONLY | os.O_CREATE | os.O_APPEND
+	if resume {
+		if err := j.load(); err != nil {
+			return nil, err
+		}
+	} else {
+		flags |= os.O_TRUNC
+	}
+
+	f, err := os.OpenFile(path, flags, 0600)
+	if err != nil {
+		return nil, err
+	}
+	j.file = f
+
+	if !j.Resumed() {
+		if err := j.write(JournalEntry{Kind: journalTarget, Name: target}); err != nil {
+			f.Close()
+			return nil, err
+		}
+	}
+
+	return j, nil
+}
+
+// Load the entries of an earlier run.  A partly written last line, left by a
+// crash, is discarded.
+func (j *Journal) load() error {
+	data, err := ioutil.ReadFile(j.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var valid int64
+	for len(data) > 0 {
+		i := bytes.IndexByte(data, '\n')
+		if i < 0 {
+			break
+		}
+
+		var e JournalEntry
+		if err := json.Unmarshal(data[:i], &e); err != nil {
+			return fmt.Errorf("corrupt journal %s: %s", j.path, err)
+		}
+
+		if valid == 0 {
+			if e.Kind != journalTarget {
+				return fmt.Errorf("corrupt journal %s: no target", j.path)
+			}
+			if e.Name != j.target {
+				return fmt.Errorf("journal %s is for %s, not %s", j.path, e.Name, j.target)
+			}
+		} else {
+			j.add(e)
+		}
+
+		valid += int64(i + 1)
+		data = data[i+1:]
+	}
+
+	if valid == 0 {
+		return nil
+	}
+
+	// Later entries are appended after the last complete line
+	if err := os.Truncate(j.path, valid); err != nil {
+		return err
+	}
+
+	// The target entry is already in the file
+	j.done[journalKey(journalTarget, j.target)] = true
+
+	return nil
+}
+
+// Resumed reports whether the journal was carried over from an earlier run
+func (j *Journal) Resumed() bool {
+	return j.done[journalKey(journalTarget, j.target)]
+}
+
+// Done reports whether the item has been completed
+func (j *Journal) Done(kind, name string) bool {
+	return j.done[journalKey(kind, name)]
+}
+
+// Entries returns the completed items of a kind, in the order they were
+// recorded
+func (j *Journal) Entries(kind string) []JournalEntry {
+	var out []JournalEntry
+	for _, e := range j.entries {
+		if e.Kind == kind {
+			out = append(out, e)
+		}
+	}
+
+	return out
+}
+
+// Record notes that an item has been completed.  The entry is synced to disk
+// before Record returns.
+func (j *Journal) Record(e JournalEntry) error {
+	if err := j.write(e); err != nil {
+		return err
+	}
+
+	j.add(e)
+	return nil
+}
+
+// Retain rewrites the journal with only the entries for which keep returns
+// true, eg. to drop Blobs that could not be recovered from an archive
+func (j *Journal) Retain(keep func(JournalEntry) bool) error {
+	var kept []JournalEntry
+	for _, e := range j.entries {
+		if keep(e) {
+			kept = append(kept, e)
+		}
+	}
+
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for _, e := range append([]JournalEntry{{Kind: journalTarget, Name: j.target}}, kept...) {
+		if err := enc.Encode(e); err != nil {
+			return err
+		}
+	}
+
+	// Replace the journal in one step so a crash leaves the old or new one
+	tmp := j.path + ".tmp"
+	if err := ioutil.WriteFile(tmp, buf.Bytes(), 0600); err != nil {
+		return err
+	}
+
+	j.file.Close()
+	if err := os.Rename(tmp, j.path); err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(j.path, os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	j.file = f
+
+	j.entries = nil
+	j.done = map[string]bool{journalKey(journalTarget, j.target): true}
+	for _, e := range kept {
+		j.add(e)
+	}
+
+	return nil
+}
+
+// Close the journal, keeping it so a later run can resume
+func (j *Journal) Close() error {
+	return j.file.Close()
+}
+
+// Remove closes and deletes the journal once the operation is complete
+func (j *Journal) Remove() error {
+	j.file.Close()
+	return os.Remove(j.path)
+}
+
+func (j *Journal) add(e JournalEntry) {
+	j.entries = append(j.entries, e)
+	j.done[journalKey(e.Kind, e.Name)] = true
+}
+
+func (j *Journal) write(e JournalEntry) error {
+	line, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	if _, err := j.file.Write(append(line, '\n')); err != nil {
+		return err
+	}
+
+	return j.file.Sync()
+}
+
+func journalKey(kind, name string) string {
+	return kind + "\x00" + name
+}
@@ -0,0 +1,238 @@
+package devportal
+
+import (
+	"archive/zip"
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testTarget = "https://myapim.management.azure-api.net"
+
+func TestJournal(t *testing.T) {
+	dir, err := ioutil.TempDir("", "journal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := JournalName(filepath.Join(dir, "apim.zip"), "upload")
+
+	j, err := OpenJournal(path, testTarget, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if j.Resumed() {
+		t.Errorf("New journal reports it was resumed")
+	}
+
+	for _, name := range []string{"/contentTypes/page/contentItems/a", "/contentTypes/page/contentItems/b"} {
+		if err := j.Record(JournalEntry{Kind: JournalContentItem, Name: name}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	j.Close()
+
+	// Simulate a crash part way through writing an entry
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString(`{"kind":"blob","na`)
+	f.Close()
+
+	j, err = OpenJournal(path, testTarget, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !j.Resumed() {
+		t.Errorf("Journal was not resumed")
+	}
+	if !j.Done(JournalContentItem, "/contentTypes/page/contentItems/b") || j.Done(JournalBlob, "/contentTypes/page/contentItems/b") {
+		t.Errorf("Bad entries after resume: %+v", j.Entries(JournalContentItem))
+	}
+
+	// New entries follow the last complete line
+	if err := j.Record(JournalEntry{Kind: JournalBlob, Name: "c"}); err != nil {
+		t.Fatal(err)
+	}
+	j.Close()
+
+	j, err = OpenJournal(path, testTarget, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(j.Entries(JournalContentItem)) != 2 || !j.Done(JournalBlob, "c") {
+		t.Errorf("Entries lost after a partial line: %+v", j.entries)
+	}
+
+	// Keep only the blob
+	if err := j.Retain(func(e JournalEntry) bool { return e.Kind == JournalBlob }); err != nil {
+		t.Fatal(err)
+	}
+	j.Close()
+
+	j, err = OpenJournal(path, testTarget, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(j.Entries(JournalContentItem)) != 0 || !j.Done(JournalBlob, "c") {
+		t.Errorf("Bad entries after Retain: %+v", j.entries)
+	}
+	j.Close()
+
+	// A journal for another instance cannot be resumed
+	if _, err := OpenJournal(path, "https://other.management.azure-api.net", true); err == nil {
+		t.Errorf("Resumed a journal for another instance")
+	}
+
+	// Without resume the journal starts afresh
+	j, err = OpenJournal(path, testTarget, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if j.Resumed() || len(j.entries) != 0 {
+		t.Errorf("Journal not started afresh: %+v", j.entries)
+	}
+
+	if err := j.Remove(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("Journal not removed: %v", err)
+	}
+}
+
+func TestResumeArchiveWriter(t *testing.T) {
+	blobs := map[string][]byte{
+		"blob1": bytes.Repeat([]byte("1"), 1000),
+		"blob2": bytes.Repeat([]byte("2"), 2000),
+		"blob3": bytes.Repeat([]byte("3"), 3000),
+	}
+
+	tests := []struct {
+		name   string
+		closed bool
+	}{
+		{"closed archive", true},
+		{"unclosed archive", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "resume")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+
+			archive := filepath.Join(dir, "apim.zip")
+			journal := JournalName(archive, "download")
+
+			// A download that wrote two blobs before stopping
+			j, err := OpenJournal(journal, testTarget, false)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			a, err := ResumeArchiveWriter(archive, j, WriterOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if err := a.AddManifest(Manifest{ToolVersion: "test"}); err != nil {
+				t.Fatal(err)
+			}
+			for _, name := range []string{"blob1", "blob2"} {
+				if err := a.writeFile(JournalBlob, name, time.Now(), bytes.NewReader(blobs[name])); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			if tt.closed {
+				a.Close()
+			} else {
+				// Leave the file as a killed process would, with no central
+				// directory
+				a.fileHandle.Close()
+			}
+			j.Close()
+
+			// Resume it
+			j, err = OpenJournal(journal, testTarget, true)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			a, err = ResumeArchiveWriter(archive, j, WriterOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for _, name := range []string{"blob1", "blob2"} {
+				if !a.HasBlob(name) {
+					t.Errorf("%s not recovered", name)
+				}
+			}
+			if a.HasBlob("blob3") {
+				t.Errorf("blob3 reported as recovered")
+			}
+
+			if err := a.AddManifest(Manifest{ToolVersion: "test"}); err != nil {
+				t.Fatal(err)
+			}
+			if err := a.AddContentItems([]byte("[]")); err != nil {
+				t.Fatal(err)
+			}
+			if err := a.writeFile(JournalBlob, "blob3", time.Now(), bytes.NewReader(blobs["blob3"])); err != nil {
+				t.Fatal(err)
+			}
+			if err := a.Close(); err != nil {
+				t.Fatal(err)
+			}
+			j.Close()
+
+			if _, err := os.Stat(archive + resumeSuffix); !os.IsNotExist(err) {
+				t.Errorf("Partial archive not removed: %v", err)
+			}
+
+			// The archive has each file once, with the right contents
+			zr, err := zip.OpenReader(archive)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer zr.Close()
+
+			var names []string
+			for _, f := range zr.File {
+				names = append(names, f.Name)
+
+				want, ok := blobs[f.Name]
+				if !ok {
+					continue
+				}
+
+				rc, err := f.Open()
+				if err != nil {
+					t.Fatal(err)
+				}
+				got, err := ioutil.ReadAll(rc)
+				rc.Close()
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !bytes.Equal(got, want) {
+					t.Errorf("%s has the wrong contents", f.Name)
+				}
+			}
+
+			if got, want := strings.Join(names, ","), "blob1,blob2,manifest.json,data.json,blob3"; got != want {
+				t.Errorf("Archive holds %s, want %s", got, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,16 @@
+package devportal
+
+import (
+	"io/ioutil"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Receives the log entries of readers and writers that are not given a logger
+var nullLogger logrus.FieldLogger = newNullLogger()
+
+func newNullLogger() *logrus.Logger {
+	l := logrus.New()
+	l.Out = ioutil.Discard
+	return l
+}
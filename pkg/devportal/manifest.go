@@ -0,0 +1,28 @@
+package devportal
+
+import (
+	"time"
+)
+
+// ManifestName is the name of the manifest file within the archive
+const ManifestName = "manifest.json"
+
+// IndexName is the name of the content items index within the archive
+const IndexName = "data.json"
+
+// Manifest records details of the developer portal an archive was
+// taken from, so that an upload can check that the target portal is able
+// to render the content
+type Manifest struct {
+	// Developer portal code version of the source portal, eg. 20200925173036
+	CodeVersion string `json:"code_version"`
+
+	// Developer portal version of the source portal, eg. 0.14.1072.0
+	Version string `json:"version"`
+
+	// Version of apim-tools that created the archive
+	ToolVersion string `json:"tool_version"`
+
+	// When the archive was created
+	Created time.Time `json:"created"`
+}